@@ -14,7 +14,11 @@ func (*Artifact) BuilderId() string {
 	return BuilderId
 }
 
+// Files is the provenance file, when the build wrote one
 func (a *Artifact) Files() []string {
+	if path, ok := a.StateData["provenance_file"].(string); ok && path != "" {
+		return []string{path}
+	}
 	return []string{}
 }
 
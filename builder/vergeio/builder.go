@@ -30,6 +30,16 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	log.Printf("[VergeIO]: Builder configuration - Cluster: %s, VM: %s",
 		b.config.ClusterConfig.Username, b.config.VmConfig.Name)
 
+	// Checkpoints and warm pool entries are named after hashes of the config
+	checkpointPrefix, err := b.config.checkpointPrefix()
+	if err != nil {
+		return nil, err
+	}
+	warmPoolName, err := b.config.warmPoolName()
+	if err != nil {
+		return nil, err
+	}
+
 	// Define the complete build workflow with all provisioning steps
 	steps := []multistep.Step{}
 
@@ -37,20 +47,98 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	// PHASE 1: VM CREATION AND SETUP
 	// ==========================================
 
-	// Step 1: Create the VM with all hardware, disks, and NICs
-	// This step handles the complete VM creation process including error recovery
-	steps = append(steps, &StepVMCreate{
-		ClusterConfig: b.config.ClusterConfig,
-		VmConfig:      b.config.VmConfig,
-	})
+	// Upload the disk images of import_source first, preflight then finds them in the catalog
+	if b.config.importSource != nil {
+		steps = append(steps, &StepImportUpload{
+			Source: b.config.importSource,
+		})
+	}
 
-	// Step 2: Wait for disk imports to complete (if any disks have media="import")
-	// This prevents "Cannot power on a VM while drives are importing" errors
-	steps = append(steps, &StepWaitForDiskImport{
+	// Step 1: Verify referenced keys, permissions and capacity on the live cluster
+	// Catches a missing network, media file or node before anything is created
+	steps = append(steps, &StepPreflight{
 		Config: &b.config,
 	})
 
-	// Step 3: Power on the VM so the guest OS can start
+	// Hash the build inputs, with skip_if_unchanged an up to date template ends the build here
+	steps = append(steps, &StepContentHash{
+		Config:          &b.config,
+		SkipIfUnchanged: b.config.SkipIfUnchanged,
+	})
+
+	// Without a configured SSH key, generate a throw-away key pair for this build.
+	// The public key is authorized through cloud-init and the private key is only
+	// held by the communicator, or written out in -debug mode
+	if b.config.useTemporarySSHKey() {
+		steps = append(steps, &communicator.StepSSHKeyGen{
+			CommConf:            &b.config.Comm,
+			SSHTemporaryKeyPair: b.config.Comm.SSH.SSHTemporaryKeyPair,
+		})
+		if b.config.PackerDebug {
+			steps = append(steps, &communicator.StepDumpSSHKey{
+				Path: fmt.Sprintf("%s.pem", b.config.PackerBuildName),
+				SSH:  &b.config.Comm.SSH,
+			})
+		}
+	}
+
+	// Serve http_directory/http_content so cloud-init can fetch from {{ .HTTPIP }}:{{ .HTTPPort }}
+	if b.config.HTTPDir != "" || len(b.config.HTTPContent) > 0 {
+		steps = append(steps,
+			commonsteps.HTTPServerFromHTTPConfig(&b.config.HTTPConfig),
+			&StepHTTPIPDiscover{
+				HTTPAddress:   b.config.HTTPAddress,
+				ClusterConfig: b.config.ClusterConfig,
+			},
+		)
+	}
+
+	if b.config.attachExisting() {
+		// Build on an existing VM, StepCapture clones it into the template after shutdown
+		steps = append(steps, &StepAttachVM{
+			Name:         b.config.ExistingVMName,
+			Key:          b.config.ExistingVMKey,
+			TemplateName: b.config.VmConfig.Name,
+			Snapshot:     b.config.ExistingVMSnapshot,
+			Timeout:      b.config.ShutdownTimeout,
+		})
+	} else {
+		// Pick up the VM of a failed build at its latest checkpoint, StepVMCreate then skips
+		if b.config.ResumeFromCheckpoint {
+			steps = append(steps, &StepResumeCheckpoint{
+				VmConfig: b.config.VmConfig,
+				Prefix:   checkpointPrefix,
+			})
+		}
+
+		// Clone the VM from a booted copy of an earlier build with the same config, StepVMCreate then skips
+		if b.config.WarmPool {
+			steps = append(steps, &StepWarmPoolClone{
+				VmConfig: b.config.VmConfig,
+				PoolName: warmPoolName,
+				MaxAge:   b.config.WarmPoolMaxAge,
+				Refresh:  b.config.WarmPoolRefresh,
+				Evict:    b.config.WarmPoolEvict,
+			})
+		}
+
+		// Step 2: Create the VM with all hardware, disks, and NICs
+		// This step handles the complete VM creation process including error recovery
+		steps = append(steps, &StepVMCreate{
+			ClusterConfig: b.config.ClusterConfig,
+			VmConfig:      b.config.VmConfig,
+			Comm:          &b.config.Comm,
+			Discard:       b.config.Compact,
+		})
+
+		// Step 3: Wait for disk imports to complete (if any disks have media="import")
+		// This prevents "Cannot power on a VM while drives are importing" errors
+		steps = append(steps, &StepWaitForDiskImport{
+			Config: &b.config,
+		})
+	}
+
+	// Step 4: Power on the VM so the guest OS can start
 	// VMs are created in powered-off state, so this is essential for provisioning
 	powerOnTimeout := b.config.PowerOnTimeout
 	if powerOnTimeout == 0 {
@@ -71,7 +159,7 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	// PHASE 2: NETWORK DISCOVERY AND CONNECTIVITY
 	// ==========================================
 
-	// Step 4: Wait for guest agent to report IP addresses
+	// Step 5: Wait for guest agent to report IP addresses
 	// This step discovers the VM's IP address(es) needed for SSH/WinRM connectivity
 	steps = append(steps, &StepWaitForIP{
 		WaitTimeout:   10 * time.Minute, // Maximum time to wait for IP discovery
@@ -83,7 +171,7 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	// PHASE 3: PROVISIONING
 	// ==========================================
 
-	// Step 5: Connect to the VM via SSH/WinRM
+	// Step 6: Connect to the VM via SSH/WinRM
 	// This uses Packer's standard communicator step to establish connectivity
 	steps = append(steps, &communicator.StepConnect{
 		Config:    &b.config.Comm,
@@ -91,19 +179,90 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		SSHConfig: b.config.Comm.SSHConfigFunc(),
 	})
 
-	// Step 6: Run all configured provisioners
+	// Keep a copy of the booted VM before provisioning, so the next build with this config can start from it
+	if b.config.WarmPool {
+		steps = append(steps, &StepWarmPoolFill{
+			PoolName:    warmPoolName,
+			Description: fmt.Sprintf("Packer warm pool entry, filled by build '%s' of VM '%s'", b.config.PackerBuildName, b.config.VmConfig.Name),
+			OSFamily:    b.config.VmConfig.OSFamily,
+		})
+	}
+
+	// Step 7: Run all configured provisioners
 	// This is where shell scripts, file uploads, Ansible, etc. are executed
-	steps = append(steps, &commonsteps.StepProvision{})
+	// A resumed build skips the ones that ran before the restored checkpoint
+	steps = append(steps, &StepCheckpointProvision{})
 
 	// ==========================================
 	// PHASE 4: CLEANUP AND FINALIZATION
 	// ==========================================
 
-	// Step 7: Gracefully shut down the VM via SSH/WinRM
+	// Remove the temporary key from authorized_keys, so the template does not trust it
+	if b.config.useTemporarySSHKey() {
+		steps = append(steps, &StepCleanupTempKeys{
+			Comm: &b.config.Comm,
+		})
+	}
+
+	// Trim or zero-fill free space so freed blocks are not captured, before generalize powers the guest off
+	if b.config.Compact {
+		steps = append(steps, &StepCompact{
+			OSFamily:    b.config.OSFamily,
+			Username:    b.config.Comm.SSHUsername,
+			SdeletePath: b.config.SdeletePath,
+			Timeout:     b.config.CompactTimeout,
+		})
+	}
+
+	// Reset machine identity (cloud-init, machine-id, host keys) or sysprep, the guest powers itself off
+	if b.config.Generalize {
+		steps = append(steps, &StepGeneralize{
+			OSFamily:        b.config.OSFamily,
+			Username:        b.config.Comm.SSHUsername,
+			SysprepUnattend: b.config.SysprepUnattend,
+			Timeout:         b.config.GeneralizeTimeout,
+			PollInterval:    b.config.ShutdownPollInterval,
+		})
+	}
+
+	// Step 8: Gracefully shut down the VM via SSH/WinRM, or ACPI if no command is set
 	// This ensures the VM is in a clean state and all changes are persisted
 	steps = append(steps, &StepShutdown{
-		Command: b.config.ShutdownCommand, // User-configured shutdown command
-		Timeout: b.config.ShutdownTimeout, // How long to wait for shutdown
+		Command:      b.config.ShutdownCommand,      // User-configured shutdown command
+		Timeout:      b.config.ShutdownTimeout,      // How long to wait for shutdown
+		PollInterval: b.config.ShutdownPollInterval, // How often to check the power state
+	})
+
+	// Take the temporary key back out of the stored user-data, the template must not carry it
+	if b.config.useTemporarySSHKey() && !b.config.attachExisting() {
+		steps = append(steps, &StepRestoreUserData{
+			VmConfig: b.config.VmConfig,
+			Comm:     &b.config.Comm,
+		})
+	}
+
+	// Clone the shut down existing VM into the template, finalization works on the clone
+	if b.config.attachExisting() {
+		steps = append(steps, &StepCapture{
+			TemplateName: b.config.VmConfig.Name,
+		})
+	}
+
+	// Step 9: Strip build-time secrets and build-only devices from the powered-off VM
+	steps = append(steps, &StepFinalize{
+		VmConfig:               b.config.VmConfig,
+		Strip:                  b.config.FinalizeTemplate,
+		TemplateCPUCores:       b.config.TemplateCPUCores,
+		TemplateRAM:            b.config.TemplateRAM,
+		TemplateCloudInitFiles: b.config.TemplateCloudInitFiles,
+		KeepCloudInitFiles:     b.config.KeepCloudInitFiles,
+		CheckpointPrefix:       checkpointPrefix,
+	})
+
+	// Record versions, build name, source images and commit on the template, and in provenance_file
+	steps = append(steps, &StepProvenance{
+		Config:  &b.config,
+		Started: time.Now(),
 	})
 
 	// ==========================================
@@ -125,14 +284,18 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		"os_family": b.config.VmConfig.OSFamily,
 		"cpu_cores": b.config.VmConfig.CPUCores,
 		"ram":       b.config.VmConfig.RAM,
+
+		"CheckpointPrefix":     checkpointPrefix,
+		"CompletedCheckpoints": "",
+		"ContentHash":          "",
 	})
 
 	ui.Message("[VergeIO]: Starting build workflow with the following phases:")
-	ui.Message("  Phase 1: VM Creation (VM + Disks + NICs)")
+	ui.Message("  Phase 1: Preflight Checks and VM Creation (VM + Disks + NICs)")
 	ui.Message("  Phase 2: Disk Import Completion + Power Management")
 	ui.Message("  Phase 3: Network Discovery and Connectivity")
 	ui.Message("  Phase 4: Provisioning via SSH/WinRM")
-	ui.Message("  Phase 5: Cleanup and Shutdown")
+	ui.Message("  Phase 5: Cleanup, Shutdown and Template Finalization")
 
 	// ==========================================
 	// EXECUTION
@@ -153,16 +316,34 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	// SUCCESS - CREATE ARTIFACT
 	// ==========================================
 
+	// StepContentHash found an up to date template, it is the artifact
+	if skipped, _ := state.Get("skipped").(bool); skipped {
+		ui.Message("[VergeIO]: Build skipped, the template is unchanged")
+		return &Artifact{
+			StateData: map[string]interface{}{
+				"generated_data": state.Get("generated_data"),
+				"vm_id":          state.Get("vm_id"),
+				"machine_id":     state.Get("machine_id"),
+				"content_hash":   state.Get("content_hash"),
+				"skipped":        true,
+			},
+		}, nil
+	}
+
 	ui.Message("[VergeIO]: Build completed successfully!")
 
 	// Create the build artifact containing information about the created VM
 	// This can be used by post-processors for further processing
 	artifact := &Artifact{
 		StateData: map[string]interface{}{
-			"generated_data": state.Get("generated_data"),
-			"vm_id":          state.Get("vm_id"),
-			"machine_id":     state.Get("machine_id"),
-			"discovered_ips": state.Get("discovered_ips"),
+			"generated_data":  state.Get("generated_data"),
+			"vm_id":           state.Get("vm_id"),
+			"machine_id":      state.Get("machine_id"),
+			"discovered_ips":  state.Get("discovered_ips"),
+			"drive_usage":     state.Get("drive_usage"),
+			"source_vm_id":    state.Get("source_vm_id"),
+			"content_hash":    state.Get("content_hash"),
+			"provenance_file": state.Get("provenance_file"),
 		},
 	}
 
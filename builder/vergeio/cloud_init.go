@@ -0,0 +1,713 @@
+package vergeio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+	"gopkg.in/yaml.v3"
+)
+
+// cloudConfigHeader is the first line cloud-init requires on a cloud-config user-data document
+const cloudConfigHeader = "#cloud-config"
+
+// Assembly modes for a cloud-init file built from several files
+const (
+	cloudInitAssemblyAuto      = "auto"
+	cloudInitAssemblyMerge     = "merge"
+	cloudInitAssemblyMultipart = "multipart"
+	cloudInitAssemblyConcat    = "concat"
+)
+
+// GetValidCloudInitAssemblies returns the supported values of cloud_init_files.assembly
+func GetValidCloudInitAssemblies() []string {
+	return []string{cloudInitAssemblyAuto, cloudInitAssemblyMerge, cloudInitAssemblyMultipart, cloudInitAssemblyConcat}
+}
+
+// cloudInitPartTypes maps the first line of a user-data part to its MIME type, see
+// https://cloudinit.readthedocs.io/en/latest/explanation/format.html
+var cloudInitPartTypes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#cloud-config-archive", "text/cloud-config-archive"},
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include-once", "text/x-include-once-url"},
+	{"#include", "text/x-include-url"},
+	{"#part-handler", "text/part-handler"},
+	{"## template: jinja", "text/jinja2"},
+	{"#!", "text/x-shellscript"},
+}
+
+// cloudInitPart is one file of a multi-file cloud-init document
+type cloudInitPart struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+// cloudInitContentType detects the MIME type of a user-data part from its first line.
+// Parts without a recognised header are taken to be header-less cloud-config.
+func cloudInitContentType(content string) string {
+	firstLine, _, _ := strings.Cut(strings.TrimLeft(content, "\r\n"), "\n")
+	firstLine = strings.TrimSpace(firstLine)
+	for _, partType := range cloudInitPartTypes {
+		if strings.HasPrefix(firstLine, partType.prefix) {
+			return partType.contentType
+		}
+	}
+	return "text/cloud-config"
+}
+
+// assembleCloudInitFile combines several files into one cloud-init file.
+//
+// user-data parts are deep-merged into a single cloud-config when they all are cloud-config
+// ("merge"), or wrapped in a MIME multipart archive with a content type per part
+// ("multipart"). "auto" picks merge when it can and multipart otherwise. Other files, such as
+// meta-data and network-config, are plain YAML and are always merged unless "concat" is asked
+// for. "concat" joins the files with newlines, which is how files were combined before.
+func assembleCloudInitFile(name string, parts []cloudInitPart, assembly string) (string, error) {
+	if assembly == "" {
+		assembly = cloudInitAssemblyAuto
+	}
+	if assembly == cloudInitAssemblyConcat {
+		contents := make([]string, len(parts))
+		for i, part := range parts {
+			contents[i] = part.Content
+		}
+		return strings.Join(contents, "\n"), nil
+	}
+
+	if name != "user-data" {
+		if assembly == cloudInitAssemblyMultipart {
+			return "", fmt.Errorf("multipart assembly is only supported for user-data")
+		}
+		return mergeYAMLDocuments(parts, "")
+	}
+
+	allCloudConfig := true
+	for i := range parts {
+		parts[i].ContentType = cloudInitContentType(parts[i].Content)
+		allCloudConfig = allCloudConfig && parts[i].ContentType == "text/cloud-config"
+	}
+
+	switch {
+	case assembly == cloudInitAssemblyMultipart, assembly == cloudInitAssemblyAuto && !allCloudConfig:
+		return buildMultipart(parts)
+	case !allCloudConfig:
+		return "", fmt.Errorf("merge assembly needs every part to be cloud-config, use \"multipart\" to combine scripts or templates")
+	}
+	return mergeYAMLDocuments(parts, cloudConfigHeader)
+}
+
+// mergeYAMLDocuments deep-merges YAML mappings in order: mappings are merged recursively,
+// lists are appended and any other value from a later file replaces the earlier one. For
+// cloud-config this is cloud-init's "dict(recurse_array,replace)+list(append)" merge.
+// A non-empty header is stripped from every part and written once at the top.
+func mergeYAMLDocuments(parts []cloudInitPart, header string) (string, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	for _, part := range parts {
+		body := part.Content
+		if header != "" {
+			if firstLine, rest, _ := strings.Cut(strings.TrimLeft(body, "\r\n"), "\n"); strings.TrimSpace(firstLine) == header {
+				body = rest
+			}
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", part.Filename, err)
+		}
+		if doc.Kind == 0 {
+			continue
+		}
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return "", fmt.Errorf("%s must be a mapping at the top level to be merged", part.Filename)
+		}
+		mergeYAMLNodes(merged, doc.Content[0])
+	}
+
+	out, err := renderYAML(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{merged}})
+	if err != nil {
+		return "", err
+	}
+	if header != "" {
+		out = header + "\n" + out
+	}
+	return out, nil
+}
+
+func mergeYAMLNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		existing := mappingValue(dst, key.Value)
+		switch {
+		case existing == nil:
+			dst.Content = append(dst.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeYAMLNodes(existing, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			*existing = *value
+		}
+	}
+}
+
+// buildMultipart wraps the parts in the MIME multipart archive cloud-init expects. The
+// boundary is derived from the contents so the same inputs always render the same document.
+func buildMultipart(parts []cloudInitPart) (string, error) {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part.Content))
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.SetBoundary(fmt.Sprintf("==vergeio-%x==", hash.Sum(nil)[:12])); err != nil {
+		return "", err
+	}
+
+	for _, part := range parts {
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = cloudInitContentType(part.Content)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", contentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part.Filename))
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write([]byte(part.Content)); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n%s", writer.Boundary(), body.String()), nil
+}
+
+// parseMultipart splits a MIME multipart user-data document into its parts.
+// ok is false when the document is not multipart.
+func parseMultipart(userData string) (parts []cloudInitPart, ok bool, err error) {
+	message, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		return nil, false, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, false, nil
+	}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts, true, nil
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to read multipart user-data: %w", err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to read multipart user-data: %w", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts = append(parts, cloudInitPart{
+			Filename:    part.FileName(),
+			ContentType: contentType,
+			Content:     string(content),
+		})
+	}
+}
+
+func renderYAML(node *yaml.Node) (string, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return "", fmt.Errorf("failed to render YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to render YAML: %w", err)
+	}
+	return out.String(), nil
+}
+
+// authorizeTemporarySSHKey merges the build's temporary public key into the user-data file
+// sent with the VM, creating the file and enabling the nocloud data source when needed
+func authorizeTemporarySSHKey(apiData *client.VMAPIResourceModel, username string, publicKey string) error {
+	if apiData.CloudInitDataSource == "" || apiData.CloudInitDataSource == "none" {
+		apiData.CloudInitDataSource = "nocloud"
+	}
+
+	for i := range apiData.CloudInitFiles {
+		file := &apiData.CloudInitFiles[i]
+		if file.Name != "user-data" {
+			continue
+		}
+		contents, err := injectSSHPublicKeyIntoUserData(file.Contents, username, publicKey)
+		if err != nil {
+			return err
+		}
+		file.Contents = contents
+		return nil
+	}
+
+	contents, err := injectSSHPublicKey("", username, publicKey)
+	if err != nil {
+		return err
+	}
+	apiData.CloudInitFiles = append(apiData.CloudInitFiles, client.CloudInitFileAPI{
+		Name:     "user-data",
+		Contents: contents,
+	})
+	return nil
+}
+
+// ensureMetaData gives the build its own cloud-init instance. Without a meta-data file, one is
+// added with instanceID and a hostname derived from vmName. A supplied meta-data without an
+// instance-id gets instanceID, cloud-init would otherwise fall back to a fixed default.
+// It returns the instance-id the VM will boot with.
+func ensureMetaData(apiData *client.VMAPIResourceModel, vmName string, instanceID string) (string, error) {
+	for i := range apiData.CloudInitFiles {
+		file := &apiData.CloudInitFiles[i]
+		if file.Name != "meta-data" {
+			continue
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(file.Contents), &doc); err != nil {
+			return "", fmt.Errorf("failed to parse meta-data: %w", err)
+		}
+		if doc.Kind == 0 {
+			doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+		}
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return "", fmt.Errorf("meta-data must be a mapping at the top level")
+		}
+		if existing := mappingValue(root, "instance-id"); existing != nil && existing.Value != "" {
+			return existing.Value, nil
+		}
+
+		root.Content = append([]*yaml.Node{scalarNode("instance-id"), scalarNode(instanceID)}, root.Content...)
+		contents, err := renderYAML(&doc)
+		if err != nil {
+			return "", err
+		}
+		file.Contents = contents
+		return instanceID, nil
+	}
+
+	apiData.CloudInitFiles = append(apiData.CloudInitFiles, client.CloudInitFileAPI{
+		Name:     "meta-data",
+		Contents: fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, hostnameFromVMName(vmName)),
+	})
+	return instanceID, nil
+}
+
+// metaDataInstanceID returns the instance-id set in a meta-data document, if any
+func metaDataInstanceID(contents string) string {
+	root, err := parseYAMLMapping(contents)
+	if err != nil || root == nil {
+		return ""
+	}
+	if value := mappingValue(root, "instance-id"); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+	return ""
+}
+
+// instanceIDOwners lists the VMs, as "VM <key>", whose meta-data file sets instance-id id
+func instanceIDOwners(files []client.CloudInitFileInfo, id string) []string {
+	var owners []string
+	for _, file := range files {
+		if metaDataInstanceID(file.Contents) == id {
+			owners = append(owners, "VM "+strings.TrimPrefix(file.Owner, "vms/"))
+		}
+	}
+	return owners
+}
+
+// instanceIDHistoryPath is the file in the Packer cache that lists the supplied instance-ids
+// earlier builds booted with, one per line
+func instanceIDHistoryPath() (string, error) {
+	return packer.CachePath("vergeio", "instance-ids")
+}
+
+// instanceIDUsed reports whether an earlier build booted with id
+func instanceIDUsed(id string) bool {
+	path, err := instanceIDHistoryPath()
+	if err != nil {
+		return false
+	}
+	history, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(strings.Split(string(history), "\n"), id)
+}
+
+// recordInstanceID adds id to the instance-id history
+func recordInstanceID(id string) error {
+	if instanceIDUsed(id) {
+		return nil
+	}
+	path, err := instanceIDHistoryPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, id)
+	return err
+}
+
+// hostnameFromVMName turns a VM name into a valid hostname label: lower case letters, digits
+// and hyphens, at most 63 characters, not starting or ending with a hyphen
+func hostnameFromVMName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case !strings.HasSuffix(b.String(), "-"):
+			b.WriteRune('-')
+		}
+	}
+
+	hostname := strings.Trim(b.String(), "-")
+	if len(hostname) > 63 {
+		hostname = strings.TrimRight(hostname[:63], "-")
+	}
+	if hostname == "" {
+		return "packer"
+	}
+	return hostname
+}
+
+// injectSSHPublicKeyIntoUserData adds the key to a cloud-config document, or to the first
+// cloud-config part of a multipart document, adding such a part if there is none
+func injectSSHPublicKeyIntoUserData(userData string, username string, publicKey string) (string, error) {
+	parts, isMultipart, err := parseMultipart(userData)
+	if err != nil {
+		return "", err
+	}
+	if !isMultipart {
+		return injectSSHPublicKey(userData, username, publicKey)
+	}
+
+	for i := range parts {
+		if parts[i].ContentType != "text/cloud-config" {
+			continue
+		}
+		content, err := injectSSHPublicKey(parts[i].Content, username, publicKey)
+		if err != nil {
+			return "", err
+		}
+		parts[i].Content = content
+		return buildMultipart(parts)
+	}
+
+	content, err := injectSSHPublicKey("", username, publicKey)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, cloudInitPart{Filename: "packer-ssh-key.yml", ContentType: "text/cloud-config", Content: content})
+	return buildMultipart(parts)
+}
+
+// injectSSHPublicKey adds publicKey to the ssh_authorized_keys of username in a cloud-config
+// user-data document and returns the new document. An empty document becomes a new
+// cloud-config. If username is not in the users list, it is added with passwordless sudo,
+// after the distribution's default user.
+func injectSSHPublicKey(userData string, username string, publicKey string) (string, error) {
+	publicKey = strings.TrimSpace(publicKey)
+
+	body := strings.TrimSpace(userData)
+	if body != "" {
+		firstLine, rest, _ := strings.Cut(body, "\n")
+		if strings.TrimSpace(firstLine) != cloudConfigHeader {
+			return "", fmt.Errorf("user-data must be a %s document to add the temporary SSH key", cloudConfigHeader)
+		}
+		body = rest
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+		return "", fmt.Errorf("failed to parse user-data: %w", err)
+	}
+
+	// An empty document has no content, start a new mapping
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("user-data must be a mapping at the top level")
+	}
+
+	users := mappingValue(root, "users")
+	if users == nil {
+		users = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{scalarNode("default")}}
+		root.Content = append(root.Content, scalarNode("users"), users)
+	}
+	if users.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("user-data: users must be a list")
+	}
+
+	user := findCloudInitUser(users, username)
+	if user == nil {
+		user = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			scalarNode("name"), scalarNode(username),
+			scalarNode("sudo"), scalarNode("ALL=(ALL) NOPASSWD:ALL"),
+			scalarNode("shell"), scalarNode("/bin/bash"),
+		}}
+		users.Content = append(users.Content, user)
+	}
+
+	keys := mappingValue(user, "ssh_authorized_keys")
+	if keys == nil {
+		keys = &yaml.Node{Kind: yaml.SequenceNode}
+		user.Content = append(user.Content, scalarNode("ssh_authorized_keys"), keys)
+	}
+	if keys.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("user-data: ssh_authorized_keys of user %q must be a list", username)
+	}
+	keys.Content = append(keys.Content, scalarNode(publicKey))
+
+	out, err := renderYAML(&doc)
+	if err != nil {
+		return "", err
+	}
+	return cloudConfigHeader + "\n" + out, nil
+}
+
+// findCloudInitUser returns the users entry for name, turning a bare "name" entry into a mapping
+func findCloudInitUser(users *yaml.Node, name string) *yaml.Node {
+	for i, entry := range users.Content {
+		switch entry.Kind {
+		case yaml.ScalarNode:
+			if entry.Value == name {
+				users.Content[i] = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{scalarNode("name"), scalarNode(name)}}
+				return users.Content[i]
+			}
+		case yaml.MappingNode:
+			if value := mappingValue(entry, "name"); value != nil && value.Value == name {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value stored under key in a mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// Documents rendered from a cloud_init block
+type renderedUserData struct {
+	Hostname   string              `yaml:"hostname,omitempty"`
+	Users      []interface{}       `yaml:"users,omitempty"`
+	Packages   []string            `yaml:"packages,omitempty"`
+	Runcmd     []string            `yaml:"runcmd,omitempty"`
+	WriteFiles []renderedWriteFile `yaml:"write_files,omitempty"`
+}
+
+type renderedUser struct {
+	Name              string   `yaml:"name"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type renderedWriteFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content,omitempty"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+}
+
+type renderedNetworkConfig struct {
+	Version   int                         `yaml:"version"`
+	Ethernets map[string]renderedEthernet `yaml:"ethernets"`
+}
+
+type renderedEthernet struct {
+	DHCP4       bool                 `yaml:"dhcp4"`
+	Addresses   []string             `yaml:"addresses,omitempty"`
+	Routes      []renderedRoute      `yaml:"routes,omitempty"`
+	Nameservers *renderedNameservers `yaml:"nameservers,omitempty"`
+}
+
+type renderedRoute struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+type renderedNameservers struct {
+	Addresses []string `yaml:"addresses"`
+}
+
+// renderCloudInit turns a cloud_init block into cloud-init documents keyed by file name:
+// a cloud-config user-data, a meta-data with the local hostname, and a version 2
+// network-config. Only documents with settings are returned.
+func renderCloudInit(ci *CloudInitConfig) (map[string]string, error) {
+	rendered := make(map[string]string)
+	var errs *packer.MultiError
+
+	userData := renderedUserData{
+		Hostname: ci.Hostname,
+		Packages: ci.Packages,
+		Runcmd:   ci.Runcmd,
+	}
+	if len(ci.Users) > 0 {
+		// Keep the distribution's default user, as cloud-init drops it once users is set
+		userData.Users = []interface{}{"default"}
+	}
+	for i, user := range ci.Users {
+		if user.Name == "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("users[%d].name must be specified", i))
+		}
+		userData.Users = append(userData.Users, renderedUser{
+			Name:              user.Name,
+			Sudo:              user.Sudo,
+			SSHAuthorizedKeys: user.SSHAuthorizedKeys,
+		})
+	}
+	for i, file := range ci.WriteFiles {
+		if file.Path == "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("write_files[%d].path must be specified", i))
+		}
+		userData.WriteFiles = append(userData.WriteFiles, renderedWriteFile(file))
+	}
+
+	network := renderedNetworkConfig{Version: 2, Ethernets: make(map[string]renderedEthernet)}
+	for i, nic := range ci.Network {
+		path := fmt.Sprintf("network[%d]", i)
+		if nic.NIC == "" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s.nic must be specified", path))
+			continue
+		}
+		if _, dup := network.Ethernets[nic.NIC]; dup {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s.nic: %s is configured more than once", path, nic.NIC))
+			continue
+		}
+
+		ethernet := renderedEthernet{DHCP4: nic.Address == ""}
+		if nic.Address != "" {
+			if _, _, err := net.ParseCIDR(nic.Address); err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s.address: %q must be in CIDR notation (e.g. 192.168.1.10/24)", path, nic.Address))
+			}
+			ethernet.Addresses = []string{nic.Address}
+		}
+		if nic.Gateway != "" {
+			if net.ParseIP(nic.Gateway) == nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s.gateway: %q is not an IP address", path, nic.Gateway))
+			}
+			ethernet.Routes = []renderedRoute{{To: "default", Via: nic.Gateway}}
+		}
+		for _, dns := range nic.DNS {
+			if net.ParseIP(dns) == nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s.dns: %q is not an IP address", path, dns))
+			}
+		}
+		if len(nic.DNS) > 0 {
+			ethernet.Nameservers = &renderedNameservers{Addresses: nic.DNS}
+		}
+		network.Ethernets[nic.NIC] = ethernet
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+
+	if userData.Hostname != "" || len(userData.Users) > 0 || len(userData.Packages) > 0 ||
+		len(userData.Runcmd) > 0 || len(userData.WriteFiles) > 0 {
+		out, err := yaml.Marshal(userData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render user-data: %w", err)
+		}
+		rendered["user-data"] = cloudConfigHeader + "\n" + string(out)
+	}
+	if ci.Hostname != "" {
+		rendered["meta-data"] = fmt.Sprintf("local-hostname: %s\n", ci.Hostname)
+	}
+	if len(network.Ethernets) > 0 {
+		out, err := yaml.Marshal(network)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render network-config: %w", err)
+		}
+		rendered["network-config"] = string(out)
+	}
+	return rendered, nil
+}
+
+// mergeRenderedCloudInit combines a rendered document with a cloud_init_files entry of the same
+// name, the file's settings win. A rendered network-config cannot be combined with another one.
+func mergeRenderedCloudInit(name, rendered, existing string) (string, error) {
+	renderedPart := cloudInitPart{Filename: "cloud_init", ContentType: "text/cloud-config", Content: rendered}
+	existingPart := cloudInitPart{Filename: name, Content: existing}
+
+	switch name {
+	case "network-config":
+		return "", fmt.Errorf("network is set, but cloud_init_files already has a network-config")
+	case "user-data":
+		parts, isMultipart, err := parseMultipart(existing)
+		if err != nil {
+			return "", err
+		}
+		if isMultipart {
+			return buildMultipart(append([]cloudInitPart{renderedPart}, parts...))
+		}
+		return assembleCloudInitFile(name, []cloudInitPart{renderedPart, existingPart}, cloudInitAssemblyAuto)
+	default:
+		return mergeYAMLDocuments([]cloudInitPart{renderedPart, existingPart}, "")
+	}
+}
+
+// staticIP returns the address of the first statically configured interface, if any
+func (ci *CloudInitConfig) staticIP() string {
+	if ci == nil {
+		return ""
+	}
+	for _, nic := range ci.Network {
+		if ip, _, err := net.ParseCIDR(nic.Address); err == nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
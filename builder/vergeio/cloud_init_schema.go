@@ -0,0 +1,407 @@
+package vergeio
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds a cloud-config value may have, a key accepting several kinds lists them all
+const (
+	kindString = 1 << iota
+	kindBool
+	kindNumber
+	kindList
+	kindMap
+)
+
+var kindNames = []struct {
+	kind int
+	name string
+}{
+	{kindString, "string"},
+	{kindBool, "boolean"},
+	{kindNumber, "number"},
+	{kindList, "list"},
+	{kindMap, "mapping"},
+}
+
+// cloudConfigSchema lists the top-level cloud-config keys of the cloud-init modules and of the
+// base config, and the kinds of value they take. It follows the cloud-init JSON schema,
+// including the deprecated keys it still accepts, without the per-module detail. A key that is
+// not listed is an error, cloud-init would ignore it.
+var cloudConfigSchema = map[string]int{
+	"allow_public_ssh_keys":      kindBool,
+	"ansible":                    kindMap,
+	"apk_repos":                  kindMap,
+	"apt":                        kindMap,
+	"apt_custom_sources_list":    kindString,
+	"apt_ftp_proxy":              kindString,
+	"apt_http_proxy":             kindString,
+	"apt_https_proxy":            kindString,
+	"apt_mirror":                 kindString,
+	"apt_mirror_search":          kindList,
+	"apt_mirror_search_dflt":     kindBool,
+	"apt_pipelining":             kindBool | kindString | kindNumber,
+	"apt_preserve_sources_list":  kindBool,
+	"apt_proxy":                  kindString,
+	"apt_reboot_if_required":     kindBool,
+	"apt_sources":                kindList,
+	"apt_update":                 kindBool,
+	"apt_upgrade":                kindBool,
+	"autoinstall":                kindMap,
+	"bootcmd":                    kindList,
+	"byobu_by_default":           kindString,
+	"ca-certs":                   kindMap,
+	"ca_certs":                   kindMap,
+	"chef":                       kindMap,
+	"chpasswd":                   kindMap,
+	"cloud_config_modules":       kindList,
+	"cloud_final_modules":        kindList,
+	"cloud_init_modules":         kindList,
+	"create_hostname_file":       kindBool,
+	"datasource":                 kindMap,
+	"datasource_list":            kindList,
+	"def_log_file":               kindString,
+	"device_aliases":             kindMap,
+	"disable_ec2_metadata":       kindBool,
+	"disable_root":               kindBool,
+	"disable_root_opts":          kindString,
+	"disk_setup":                 kindMap,
+	"drivers":                    kindMap,
+	"fan":                        kindMap,
+	"final_message":              kindString,
+	"fqdn":                       kindString,
+	"fs_setup":                   kindList,
+	"groups":                     kindList | kindMap | kindString,
+	"growpart":                   kindMap,
+	"grub-dpkg":                  kindMap,
+	"grub_dpkg":                  kindMap,
+	"hostname":                   kindString,
+	"keyboard":                   kindMap,
+	"landscape":                  kindMap,
+	"launch-index":               kindNumber,
+	"locale":                     kindString | kindBool,
+	"locale_configfile":          kindString,
+	"log_cfgs":                   kindList,
+	"lxd":                        kindMap,
+	"manage_etc_hosts":           kindBool | kindString,
+	"manage_resolv_conf":         kindBool,
+	"manual_cache_clean":         kindBool,
+	"mcollective":                kindMap,
+	"merge_how":                  kindList | kindString,
+	"merge_type":                 kindList | kindString,
+	"mount_default_fields":       kindList,
+	"mounts":                     kindList,
+	"network":                    kindMap,
+	"no_ssh_fingerprints":        kindBool,
+	"ntp":                        kindMap,
+	"output":                     kindMap,
+	"package_reboot_if_required": kindBool,
+	"package_update":             kindBool,
+	"package_upgrade":            kindBool,
+	"packages":                   kindList,
+	"password":                   kindString,
+	"phone_home":                 kindMap,
+	"power_state":                kindMap,
+	"prefer_fqdn_over_hostname":  kindBool,
+	"preserve_hostname":          kindBool,
+	"preserve_sources_list":      kindBool,
+	"puppet":                     kindMap,
+	"random_seed":                kindMap,
+	"reporting":                  kindMap,
+	"resize_rootfs":              kindBool | kindString,
+	"resolv_conf":                kindMap,
+	"rh_subscription":            kindMap,
+	"rpi":                        kindMap,
+	"rsyslog":                    kindMap,
+	"runcmd":                     kindList,
+	"salt_minion":                kindMap,
+	"snap":                       kindMap,
+	"snappy":                     kindMap,
+	"spacewalk":                  kindMap,
+	"ssh":                        kindMap,
+	"ssh_authorized_keys":        kindList,
+	"ssh_deletekeys":             kindBool,
+	"ssh_fp_console_blacklist":   kindList,
+	"ssh_genkeytypes":            kindList,
+	"ssh_import_id":              kindList,
+	"ssh_key_console_blacklist":  kindList,
+	"ssh_keys":                   kindMap,
+	"ssh_publish_hostkeys":       kindMap,
+	"ssh_pwauth":                 kindBool | kindString,
+	"ssh_quiet_keygen":           kindBool,
+	"swap":                       kindMap,
+	"syslog_fix_perms":           kindList | kindString,
+	"system_info":                kindMap,
+	"timezone":                   kindString,
+	"ubuntu_advantage":           kindMap,
+	"ubuntu_pro":                 kindMap,
+	"updates":                    kindMap,
+	"user":                       kindMap | kindString,
+	"users":                      kindList | kindMap | kindString,
+	"vendor_data":                kindMap,
+	"vendordata":                 kindMap,
+	"wireguard":                  kindMap,
+	"write_files":                kindList,
+	"yum_repo_dir":               kindString,
+	"yum_repos":                  kindMap,
+	"zypper":                     kindMap,
+}
+
+// cloudConfigValues lists the strings a key accepts where its schema is an enum. Values of
+// other kinds are checked against cloudConfigSchema only.
+var cloudConfigValues = map[string][]string{
+	"manage_etc_hosts": {"template", "localhost"},
+	"resize_rootfs":    {"noblock"},
+	"ssh_pwauth":       {"unchanged"},
+}
+
+// writeFilesEncodings are the encodings a write_files entry may declare
+var writeFilesEncodings = []string{"b64", "base64", "gz", "gzip", "gz+b64", "gz+base64", "gzip+b64", "gzip+base64", "text/plain"}
+
+// powerStateModes are the modes of power_state
+var powerStateModes = []string{"poweroff", "reboot", "halt"}
+
+// validateCloudInitFiles checks every cloud-init file as YAML and against the cloud-init
+// schema. Errors make the document unusable or would be ignored by cloud-init, warnings point
+// at documents cloud-init skips as a whole.
+// field names the list the files came from in messages.
+func validateCloudInitFiles(field string, files []CloudInitFile) (warnings []string, err error) {
+	var errs *packer.MultiError
+	for i, file := range files {
+		path := fmt.Sprintf("%s[%d] (%s)", field, i, file.Name)
+
+		var fileWarnings []string
+		var fileErr error
+		switch file.Name {
+		case "user-data":
+			fileWarnings, fileErr = validateUserData(file.Contents)
+		case "network-config":
+			fileErr = validateNetworkConfig(file.Contents)
+		case "meta-data":
+			fileErr = validateMetaData(file.Contents)
+		default:
+			continue
+		}
+
+		for _, warning := range fileWarnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", path, warning))
+		}
+		if fileErr != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s: %w", path, fileErr))
+		}
+	}
+
+	if errs != nil {
+		return warnings, errs
+	}
+	return warnings, nil
+}
+
+// validateUserData validates the cloud-config parts of a user-data document. Scripts,
+// includes and templates are passed through, cloud-init handles them in the guest.
+func validateUserData(contents string) ([]string, error) {
+	parts, isMultipart, err := parseMultipart(contents)
+	if err != nil {
+		return nil, err
+	}
+	if !isMultipart {
+		firstLine, _, _ := strings.Cut(strings.TrimLeft(contents, "\r\n"), "\n")
+		if !strings.HasPrefix(strings.TrimSpace(firstLine), "#") {
+			return []string{"user-data has no header such as #cloud-config or #!, cloud-init will ignore it"}, nil
+		}
+		parts = []cloudInitPart{{Content: contents, ContentType: cloudInitContentType(contents)}}
+	}
+
+	var warnings []string
+	for _, part := range parts {
+		if part.ContentType != "text/cloud-config" {
+			continue
+		}
+		partWarnings, err := validateCloudConfig(part.Content)
+		if err != nil {
+			if part.Filename != "" {
+				return nil, fmt.Errorf("%s: %w", part.Filename, err)
+			}
+			return nil, err
+		}
+		warnings = append(warnings, partWarnings...)
+	}
+	return warnings, nil
+}
+
+func validateCloudConfig(contents string) ([]string, error) {
+	root, err := parseYAMLMapping(contents)
+	if err != nil || root == nil {
+		return nil, err
+	}
+
+	var warnings []string
+	var errs []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		allowed, known := cloudConfigSchema[key]
+		if !known {
+			errs = append(errs, fmt.Sprintf("line %d: unknown cloud-config key %q", root.Content[i].Line, key))
+			continue
+		}
+		if value.Tag == "!!null" {
+			continue
+		}
+		kind := yamlKind(value)
+		if allowed&kind == 0 {
+			errs = append(errs, fmt.Sprintf("line %d: %s must be a %s, got a %s", value.Line, key, describeKinds(allowed), describeKinds(kind)))
+			continue
+		}
+		if values, ok := cloudConfigValues[key]; ok && kind == kindString && !slices.Contains(values, value.Value) {
+			errs = append(errs, fmt.Sprintf("line %d: %s must be %s, got %q", value.Line, key, describeValues(allowed&^kindString, values), value.Value))
+		}
+	}
+
+	if powerState := mappingValue(root, "power_state"); powerState != nil && powerState.Kind == yaml.MappingNode {
+		if mode := mappingValue(powerState, "mode"); mode == nil {
+			errs = append(errs, fmt.Sprintf("line %d: power_state needs a mode", powerState.Line))
+		} else if !slices.Contains(powerStateModes, mode.Value) {
+			errs = append(errs, fmt.Sprintf("line %d: power_state.mode must be %s, got %q", mode.Line, describeValues(0, powerStateModes), mode.Value))
+		}
+	}
+
+	// The entries of the lists most often written by hand
+	if users := mappingValue(root, "users"); users != nil && users.Kind == yaml.SequenceNode {
+		for _, user := range users.Content {
+			if user.Kind == yaml.MappingNode && mappingValue(user, "name") == nil {
+				errs = append(errs, fmt.Sprintf("line %d: users entry has no name", user.Line))
+			}
+		}
+	}
+	if files := mappingValue(root, "write_files"); files != nil && files.Kind == yaml.SequenceNode {
+		for _, file := range files.Content {
+			if file.Kind != yaml.MappingNode || mappingValue(file, "path") == nil {
+				errs = append(errs, fmt.Sprintf("line %d: write_files entry must be a mapping with a path", file.Line))
+				continue
+			}
+			if encoding := mappingValue(file, "encoding"); encoding != nil && !slices.Contains(writeFilesEncodings, encoding.Value) {
+				errs = append(errs, fmt.Sprintf("line %d: write_files encoding must be %s, got %q", encoding.Line, describeValues(0, writeFilesEncodings), encoding.Value))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return warnings, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return warnings, nil
+}
+
+// validateNetworkConfig checks a version 1 or 2 network-config, optionally wrapped in a
+// top-level network key
+func validateNetworkConfig(contents string) error {
+	root, err := parseYAMLMapping(contents)
+	if err != nil || root == nil {
+		return err
+	}
+	if wrapped := mappingValue(root, "network"); wrapped != nil {
+		if wrapped.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: network must be a mapping", wrapped.Line)
+		}
+		root = wrapped
+	}
+
+	version := mappingValue(root, "version")
+	if version == nil {
+		return fmt.Errorf("version must be set to 1 or 2")
+	}
+	switch version.Value {
+	case "1":
+		config := mappingValue(root, "config")
+		if config == nil || config.Kind != yaml.SequenceNode {
+			return fmt.Errorf("version 1 network-config needs a config list")
+		}
+		for _, entry := range config.Content {
+			if entry.Kind != yaml.MappingNode || mappingValue(entry, "type") == nil {
+				return fmt.Errorf("line %d: config entry must be a mapping with a type", entry.Line)
+			}
+		}
+	case "2":
+		for _, section := range []string{"ethernets", "bonds", "bridges", "vlans", "wifis"} {
+			if value := mappingValue(root, section); value != nil && value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: %s must be a mapping of interface names", value.Line, section)
+			}
+		}
+	default:
+		return fmt.Errorf("line %d: version must be 1 or 2, got %q", version.Line, version.Value)
+	}
+	return nil
+}
+
+func validateMetaData(contents string) error {
+	root, err := parseYAMLMapping(contents)
+	if err != nil || root == nil {
+		return err
+	}
+	for _, key := range []string{"instance-id", "local-hostname"} {
+		if value := mappingValue(root, key); value != nil && value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: %s must be a string", value.Line, key)
+		}
+	}
+	return nil
+}
+
+// parseYAMLMapping parses a YAML document and returns its top-level mapping, or nil for an
+// empty document. The #cloud-config header is a comment to YAML and needs no special case.
+func parseYAMLMapping(contents string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(contents), &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return nil, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("must be a mapping at the top level")
+	}
+	return doc.Content[0], nil
+}
+
+func yamlKind(node *yaml.Node) int {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return kindList
+	case yaml.MappingNode:
+		return kindMap
+	}
+	switch node.Tag {
+	case "!!bool":
+		return kindBool
+	case "!!int", "!!float":
+		return kindNumber
+	}
+	return kindString
+}
+
+func describeKinds(kinds int) string {
+	var names []string
+	for _, k := range kindNames {
+		if kinds&k.kind != 0 {
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " or ")
+}
+
+// describeValues lists the accepted strings of an enum, after the other kinds it accepts
+func describeValues(kinds int, values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	if kinds != 0 {
+		quoted = append([]string{"a " + describeKinds(kinds)}, quoted...)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,101 @@
+package vergeio
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// Placeholders for build values that are only known once the build runs. Templates render
+// them during Prepare, so the documents can be merged and validated as YAML, and
+// StepVMCreate replaces them with the real values.
+const (
+	sshPublicKeyPlaceholder = "__VERGEIO_SSH_PUBLIC_KEY__"
+	httpIPPlaceholder       = "__VERGEIO_HTTP_IP__"
+	httpPortPlaceholder     = "__VERGEIO_HTTP_PORT__"
+)
+
+// cloudInitTemplateData is the data available to Go templates in cloud-init files
+type cloudInitTemplateData struct {
+	// Name is the VM name
+	Name string
+	// SSHUsername is the communicator user
+	SSHUsername string
+	// SSHPublicKey is the temporary public key generated for the build
+	SSHPublicKey string
+	// HTTPIP and HTTPPort address the build's HTTP server, HTTPAddress joins them
+	HTTPIP      string
+	HTTPPort    string
+	HTTPAddress string
+	// Vars holds the template_vars of the file being rendered
+	Vars map[string]string
+}
+
+// renderCloudInitTemplate renders content as a Go template with the build values. Jinja
+// templates are left alone, cloud-init renders those itself in the guest.
+func (c *Config) renderCloudInitTemplate(content string, vars map[string]string) (string, error) {
+	if cloudInitContentType(content) == "text/jinja2" {
+		return content, nil
+	}
+
+	ctx := c.ctx
+	ctx.Data = &cloudInitTemplateData{
+		Name:         c.VmConfig.Name,
+		SSHUsername:  c.Comm.SSHUsername,
+		SSHPublicKey: sshPublicKeyPlaceholder,
+		HTTPIP:       httpIPPlaceholder,
+		HTTPPort:     httpPortPlaceholder,
+		HTTPAddress:  httpIPPlaceholder + ":" + httpPortPlaceholder,
+		Vars:         vars,
+	}
+	return interpolate.Render(content, &ctx)
+}
+
+// checkCloudInitPlaceholders reports build values used by the cloud-init files that this
+// build will not provide
+func (c *Config) checkCloudInitPlaceholders() error {
+	for _, file := range c.VmConfig.CloudInitFiles {
+		if strings.Contains(file.Contents, sshPublicKeyPlaceholder) && !c.useTemporarySSHKey() {
+			return fmt.Errorf("cloud_init_files (%s): SSHPublicKey is only available when a temporary SSH key is generated, which this build doesn't do because %s",
+				file.Name, strings.Join(c.noTemporarySSHKeyReasons(), " and "))
+		}
+		usesHTTP := strings.Contains(file.Contents, httpIPPlaceholder) || strings.Contains(file.Contents, httpPortPlaceholder)
+		if usesHTTP && c.HTTPDir == "" && len(c.HTTPContent) == 0 {
+			return fmt.Errorf("cloud_init_files (%s): HTTPIP, HTTPPort and HTTPAddress need http_directory or http_content to be set", file.Name)
+		}
+	}
+
+	// The temporary key and the HTTP server are gone by the time clones of the template boot
+	for _, file := range c.TemplateCloudInitFiles {
+		for _, placeholder := range []string{sshPublicKeyPlaceholder, httpIPPlaceholder, httpPortPlaceholder} {
+			if strings.Contains(file.Contents, placeholder) {
+				return fmt.Errorf("template_cloud_init_files (%s): SSHPublicKey, HTTPIP, HTTPPort and HTTPAddress only exist during the build", file.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveCloudInitPlaceholders replaces the build value placeholders with the values of this build
+func resolveCloudInitPlaceholders(contents string, sshPublicKey string, httpIP string, httpPort int) string {
+	return strings.NewReplacer(
+		sshPublicKeyPlaceholder, strings.TrimSpace(sshPublicKey),
+		httpIPPlaceholder, httpIP,
+		httpPortPlaceholder, fmt.Sprint(httpPort),
+	).Replace(contents)
+}
+
+// buildCloudInitFiles returns the cloud-init files sent with the VM, with the build value
+// placeholders resolved
+func buildCloudInitFiles(files []CloudInitFile, sshPublicKey string, httpIP string, httpPort int) []client.CloudInitFileAPI {
+	var built []client.CloudInitFileAPI
+	for _, file := range files {
+		built = append(built, client.CloudInitFileAPI{
+			Name:     file.Name,
+			Contents: resolveCloudInitPlaceholders(file.Contents, sshPublicKey, httpIP, httpPort),
+		})
+	}
+	return built
+}
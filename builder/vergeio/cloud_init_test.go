@@ -0,0 +1,234 @@
+package vergeio
+
+import (
+	"strings"
+	"testing"
+
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+	"gopkg.in/yaml.v3"
+)
+
+const testPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKtest\n"
+
+func TestInjectSSHPublicKey_EmptyUserData(t *testing.T) {
+	out, err := injectSSHPublicKey("", "packer", testPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out, "#cloud-config\n") {
+		t.Fatalf("expected a cloud-config header, got:\n%s", out)
+	}
+
+	var doc struct {
+		Users []interface{} `yaml:"users"`
+	}
+	if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output is not valid YAML: %s", err)
+	}
+	if len(doc.Users) != 2 || doc.Users[0] != "default" {
+		t.Fatalf("expected the default user followed by packer, got %v", doc.Users)
+	}
+	if !strings.Contains(out, "- ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKtest") {
+		t.Fatalf("public key missing from:\n%s", out)
+	}
+}
+
+func TestInjectSSHPublicKey_ExistingUser(t *testing.T) {
+	userData := `#cloud-config
+# keep this comment
+hostname: builder
+users:
+  - default
+  - name: packer
+    groups: [wheel]
+    ssh_authorized_keys:
+      - ssh-rsa AAAAexisting
+`
+	out, err := injectSSHPublicKey(userData, "packer", testPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var doc struct {
+		Hostname string `yaml:"hostname"`
+		Users    []yaml.Node
+	}
+	if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output is not valid YAML: %s", err)
+	}
+	if doc.Hostname != "builder" || len(doc.Users) != 2 {
+		t.Fatalf("existing settings were not preserved:\n%s", out)
+	}
+	if !strings.Contains(out, "# keep this comment") {
+		t.Fatalf("comment was dropped:\n%s", out)
+	}
+
+	var packer struct {
+		Keys []string `yaml:"ssh_authorized_keys"`
+	}
+	if err := doc.Users[1].Decode(&packer); err != nil {
+		t.Fatal(err)
+	}
+	if len(packer.Keys) != 2 || packer.Keys[1] != strings.TrimSpace(testPublicKey) {
+		t.Fatalf("expected the key to be appended, got %v", packer.Keys)
+	}
+}
+
+func TestInjectSSHPublicKey_NotCloudConfig(t *testing.T) {
+	if _, err := injectSSHPublicKey("#!/bin/sh\necho hi\n", "packer", testPublicKey); err == nil {
+		t.Fatal("expected an error for a shell script user-data")
+	}
+}
+
+func TestAssembleCloudInitFile_Merge(t *testing.T) {
+	parts := []cloudInitPart{
+		{Filename: "base.yml", Content: "#cloud-config\nhostname: one\npackages: [curl]\nwrite_files:\n  - path: /a\n"},
+		{Filename: "packages.yml", Content: "hostname: two\npackages: [git]\nruncmd:\n  - echo hi\n"},
+	}
+	out, err := assembleCloudInitFile("user-data", parts, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out, cloudConfigHeader+"\n") || strings.Count(out, cloudConfigHeader) != 1 {
+		t.Fatalf("expected a single cloud-config header:\n%s", out)
+	}
+
+	var doc struct {
+		Hostname string   `yaml:"hostname"`
+		Packages []string `yaml:"packages"`
+		Runcmd   []string `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output is not valid YAML: %s", err)
+	}
+	if doc.Hostname != "two" || len(doc.Packages) != 2 || len(doc.Runcmd) != 1 {
+		t.Fatalf("unexpected merge result:\n%s", out)
+	}
+}
+
+func TestAssembleCloudInitFile_Multipart(t *testing.T) {
+	parts := []cloudInitPart{
+		{Filename: "base.yml", Content: "#cloud-config\nhostname: one\n"},
+		{Filename: "setup.sh", Content: "#!/bin/sh\necho hi\n"},
+	}
+	out, err := assembleCloudInitFile("user-data", parts, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, ok, err := parseMultipart(out)
+	if err != nil || !ok {
+		t.Fatalf("expected a multipart document, got ok=%v err=%v:\n%s", ok, err, out)
+	}
+	if len(parsed) != 2 || parsed[0].ContentType != "text/cloud-config" || parsed[1].ContentType != "text/x-shellscript" {
+		t.Fatalf("unexpected parts: %+v", parsed)
+	}
+	if parsed[1].Filename != "setup.sh" || parsed[1].Content != parts[1].Content {
+		t.Fatalf("part was not preserved: %+v", parsed[1])
+	}
+
+	if _, err := assembleCloudInitFile("user-data", parts, cloudInitAssemblyMerge); err == nil {
+		t.Fatal("expected merge to reject a shell script part")
+	}
+
+	withKey, err := injectSSHPublicKeyIntoUserData(out, "packer", testPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parsed, _, _ = parseMultipart(withKey)
+	if len(parsed) != 2 || !strings.Contains(parsed[0].Content, strings.TrimSpace(testPublicKey)) {
+		t.Fatalf("expected the key in the cloud-config part: %+v", parsed)
+	}
+}
+
+func TestValidateCloudInitFiles(t *testing.T) {
+	warnings, err := validateCloudInitFiles("cloud_init_files", []CloudInitFile{
+		{Name: "user-data", Contents: "#cloud-config\nhostname: ok\nsystem_info:\n  default_user:\n    name: admin\nmanage_etc_hosts: localhost\n"},
+		{Name: "network-config", Contents: "version: 2\nethernets:\n  eth0:\n    dhcp4: true\n"},
+		{Name: "meta-data", Contents: "instance-id: i-1\n"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	_, err = validateCloudInitFiles("cloud_init_files", []CloudInitFile{
+		{Name: "user-data", Contents: "#cloud-config\npackages: curl\nusers:\n  - sudo: ALL\nfancy_module: {}\npower_state:\n  mode: shutdown\n"},
+		{Name: "network-config", Contents: "version: 3\n"},
+		{Name: "meta-data", Contents: "instance-id: [\n"},
+	})
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"packages must be a list", "users entry has no name", `unknown cloud-config key "fancy_module"`,
+		`power_state.mode must be "poweroff", "reboot", "halt", got "shutdown"`, "version must be 1 or 2", "invalid YAML"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in the error, got: %s", want, err)
+		}
+	}
+}
+
+func TestEnsureMetaData(t *testing.T) {
+	apiData := client.VMAPIResourceModel{}
+	id, err := ensureMetaData(&apiData, "Ubuntu 24.04 Base_Image", "iid-1")
+	if err != nil || id != "iid-1" {
+		t.Fatalf("unexpected result %q, %v", id, err)
+	}
+	if got := apiData.CloudInitFiles[0].Contents; got != "instance-id: iid-1\nlocal-hostname: ubuntu-24-04-base-image\n" {
+		t.Fatalf("unexpected meta-data:\n%s", got)
+	}
+
+	apiData.CloudInitFiles[0].Contents = "local-hostname: web\n"
+	if id, err := ensureMetaData(&apiData, "vm", "iid-2"); err != nil || id != "iid-2" {
+		t.Fatalf("unexpected result %q, %v", id, err)
+	}
+	if got := apiData.CloudInitFiles[0].Contents; got != "instance-id: iid-2\nlocal-hostname: web\n" {
+		t.Fatalf("expected the instance-id to be added:\n%s", got)
+	}
+
+	if id, err := ensureMetaData(&apiData, "vm", "iid-3"); err != nil || id != "iid-2" {
+		t.Fatalf("expected the supplied instance-id to be kept, got %q, %v", id, err)
+	}
+}
+
+func TestInstanceIDOwners(t *testing.T) {
+	files := []client.CloudInitFileInfo{
+		{Owner: "vms/12", Name: "meta-data", Contents: "instance-id: base\nlocal-hostname: a\n"},
+		{Owner: "vms/15", Name: "meta-data", Contents: "instance-id: iid-other\n"},
+		{Owner: "vms/20", Name: "meta-data", Contents: "local-hostname: c\ninstance-id: base\n"},
+	}
+	if got := strings.Join(instanceIDOwners(files, "base"), ", "); got != "VM 12, VM 20" {
+		t.Fatalf("unexpected owners %q", got)
+	}
+	if got := instanceIDOwners(files, "iid-new"); len(got) != 0 {
+		t.Fatalf("expected no owners, got %v", got)
+	}
+}
+
+func TestRestoredUserDataDropsTemporaryKey(t *testing.T) {
+	files := []CloudInitFile{
+		{Name: "user-data", Contents: "#cloud-config\nhostname: " + httpIPPlaceholder + "\n"},
+		{Name: "meta-data", Contents: "instance-id: iid-1\n"},
+	}
+
+	apiData := client.VMAPIResourceModel{CloudInitFiles: buildCloudInitFiles(files, testPublicKey, "10.0.0.5", 8080)}
+	if err := authorizeTemporarySSHKey(&apiData, "packer", testPublicKey); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	injected, _ := userDataFile(apiData.CloudInitFiles)
+	if !strings.Contains(injected.Contents, "AAAAC3NzaC1lZDI1NTE5AAAAIKtest") || !strings.Contains(injected.Contents, "NOPASSWD") {
+		t.Fatalf("expected the key and a sudo user in the stored user-data, got:\n%s", injected.Contents)
+	}
+
+	restored, ok := userDataFile(buildCloudInitFiles(files, testPublicKey, "10.0.0.5", 8080))
+	if !ok || restored.Contents != "#cloud-config\nhostname: 10.0.0.5\n" {
+		t.Fatalf("expected the configured user-data, got %q", restored.Contents)
+	}
+
+	// Without configured user-data the injected file is only removed
+	if _, ok := userDataFile(buildCloudInitFiles(files[1:], testPublicKey, "", 0)); ok {
+		t.Fatal("expected no user-data to restore")
+	}
+}
@@ -3,15 +3,22 @@ package vergeio
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
 )
 
 // Config represents the complete configuration for the VergeIO builder
@@ -27,6 +34,10 @@ type Config struct {
 	// Includes fields like ssh_username, ssh_password, ssh_port, ssh_timeout, etc.
 	Comm communicator.Config `mapstructure:",squash"`
 
+	// HTTPConfig serves http_directory or http_content during the build, cloud-init files
+	// can point the guest at it with {{ .HTTPIP }} and {{ .HTTPPort }}
+	commonsteps.HTTPConfig `mapstructure:",squash"`
+
 	// ClusterConfig contains VergeIO cluster connection information
 	// This is embedded with squash so fields appear at the root level in HCL
 	ClusterConfig `mapstructure:",squash"`
@@ -37,12 +48,17 @@ type Config struct {
 
 	// ShutdownCommand is the command to run inside the VM to shut it down gracefully
 	// Example: "sudo shutdown -P now" for Linux, "shutdown /s /t 0" for Windows
+	// If empty, the VM is shut down with an ACPI power-button event through VergeIO
 	ShutdownCommand string `mapstructure:"shutdown_command"`
 
-	// ShutdownTimeout is how long to wait for the shutdown command to complete
+	// ShutdownTimeout is how long to wait for the VM to power off gracefully
 	// If this timeout is exceeded, the VM will be forcefully powered off
 	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 
+	// ShutdownPollInterval is how often the VM power state is checked while waiting for shutdown
+	// Default: 5 seconds
+	ShutdownPollInterval time.Duration `mapstructure:"shutdown_poll_interval"`
+
 	// PowerOnTimeout is the maximum time to wait for the VM to power on
 	// This should be relatively short as power-on is usually quick
 	// Default: 2 minutes
@@ -52,6 +68,111 @@ type Config struct {
 	// This needs to be longer to allow for OS boot sequence
 	// Default: 5 minutes
 	BootTimeout time.Duration `mapstructure:"boot_timeout"`
+
+	// DiskImportTimeout is the maximum time to wait for all media="import" disks to finish importing
+	// All imports run concurrently and share this timeout
+	// Default: 30 minutes
+	DiskImportTimeout time.Duration `mapstructure:"disk_import_timeout"`
+
+	// Generalize runs an OS-family specific cleanup after provisioning, see StepGeneralize
+	// The cleanup powers the VM off, so the shutdown command is not used
+	Generalize bool `mapstructure:"generalize"`
+
+	// GeneralizeTimeout bounds the cleanup and the wait for the VM to power off
+	// Default: 15 minutes
+	GeneralizeTimeout time.Duration `mapstructure:"generalize_timeout"`
+
+	// SysprepUnattend is the path of an unattend.xml passed to sysprep on Windows guests
+	SysprepUnattend string `mapstructure:"sysprep_unattend"`
+
+	// Compact frees the blocks the guest no longer uses before shutdown, see StepCompact
+	// Discard is enabled on the drives so the freed blocks reach the storage tier
+	Compact bool `mapstructure:"compact"`
+
+	// CompactTimeout bounds the compaction commands
+	// Default: 30 minutes
+	CompactTimeout time.Duration `mapstructure:"compact_timeout"`
+
+	// SdeletePath is the sdelete executable used on Windows guests, it must already be in the guest
+	// Default: sdelete64.exe
+	SdeletePath string `mapstructure:"sdelete_path"`
+
+	// ResumeFromCheckpoint restores the VM of an earlier failed build from its latest
+	// checkpoint snapshot instead of creating a new one, see StepResumeCheckpoint
+	ResumeFromCheckpoint bool `mapstructure:"resume_from_checkpoint"`
+
+	// FinalizeTemplate strips the build's cloud-init files, the console password and CD-ROM
+	// media from the VM once it is shut down, see StepFinalize. Without it they are left as is.
+	FinalizeTemplate bool `mapstructure:"finalize_template"`
+
+	// TemplateCPUCores and TemplateRAM replace cpu_cores and ram once the VM is shut down, so
+	// the build can run with more resources than clones of the template should get
+	TemplateCPUCores int `mapstructure:"template_cpu_cores"`
+	TemplateRAM      int `mapstructure:"template_ram"`
+
+	// TemplateCloudInitFiles replace the build's cloud-init files on the finished template.
+	// Without them FinalizeTemplate removes the build's files, unless KeepCloudInitFiles is set.
+	TemplateCloudInitFiles []CloudInitFile `mapstructure:"template_cloud_init_files"`
+	KeepCloudInitFiles     bool            `mapstructure:"keep_cloud_init_files"`
+
+	// ExistingVMName or ExistingVMKey build on an existing VM instead of creating one, see
+	// StepAttachVM. The VM is provisioned in place and cloned into the template named name.
+	ExistingVMName string `mapstructure:"existing_vm_name"`
+	ExistingVMKey  int    `mapstructure:"existing_vm_key"`
+
+	// ExistingVMSnapshot snapshots the existing VM before the build touches it, a failed build
+	// rolls the VM back to it
+	ExistingVMSnapshot bool `mapstructure:"existing_vm_snapshot"`
+
+	// ImportSource is a local .ova, .ovf or disk image whose disks are uploaded to the media
+	// catalog and imported, see applyImportSource. Its hardware fills in unset VM values.
+	ImportSource string `mapstructure:"import_source"`
+
+	// ImportNetworks maps the source's network names to VergeIO network names
+	ImportNetworks map[string]string `mapstructure:"import_networks"`
+
+	// ImportDiskInterface replaces the interface of every imported disk, e.g. for a guest
+	// without virtio drivers
+	ImportDiskInterface string `mapstructure:"import_disk_interface"`
+
+	// WarmPool clones the build VM from a booted, not yet provisioned copy of an earlier build
+	// with the same VM config, see StepWarmPoolClone. The first build fills the pool.
+	WarmPool bool `mapstructure:"warm_pool"`
+
+	// WarmPoolMaxAge evicts pool entries of any config that are older than this
+	WarmPoolMaxAge time.Duration `mapstructure:"warm_pool_max_age"`
+
+	// WarmPoolRefresh evicts this config's pool entry and fills it again
+	WarmPoolRefresh bool `mapstructure:"warm_pool_refresh"`
+
+	// WarmPoolEvict evicts the listed pool entries, by entry name or pool key, as the
+	// vergeio-warm-pool data source reports them
+	WarmPoolEvict []string `mapstructure:"warm_pool_evict"`
+
+	// SkipIfUnchanged returns the existing template as the artifact, without building, when its
+	// content hash matches this build's, see StepContentHash
+	SkipIfUnchanged bool `mapstructure:"skip_if_unchanged"`
+
+	// ContentHashFiles are hashed along with the config, e.g. the provisioner scripts, which
+	// the builder cannot see. Glob patterns are expanded.
+	ContentHashFiles []string `mapstructure:"content_hash_files"`
+
+	// GitCommit is the commit of the template sources, recorded in the build provenance
+	GitCommit string `mapstructure:"git_commit"`
+
+	// ProvenanceFile is where the in-toto provenance statement of the build is written, see
+	// StepProvenance. The file becomes part of the artifact.
+	ProvenanceFile string `mapstructure:"provenance_file"`
+
+	// importSource is ImportSource as read by Prepare
+	importSource *importSource
+
+	// contentFiles maps every file of ContentHashFiles to the SHA-256 of its contents
+	contentFiles map[string]string
+	// httpFiles maps every file under HTTPDir, relative to it, to the SHA-256 of its contents
+	httpFiles map[string]string
+
+	ctx interpolate.Context
 }
 
 type Builder struct {
@@ -96,7 +217,9 @@ type VmConfig struct {
 	SerialPort           bool            `mapstructure:"serial_port" required:"false"`
 	BootDelay            int             `mapstructure:"boot_delay" required:"false"`
 	PreferredNode        string          `mapstructure:"preferred_node" required:"false"`
+	PreferredNodeName    string          `mapstructure:"preferred_node_name" required:"false"`
 	SnapshotProfile      string          `mapstructure:"snapshot_profile" required:"false"`
+	SnapshotProfileName  string          `mapstructure:"snapshot_profile_name" required:"false"`
 	CloudInitDataSource  string          `mapstructure:"cloud_init_data_source" required:"false"`
 	PowerState           bool            `mapstructure:"power_state" required:"false"`
 	GuestAgent           bool            `mapstructure:"guest_agent" required:"false"`
@@ -107,6 +230,8 @@ type VmConfig struct {
 	VmDiskConfigs        []VmDiskConfig  `mapstructure:"vm_disks" required:"false"`
 	VmNicConfigs         []VmNicConfig   `mapstructure:"vm_nics" required:"false"`
 	CloudInitFiles       []CloudInitFile `mapstructure:"cloud_init_files" required:"false"`
+	// CloudInit is rendered into the user-data, meta-data and network-config files
+	CloudInit *CloudInitConfig `mapstructure:"cloud_init" required:"false"`
 }
 
 // CloudInitFile represents a cloud-init file with name and contents
@@ -116,6 +241,43 @@ type CloudInitFile struct {
 	Name     string   `mapstructure:"name" required:"false"`
 	Contents string   `mapstructure:"contents" required:"false"`
 	Files    []string `mapstructure:"files" required:"false"`
+	Assembly string   `mapstructure:"assembly" required:"false"`
+	// TemplateVars are available to the file's Go template as {{ .Vars.<name> }}
+	TemplateVars map[string]string `mapstructure:"template_vars" required:"false"`
+	// SkipTemplate passes the contents through without Go template rendering
+	SkipTemplate bool `mapstructure:"skip_template" required:"false"`
+}
+
+// CloudInitConfig describes common cloud-init settings as HCL blocks instead of raw YAML
+// See renderCloudInit for the documents it produces
+type CloudInitConfig struct {
+	Hostname   string                   `mapstructure:"hostname" required:"false"`
+	Users      []CloudInitUser          `mapstructure:"users" required:"false"`
+	Packages   []string                 `mapstructure:"packages" required:"false"`
+	Runcmd     []string                 `mapstructure:"runcmd" required:"false"`
+	WriteFiles []CloudInitWriteFile     `mapstructure:"write_files" required:"false"`
+	Network    []CloudInitNetworkConfig `mapstructure:"network" required:"false"`
+}
+
+type CloudInitUser struct {
+	Name              string   `mapstructure:"name" required:"true"`
+	SSHAuthorizedKeys []string `mapstructure:"ssh_authorized_keys" required:"false"`
+	Sudo              string   `mapstructure:"sudo" required:"false"`
+}
+
+type CloudInitWriteFile struct {
+	Path        string `mapstructure:"path" required:"true"`
+	Content     string `mapstructure:"content" required:"false"`
+	Owner       string `mapstructure:"owner" required:"false"`
+	Permissions string `mapstructure:"permissions" required:"false"`
+}
+
+// CloudInitNetworkConfig configures one guest interface. Without an address it uses DHCP.
+type CloudInitNetworkConfig struct {
+	NIC     string   `mapstructure:"nic" required:"true"`
+	Address string   `mapstructure:"address" required:"false"`
+	Gateway string   `mapstructure:"gateway" required:"false"`
+	DNS     []string `mapstructure:"dns" required:"false"`
 }
 
 type VmDiskConfig struct {
@@ -125,14 +287,22 @@ type VmDiskConfig struct {
 	Interface           string `mapstructure:"interface" required:"false"`
 	Media               string `mapstructure:"media" required:"false"`
 	MediaSource         int    `mapstructure:"media_source" required:"false"`
+	MediaSourceName     string `mapstructure:"media_source_name" required:"false"`
+	CloneFromVM         string `mapstructure:"clone_from_vm" required:"false"`
+	CloneFromDrive      string `mapstructure:"clone_from_drive" required:"false"`
 	PreferredTier       string `mapstructure:"preferred_tier" required:"false"`
-	DiskSize            int64  `mapstructure:"disksize" required:"false"`
+	DiskSize            string `mapstructure:"disksize" required:"false"`
 	Enabled             bool   `mapstructure:"enabled" required:"false"`
 	ReadOnly            bool   `mapstructure:"readonly" required:"false"`
 	Serial              string `mapstructure:"serial" required:"false"`
 	Asset               string `mapstructure:"asset" required:"false"`
 	OrderId             int    `mapstructure:"orderid" required:"false"`
 	PreserveDriveFormat bool   `mapstructure:"preserve_drive_format" required:"false"`
+	// BuildOnly drives are removed from the template once the build is done
+	BuildOnly bool `mapstructure:"build_only" required:"false"`
+
+	// sizeBytes is DiskSize normalised to bytes by Prepare
+	sizeBytes int64
 }
 
 type VmNicConfig struct {
@@ -143,10 +313,13 @@ type VmNicConfig struct {
 	Driver          string `mapstructure:"driver" required:"false"`
 	Model           string `mapstructure:"model" required:"false"`
 	VNET            int    `mapstructure:"vnet" required:"false"`
+	VNETName        string `mapstructure:"vnet_name" required:"false"`
 	MAC             string `mapstructure:"macaddress" required:"false"`
 	IPAddress       string `mapstructure:"ipaddress" required:"false"`
 	AssignIPAddress bool   `mapstructure:"assign_ipaddress" required:"false"`
 	Enabled         bool   `mapstructure:"enabled" required:"false"`
+	// BuildOnly NICs are removed from the template once the build is done
+	BuildOnly bool `mapstructure:"build_only" required:"false"`
 }
 
 // Prepare validates and sets up the configuration for the VergeIO builder
@@ -159,8 +332,13 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 	// Decode the user's HCL configuration into our Config struct
 	// This converts the HCL input into Go struct fields
 	err = config.Decode(&b.config, &config.DecodeOpts{
-		PluginType:  "packer.builder.vergeio",
-		Interpolate: true, // Allow variable interpolation in config
+		PluginType:         "packer.builder.vergeio",
+		Interpolate:        true, // Allow variable interpolation in config
+		InterpolateContext: &b.config.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			// Cloud-init files are rendered by processCloudInitFiles, with the build values
+			Exclude: []string{"cloud_init_files", "template_cloud_init_files"},
+		},
 	}, raws...)
 
 	if err != nil {
@@ -232,6 +410,13 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 		b.config.Comm.WinRMTimeout = 20 * time.Minute
 	}
 
+	// === Disk Import Configuration Setup ===
+	// Set default disk import timeout if not specified
+	if b.config.DiskImportTimeout == 0 {
+		log.Printf("[Vergeio]: No disk import timeout specified, defaulting to 30 minutes")
+		b.config.DiskImportTimeout = 30 * time.Minute
+	}
+
 	// === Shutdown Configuration Setup ===
 	// Set default shutdown timeout if not specified
 	if b.config.ShutdownTimeout == 0 {
@@ -239,12 +424,159 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 		b.config.ShutdownTimeout = 5 * time.Minute
 	}
 
+	// Set default shutdown poll interval if not specified
+	if b.config.ShutdownPollInterval == 0 {
+		log.Printf("[Vergeio]: No shutdown poll interval specified, defaulting to 5 seconds")
+		b.config.ShutdownPollInterval = 5 * time.Second
+	}
+
+	// === Import Source ===
+	// The source's disks, NICs and os_family are applied before anything that reads them is validated
+	if b.config.ImportSource != "" {
+		if b.config.attachExisting() {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("import_source: not supported when building on an existing VM"))
+		} else if importWarnings, err := b.config.applyImportSource(); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		} else {
+			warnings = append(warnings, importWarnings...)
+		}
+		for _, ifaceErr := range appendIfInvalid(nil, "import_disk_interface", b.config.ImportDiskInterface, client.GetValidDiskInterfaces()) {
+			errs = packer.MultiErrorAppend(errs, ifaceErr)
+		}
+	} else if len(b.config.ImportNetworks) > 0 || b.config.ImportDiskInterface != "" {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("import_networks and import_disk_interface: require import_source"))
+	}
+
+	// === Generalize Configuration Validation ===
+	if b.config.Generalize {
+		if b.config.OSFamily != "linux" && b.config.OSFamily != "windows" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("generalize: only supported for os_family linux and windows, got %q", b.config.OSFamily))
+		}
+		if b.config.GeneralizeTimeout == 0 {
+			log.Printf("[Vergeio]: No generalize timeout specified, defaulting to 15 minutes")
+			b.config.GeneralizeTimeout = 15 * time.Minute
+		}
+	}
+	if b.config.SysprepUnattend != "" {
+		if !b.config.Generalize || b.config.OSFamily != "windows" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("sysprep_unattend: requires generalize = true and os_family = \"windows\""))
+		} else if _, err := os.Stat(b.config.SysprepUnattend); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("sysprep_unattend: %w", err))
+		}
+	}
+
+	// === Compaction Configuration Validation ===
+	if b.config.Compact {
+		if b.config.OSFamily != "linux" && b.config.OSFamily != "windows" {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("compact: only supported for os_family linux and windows, got %q", b.config.OSFamily))
+		}
+		if b.config.CompactTimeout == 0 {
+			log.Printf("[Vergeio]: No compact timeout specified, defaulting to 30 minutes")
+			b.config.CompactTimeout = 30 * time.Minute
+		}
+		if b.config.SdeletePath == "" {
+			b.config.SdeletePath = "sdelete64.exe"
+		}
+	}
+
+	// === Checkpoint Resume Validation ===
+	// The restored guest only trusts the temporary key of the build that created it
+	if b.config.ResumeFromCheckpoint && b.config.useTemporarySSHKey() {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("resume_from_checkpoint: needs ssh_password, ssh_private_key_file, ssh_agent_auth or winrm, "+
+			"the temporary SSH key of the earlier build is gone"))
+	}
+
+	// === Warm Pool Validation ===
+	if b.config.WarmPool {
+		if b.config.ResumeFromCheckpoint {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("warm_pool: conflicts with resume_from_checkpoint"))
+		}
+		// The pooled guest only trusts the temporary key of the build that filled the pool
+		if b.config.useTemporarySSHKey() {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("warm_pool: needs ssh_password, ssh_private_key_file, ssh_agent_auth or winrm, "+
+				"the temporary SSH key of the build that filled the pool is gone"))
+		}
+		if b.config.WarmPoolMaxAge < 0 {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("warm_pool_max_age: must not be negative, got %v", b.config.WarmPoolMaxAge))
+		}
+		for i, entry := range b.config.WarmPoolEvict {
+			if strings.TrimPrefix(entry, WarmPoolPrefix) == "" {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("warm_pool_evict[%d]: must be an entry name or pool key, got %q", i, entry))
+			}
+		}
+	} else if b.config.WarmPoolMaxAge != 0 || b.config.WarmPoolRefresh || len(b.config.WarmPoolEvict) > 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("warm_pool_max_age, warm_pool_refresh and warm_pool_evict: require warm_pool = true"))
+	}
+
+	// === Content Hash ===
+	// The files are hashed now, so a missing script fails the build before anything is created
+	if err := b.config.loadContentHashFiles(); err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+	// The builder can't see the provisioner scripts, without content_hash_files a changed script doesn't rebuild
+	if b.config.SkipIfUnchanged && len(b.config.ContentHashFiles) == 0 {
+		warnings = append(warnings, "skip_if_unchanged: content_hash_files is empty, changes to provisioner scripts "+
+			"do not change the content hash and the existing template is kept")
+	}
+
+	// === Template Finalization Validation ===
+	if b.config.TemplateCPUCores < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("template_cpu_cores: must not be negative, got %d", b.config.TemplateCPUCores))
+	}
+	if b.config.TemplateRAM < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("template_ram: must not be negative, got %d", b.config.TemplateRAM))
+	}
+	if b.config.KeepCloudInitFiles && len(b.config.TemplateCloudInitFiles) > 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("keep_cloud_init_files: conflicts with template_cloud_init_files"))
+	}
+
+	// === Existing VM Validation ===
+	// The existing VM brings its own hardware and guest, only the template name is taken from name
+	if b.config.attachExisting() {
+		if b.config.ExistingVMName != "" && b.config.ExistingVMKey != 0 {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("existing_vm_key: conflicts with existing_vm_name"))
+		}
+		if b.config.ExistingVMKey < 0 {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("existing_vm_key: must not be negative, got %d", b.config.ExistingVMKey))
+		}
+		if b.config.ExistingVMName != "" && b.config.ExistingVMName == b.config.VmConfig.Name {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("name: must differ from existing_vm_name, the template is a clone of the existing VM"))
+		}
+		for _, option := range []struct {
+			field string
+			set   bool
+		}{
+			{"vm_disks", len(b.config.VmDiskConfigs) > 0},
+			{"vm_nics", len(b.config.VmNicConfigs) > 0},
+			{"cloud_init_files", len(b.config.CloudInitFiles) > 0},
+			{"cloud_init", b.config.CloudInit != nil},
+			{"generalize", b.config.Generalize},
+			{"resume_from_checkpoint", b.config.ResumeFromCheckpoint},
+			{"warm_pool", b.config.WarmPool},
+			{"skip_if_unchanged", b.config.SkipIfUnchanged},
+		} {
+			if option.set {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s: not supported when building on an existing VM", option.field))
+			}
+		}
+		if !b.config.GuestAgent {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("guest_agent: must be true when building on an existing VM, its address is discovered through the guest agent"))
+		}
+		// The existing guest never authorized the temporary key
+		if b.config.useTemporarySSHKey() {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("existing_vm_name: needs ssh_password, ssh_private_key_file, ssh_agent_auth or winrm, "+
+				"the existing VM does not trust a temporary SSH key"))
+		}
+	} else if b.config.ExistingVMSnapshot {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("existing_vm_snapshot: requires existing_vm_name or existing_vm_key"))
+	}
+
 	// Validate that shutdown command is provided if we expect to run provisioners
 	// (We'll add this validation later once we know the expected usage patterns)
 
 	// === Network Configuration Validation ===
 	// Ensure at least one NIC is configured for provisioning connectivity
-	if len(b.config.VmNicConfigs) == 0 {
+	if len(b.config.VmNicConfigs) == 0 && !b.config.attachExisting() {
 		warnings = append(warnings, "No vm_nics configured - provisioning may fail without network connectivity")
 	}
 
@@ -255,9 +587,9 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 			errs = packer.MultiErrorAppend(errs, fmt.Errorf("ssh_username is required when using SSH communicator"))
 		}
 
-		// Must have either password or private key for SSH authentication
-		if b.config.Comm.SSHPassword == "" && b.config.Comm.SSHPrivateKeyFile == "" && !b.config.Comm.SSHAgentAuth {
-			errs = packer.MultiErrorAppend(errs, fmt.Errorf("ssh_password, ssh_private_key_file, or ssh_agent_auth must be specified for SSH authentication"))
+		// Without a private key file or agent auth, a temporary key pair is generated for the build
+		if b.config.useTemporarySSHKey() {
+			log.Printf("[Vergeio]: No SSH private key configured, a temporary key pair will be generated")
 		}
 	}
 
@@ -270,6 +602,18 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 		}
 	}
 
+	// === HTTP Server Configuration ===
+	// Defaults the port range and checks that http_directory and http_content are not both set
+	for _, httpErr := range b.config.HTTPConfig.Prepare(&b.config.ctx) {
+		errs = packer.MultiErrorAppend(errs, httpErr)
+	}
+
+	// === VM, Disk and NIC Validation ===
+	// Catch typos and impossible combinations before a half-built VM exists
+	for _, vmErr := range b.config.VmConfig.validate() {
+		errs = packer.MultiErrorAppend(errs, vmErr)
+	}
+
 	// Check for any validation errors before continuing
 	if errs != nil {
 		log.Printf("[Vergeio]: Configuration validation failed with errors: %+v", errs)
@@ -285,29 +629,268 @@ func (b *Builder) Prepare(raws ...interface{}) (generatedVars []string, warnings
 		return nil, warnings, errs
 	}
 
+	// Render the cloud_init block and merge it into the cloud-init files
+	if err := b.applyCloudInitConfig(); err != nil {
+		log.Printf("[Vergeio]: Cloud-init rendering failed: %+v", err)
+		errs = packer.MultiErrorAppend(errs, err)
+		return nil, warnings, errs
+	}
+
+	// Catch YAML and schema mistakes now, rather than as a guest that never gets an IP
+	cloudInitWarnings, err := validateCloudInitFiles("cloud_init_files", b.config.CloudInitFiles)
+	warnings = append(warnings, cloudInitWarnings...)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+	cloudInitWarnings, err = validateCloudInitFiles("template_cloud_init_files", b.config.TemplateCloudInitFiles)
+	warnings = append(warnings, cloudInitWarnings...)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+	if err := b.config.checkCloudInitPlaceholders(); err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+	if len(b.config.TemplateCloudInitFiles) > 0 && (b.config.CloudInitDataSource == "" || b.config.CloudInitDataSource == "none") {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("cloud_init_data_source: must be set (e.g. \"nocloud\") when template_cloud_init_files are configured"))
+	}
+
+	// An instance-id that an earlier build already booted with makes cloud-init skip its
+	// first-boot modules on clones of the template
+	for i, file := range b.config.CloudInitFiles {
+		if file.Name != "meta-data" {
+			continue
+		}
+		if id := metaDataInstanceID(file.Contents); id != "" && instanceIDUsed(id) {
+			warnings = append(warnings, fmt.Sprintf("cloud_init_files[%d] (%s): instance-id %q was already used by an earlier build, "+
+				"remove it to have a unique one generated, or make it unique, e.g. \"iid-${uuidv4()}\"", i, file.Name, id))
+		}
+	}
+	if errs != nil {
+		return nil, warnings, errs
+	}
+
 	log.Printf("[Vergeio]: Configuration validation completed successfully")
 	log.Printf("[Vergeio]: Final configuration - Comm: %+v", b.config.Comm)
 	log.Printf("[Vergeio]: Final configuration - Shutdown timeout: %v", b.config.ShutdownTimeout)
 
-	// Return empty generated data for now
-	// This could be extended to provide VM information to provisioners
-	buildGeneratedData := []string{}
+	// Build variables for provisioners, the vergeio-checkpoint provisioner relies on them
+	buildGeneratedData := []string{"CheckpointPrefix", "CompletedCheckpoints", "ContentHash"}
 
 	return buildGeneratedData, warnings, nil
 }
 
+// validate checks every enumerated field and cross-field rule of the VM, its disks and NICs
+// All problems are returned at once so users can fix them in a single pass
+// It also normalises every disk size to bytes.
+func (vm *VmConfig) validate() []error {
+	var errs []error
+
+	if vm.Name == "" {
+		errs = append(errs, fmt.Errorf("name must be specified"))
+	}
+	if vm.CPUCores < 0 {
+		errs = append(errs, fmt.Errorf("cpu_cores: must not be negative, got %d", vm.CPUCores))
+	}
+	if vm.RAM < 0 {
+		errs = append(errs, fmt.Errorf("ram: must not be negative, got %d", vm.RAM))
+	}
+
+	errs = appendIfInvalid(errs, "machine_type", vm.MachineType, client.GetValidMachineTypes())
+	errs = appendIfInvalid(errs, "os_family", vm.OSFamily, client.GetValidOSFamilies())
+	errs = appendIfInvalid(errs, "rtc_base", vm.RTCBase, client.GetValidRTCBases())
+	errs = appendIfInvalid(errs, "cloud_init_data_source", vm.CloudInitDataSource, client.GetValidCloudInitDataSources())
+
+	if vm.SecureBoot && !vm.UEFI {
+		errs = append(errs, fmt.Errorf("secure_boot: requires uefi = true"))
+	}
+	if vm.ConsolePassEnabled && vm.ConsolePass == "" {
+		errs = append(errs, fmt.Errorf("console_pass: must be set when console_pass_enabled = true"))
+	}
+	if len(vm.CloudInitFiles) > 0 && vm.CloudInit == nil && (vm.CloudInitDataSource == "" || vm.CloudInitDataSource == "none") {
+		errs = append(errs, fmt.Errorf("cloud_init_data_source: must be set (e.g. \"nocloud\") when cloud_init_files are configured"))
+	}
+	if vm.PreferredNode != "" && vm.PreferredNodeName != "" {
+		errs = append(errs, fmt.Errorf("preferred_node_name: conflicts with preferred_node"))
+	}
+	if vm.SnapshotProfile != "" && vm.SnapshotProfileName != "" {
+		errs = append(errs, fmt.Errorf("snapshot_profile_name: conflicts with snapshot_profile"))
+	}
+
+	for i := range vm.VmDiskConfigs {
+		disk := &vm.VmDiskConfigs[i]
+		path := fmt.Sprintf("vm_disks[%d]", i)
+
+		errs = appendIfInvalid(errs, path+".interface", disk.Interface, client.GetValidDiskInterfaces())
+		errs = appendIfInvalid(errs, path+".media", disk.Media, client.GetValidDiskMedia())
+
+		size, err := parseDiskSize(disk.DiskSize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.disksize: %w", path, err))
+		}
+		disk.sizeBytes = size
+
+		// A source is given by key, by file name or, for clones, by VM and drive name
+		sources := 0
+		for _, set := range []bool{disk.MediaSource != 0, disk.MediaSourceName != "", disk.CloneFromVM != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			errs = append(errs, fmt.Errorf("%s: only one of media_source, media_source_name and clone_from_vm may be set", path))
+		}
+		if disk.CloneFromDrive != "" && disk.CloneFromVM == "" {
+			errs = append(errs, fmt.Errorf("%s.clone_from_drive: requires clone_from_vm", path))
+		}
+		if disk.CloneFromVM != "" && disk.Media != "clone" {
+			errs = append(errs, fmt.Errorf("%s.clone_from_vm: only valid with media = \"clone\"", path))
+		}
+		if disk.MediaSourceName != "" && disk.Media == "clone" {
+			errs = append(errs, fmt.Errorf("%s.media_source_name: names a media file, use clone_from_vm for media = \"clone\"", path))
+		}
+
+		switch disk.Media {
+		case "import", "clone":
+			if sources == 0 {
+				errs = append(errs, fmt.Errorf("%s.media_source: required when media = %q", path, disk.Media))
+			}
+		case "", "disk", "nonpersistent":
+			if disk.DiskSize == "" {
+				errs = append(errs, fmt.Errorf("%s.disksize: required for a new blank disk", path))
+			}
+			if sources > 0 {
+				errs = append(errs, fmt.Errorf("%s.media_source: only valid with media = \"import\", \"clone\" or \"cdrom\"", path))
+			}
+		}
+	}
+
+	for i, nic := range vm.VmNicConfigs {
+		path := fmt.Sprintf("vm_nics[%d]", i)
+
+		errs = appendIfInvalid(errs, path+".interface", nic.Interface, client.GetValidNicInterfaces())
+		errs = appendIfInvalid(errs, path+".driver", nic.Driver, client.GetValidNicDrivers())
+
+		switch {
+		case nic.VNET > 0 && nic.VNETName != "":
+			errs = append(errs, fmt.Errorf("%s.vnet_name: conflicts with vnet", path))
+		case nic.VNET <= 0 && nic.VNETName == "":
+			errs = append(errs, fmt.Errorf("%s.vnet: must be set to the key of an existing network, or use vnet_name", path))
+		}
+	}
+
+	return errs
+}
+
+// diskSizeUnits maps size suffixes to bytes. Like VergeIO and qemu-img, every unit is a power of 1024.
+var diskSizeUnits = map[string]int64{
+	"":  1 << 30, // a bare number is GiB, as before sizes took units
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseDiskSize turns a size such as "40", "40GiB" or "500G" into bytes. An empty size is 0.
+func parseDiskSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return 0, nil
+	}
+
+	split := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(size)
+	}
+
+	number, unit := size[:split], strings.ToLower(strings.TrimSpace(size[split:]))
+	multiplier, ok := diskSizeUnits[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional unit such as \"40GiB\" or \"500G\"", size)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", size, err)
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("invalid size %q, must be greater than zero", size)
+	}
+	bytes := value * float64(multiplier)
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q, too large", size)
+	}
+
+	// Round up to a whole MiB, fractional sizes would otherwise end in odd byte counts
+	const mib = 1 << 20
+	return (int64(bytes) + mib - 1) / mib * mib, nil
+}
+
+// appendIfInvalid appends an error for field if value is set but not one of the valid values
+func appendIfInvalid(errs []error, field string, value string, valid []string) []error {
+	if value == "" || slices.Contains(valid, value) {
+		return errs
+	}
+	return append(errs, fmt.Errorf("%s: invalid value %q, must be one of: %s", field, value, strings.Join(valid, ", ")))
+}
+
+// attachExisting reports whether the build runs on an existing VM rather than a new one
+func (c *Config) attachExisting() bool {
+	return c.ExistingVMName != "" || c.ExistingVMKey != 0
+}
+
+// useTemporarySSHKey reports whether the build generates its own SSH key pair and
+// authorizes it through cloud-init, which it does whenever no password or key is configured
+func (c *Config) useTemporarySSHKey() bool {
+	return len(c.noTemporarySSHKeyReasons()) == 0
+}
+
+// noTemporarySSHKeyReasons lists the settings that keep the build from generating a temporary SSH key
+func (c *Config) noTemporarySSHKeyReasons() []string {
+	var reasons []string
+	if c.Comm.Type != "ssh" {
+		reasons = append(reasons, fmt.Sprintf("communicator is %q", c.Comm.Type))
+	}
+	if c.Comm.SSHPassword != "" {
+		reasons = append(reasons, "ssh_password is set")
+	}
+	if c.Comm.SSHPrivateKeyFile != "" {
+		reasons = append(reasons, "ssh_private_key_file is set")
+	}
+	if c.Comm.SSHAgentAuth {
+		reasons = append(reasons, "ssh_agent_auth is set")
+	}
+	return reasons
+}
+
 // processCloudInitFiles handles loading external cloud-init files and validates configuration
 func (b *Builder) processCloudInitFiles() error {
-	for i := range b.config.VmConfig.CloudInitFiles {
-		cloudInitFile := &b.config.VmConfig.CloudInitFiles[i]
+	if err := b.loadCloudInitFiles("cloud_init_files", b.config.VmConfig.CloudInitFiles); err != nil {
+		return err
+	}
+	return b.loadCloudInitFiles("template_cloud_init_files", b.config.TemplateCloudInitFiles)
+}
+
+// loadCloudInitFiles renders and loads the entries of one cloud-init file list in place,
+// field names the list in errors
+func (b *Builder) loadCloudInitFiles(field string, files []CloudInitFile) error {
+	for i := range files {
+		cloudInitFile := &files[i]
 
 		// Check what's specified
 		hasContents := cloudInitFile.Contents != ""
 		hasFiles := len(cloudInitFile.Files) > 0
 
+		if a := cloudInitFile.Assembly; a != "" && !slices.Contains(GetValidCloudInitAssemblies(), a) {
+			return fmt.Errorf("%s[%d] (%s): assembly: invalid value %q, must be one of: %s",
+				field, i, cloudInitFile.Name, a, strings.Join(GetValidCloudInitAssemblies(), ", "))
+		}
+
 		// Validate that contents and files are mutually exclusive
 		if hasContents && hasFiles {
-			return fmt.Errorf("cloud_init_files[%d] (%s): 'contents' and 'files' are mutually exclusive", i, cloudInitFile.Name)
+			return fmt.Errorf("%s[%d] (%s): 'contents' and 'files' are mutually exclusive", field, i, cloudInitFile.Name)
 		}
 
 		// If neither is specified, skip this entry (it's optional)
@@ -316,6 +899,15 @@ func (b *Builder) processCloudInitFiles() error {
 			continue
 		}
 
+		// Inline contents are a Go template too, see renderCloudInitTemplate
+		if hasContents && !cloudInitFile.SkipTemplate {
+			contents, err := b.config.renderCloudInitTemplate(cloudInitFile.Contents, cloudInitFile.TemplateVars)
+			if err != nil {
+				return fmt.Errorf("%s[%d] (%s): template: %w", field, i, cloudInitFile.Name, err)
+			}
+			cloudInitFile.Contents = contents
+		}
+
 		// If files are specified, load and concatenate their contents
 		if hasFiles {
 			log.Printf("[Vergeio]: Loading cloud-init file '%s' from %d files: %v", cloudInitFile.Name, len(cloudInitFile.Files), cloudInitFile.Files)
@@ -329,34 +921,45 @@ func (b *Builder) processCloudInitFiles() error {
 					// Get current working directory
 					wd, err := os.Getwd()
 					if err != nil {
-						return fmt.Errorf("failed to get working directory for cloud_init_files[%d] (%s) file[%d]: %w", i, cloudInitFile.Name, j, err)
+						return fmt.Errorf("failed to get working directory for %s[%d] (%s) file[%d]: %w", field, i, cloudInitFile.Name, j, err)
 					}
 					absolutePath = filepath.Join(wd, filePath)
 				}
 
 				// Check if file exists
 				if _, err := os.Stat(absolutePath); os.IsNotExist(err) {
-					return fmt.Errorf("cloud_init_files[%d] (%s) file[%d]: file not found: %s", i, cloudInitFile.Name, j, absolutePath)
+					return fmt.Errorf("%s[%d] (%s) file[%d]: file not found: %s", field, i, cloudInitFile.Name, j, absolutePath)
 				}
 
 				// Read file contents
 				contents, err := os.ReadFile(absolutePath)
 				if err != nil {
-					return fmt.Errorf("cloud_init_files[%d] (%s) file[%d]: failed to read file %s: %w", i, cloudInitFile.Name, j, absolutePath, err)
+					return fmt.Errorf("%s[%d] (%s) file[%d]: failed to read file %s: %w", field, i, cloudInitFile.Name, j, absolutePath, err)
 				}
 
-				allContents = append(allContents, string(contents))
+				// Each file is rendered on its own, before the files are combined
+				rendered := string(contents)
+				if !cloudInitFile.SkipTemplate {
+					rendered, err = b.config.renderCloudInitTemplate(rendered, cloudInitFile.TemplateVars)
+					if err != nil {
+						return fmt.Errorf("%s[%d] (%s) file[%d]: template: %w", field, i, cloudInitFile.Name, j, err)
+					}
+				}
+
+				allContents = append(allContents, rendered)
 				log.Printf("[Vergeio]: Loaded file %s (%d bytes)", filePath, len(contents))
 			}
 
-			// Concatenate all file contents with newlines
-			cloudInitFile.Contents = ""
+			// Combine the files into one document, see assembleCloudInitFile for the modes
+			parts := make([]cloudInitPart, len(allContents))
 			for k, content := range allContents {
-				if k > 0 {
-					cloudInitFile.Contents += "\n"
-				}
-				cloudInitFile.Contents += content
+				parts[k] = cloudInitPart{Filename: filepath.Base(cloudInitFile.Files[k]), Content: content}
+			}
+			assembled, err := assembleCloudInitFile(cloudInitFile.Name, parts, cloudInitFile.Assembly)
+			if err != nil {
+				return fmt.Errorf("%s[%d] (%s): %w", field, i, cloudInitFile.Name, err)
 			}
+			cloudInitFile.Contents = assembled
 
 			// Clear the files array after loading (for security)
 			cloudInitFile.Files = nil
@@ -366,9 +969,53 @@ func (b *Builder) processCloudInitFiles() error {
 
 		// Validate that contents is not empty after loading
 		if cloudInitFile.Contents == "" {
-			return fmt.Errorf("cloud_init_files[%d] (%s): contents cannot be empty after loading files", i, cloudInitFile.Name)
+			return fmt.Errorf("%s[%d] (%s): contents cannot be empty after loading files", field, i, cloudInitFile.Name)
 		}
 	}
 
 	return nil
 }
+
+// applyCloudInitConfig renders the cloud_init block and merges the documents into
+// cloud_init_files. Settings from cloud_init_files win over the rendered ones.
+func (b *Builder) applyCloudInitConfig() error {
+	if b.config.CloudInit == nil {
+		return nil
+	}
+
+	rendered, err := renderCloudInit(b.config.CloudInit)
+	if err != nil {
+		return fmt.Errorf("cloud_init: %w", err)
+	}
+
+	// Rendered files need a datasource to be delivered through
+	if b.config.CloudInitDataSource == "" || b.config.CloudInitDataSource == "none" {
+		b.config.CloudInitDataSource = "nocloud"
+	}
+
+	for _, name := range []string{"user-data", "meta-data", "network-config"} {
+		contents, ok := rendered[name]
+		if !ok {
+			continue
+		}
+
+		existing := -1
+		for i, file := range b.config.CloudInitFiles {
+			if file.Name == name {
+				existing = i
+				break
+			}
+		}
+		if existing < 0 {
+			b.config.CloudInitFiles = append(b.config.CloudInitFiles, CloudInitFile{Name: name, Contents: contents})
+			continue
+		}
+
+		merged, err := mergeRenderedCloudInit(name, contents, b.config.CloudInitFiles[existing].Contents)
+		if err != nil {
+			return fmt.Errorf("cloud_init: %w", err)
+		}
+		b.config.CloudInitFiles[existing].Contents = merged
+	}
+	return nil
+}
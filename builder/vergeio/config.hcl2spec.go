@@ -49,6 +49,9 @@ type FlatConfig struct {
 	SSHProxyPassword          *string `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
 	SSHKeepAliveInterval      *string `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
 	SSHReadWriteTimeout       *string `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHClearAuthorizedKeys    *bool   `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHTemporaryKeyPairType   *string `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits   *int    `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
 	// WinRM-specific fields
 	WinRMUser     *string `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
 	WinRMPassword *string `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
@@ -59,12 +62,52 @@ type FlatConfig struct {
 	WinRMUseSSL   *bool   `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
 	WinRMInsecure *bool   `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
 	WinRMUseNTLM  *bool   `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	// HTTP server fields (embedded from commonsteps.HTTPConfig)
+	HTTPDir             *string           `mapstructure:"http_directory" cty:"http_directory" hcl:"http_directory"`
+	HTTPContent         map[string]string `mapstructure:"http_content" cty:"http_content" hcl:"http_content"`
+	HTTPPortMin         *int              `mapstructure:"http_port_min" cty:"http_port_min" hcl:"http_port_min"`
+	HTTPPortMax         *int              `mapstructure:"http_port_max" cty:"http_port_max" hcl:"http_port_max"`
+	HTTPAddress         *string           `mapstructure:"http_bind_address" cty:"http_bind_address" hcl:"http_bind_address"`
+	HTTPInterface       *string           `mapstructure:"http_interface" undocumented:"true" cty:"http_interface" hcl:"http_interface"`
+	HTTPNetworkProtocol *string           `mapstructure:"http_network_protocol" cty:"http_network_protocol" hcl:"http_network_protocol"`
 	// Shutdown configuration fields
-	ShutdownCommand *string `mapstructure:"shutdown_command" cty:"shutdown_command" hcl:"shutdown_command"`
-	ShutdownTimeout *string `mapstructure:"shutdown_timeout" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	ShutdownCommand      *string `mapstructure:"shutdown_command" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout      *string `mapstructure:"shutdown_timeout" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	ShutdownPollInterval *string `mapstructure:"shutdown_poll_interval" cty:"shutdown_poll_interval" hcl:"shutdown_poll_interval"`
 	// Power-on timeout configuration fields
 	PowerOnTimeout *string `mapstructure:"power_on_timeout" cty:"power_on_timeout" hcl:"power_on_timeout"`
 	BootTimeout    *string `mapstructure:"boot_timeout" cty:"boot_timeout" hcl:"boot_timeout"`
+	// Disk import configuration fields
+	DiskImportTimeout *string `mapstructure:"disk_import_timeout" cty:"disk_import_timeout" hcl:"disk_import_timeout"`
+	// Generalize configuration fields
+	Generalize        *bool   `mapstructure:"generalize" cty:"generalize" hcl:"generalize"`
+	GeneralizeTimeout *string `mapstructure:"generalize_timeout" cty:"generalize_timeout" hcl:"generalize_timeout"`
+	SysprepUnattend   *string `mapstructure:"sysprep_unattend" cty:"sysprep_unattend" hcl:"sysprep_unattend"`
+	// Compaction configuration fields
+	Compact              *bool   `mapstructure:"compact" cty:"compact" hcl:"compact"`
+	CompactTimeout       *string `mapstructure:"compact_timeout" cty:"compact_timeout" hcl:"compact_timeout"`
+	SdeletePath          *string `mapstructure:"sdelete_path" cty:"sdelete_path" hcl:"sdelete_path"`
+	ResumeFromCheckpoint *bool   `mapstructure:"resume_from_checkpoint" cty:"resume_from_checkpoint" hcl:"resume_from_checkpoint"`
+	// Template finalization fields
+	FinalizeTemplate       *bool               `mapstructure:"finalize_template" cty:"finalize_template" hcl:"finalize_template"`
+	TemplateCPUCores       *int                `mapstructure:"template_cpu_cores" cty:"template_cpu_cores" hcl:"template_cpu_cores"`
+	TemplateRAM            *int                `mapstructure:"template_ram" cty:"template_ram" hcl:"template_ram"`
+	TemplateCloudInitFiles []FlatCloudInitFile `mapstructure:"template_cloud_init_files" cty:"template_cloud_init_files" hcl:"template_cloud_init_files"`
+	KeepCloudInitFiles     *bool               `mapstructure:"keep_cloud_init_files" cty:"keep_cloud_init_files" hcl:"keep_cloud_init_files"`
+	ExistingVMName         *string             `mapstructure:"existing_vm_name" cty:"existing_vm_name" hcl:"existing_vm_name"`
+	ExistingVMKey          *int                `mapstructure:"existing_vm_key" cty:"existing_vm_key" hcl:"existing_vm_key"`
+	ExistingVMSnapshot     *bool               `mapstructure:"existing_vm_snapshot" cty:"existing_vm_snapshot" hcl:"existing_vm_snapshot"`
+	ImportSource           *string             `mapstructure:"import_source" cty:"import_source" hcl:"import_source"`
+	ImportNetworks         map[string]string   `mapstructure:"import_networks" cty:"import_networks" hcl:"import_networks"`
+	ImportDiskInterface    *string             `mapstructure:"import_disk_interface" cty:"import_disk_interface" hcl:"import_disk_interface"`
+	WarmPool               *bool               `mapstructure:"warm_pool" cty:"warm_pool" hcl:"warm_pool"`
+	WarmPoolMaxAge         *string             `mapstructure:"warm_pool_max_age" cty:"warm_pool_max_age" hcl:"warm_pool_max_age"`
+	WarmPoolRefresh        *bool               `mapstructure:"warm_pool_refresh" cty:"warm_pool_refresh" hcl:"warm_pool_refresh"`
+	WarmPoolEvict          []string            `mapstructure:"warm_pool_evict" cty:"warm_pool_evict" hcl:"warm_pool_evict"`
+	SkipIfUnchanged        *bool               `mapstructure:"skip_if_unchanged" cty:"skip_if_unchanged" hcl:"skip_if_unchanged"`
+	ContentHashFiles       []string            `mapstructure:"content_hash_files" cty:"content_hash_files" hcl:"content_hash_files"`
+	GitCommit              *string             `mapstructure:"git_commit" cty:"git_commit" hcl:"git_commit"`
+	ProvenanceFile         *string             `mapstructure:"provenance_file" cty:"provenance_file" hcl:"provenance_file"`
 	// ClusterConfig fields
 	Username *string `mapstructure:"vergeio_username" required:"false" cty:"vergeio_username" hcl:"vergeio_username"`
 	Password *string `mapstructure:"vergeio_password" required:"false" cty:"vergeio_password" hcl:"vergeio_password"`
@@ -72,44 +115,47 @@ type FlatConfig struct {
 	Endpoint *string `mapstructure:"vergeio_endpoint" required:"false" cty:"vergeio_endpoint" hcl:"vergeio_endpoint"`
 	Port     *int    `mapstructure:"vergeio_port" required:"false" cty:"vergeio_port" hcl:"vergeio_port"`
 	// VmConfig fields
-	Machine              *int                `mapstructure:"machine" required:"false" cty:"machine" hcl:"machine"`
-	Name                 *string             `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
-	Cluster              *string             `mapstructure:"cluster" required:"false" cty:"cluster" hcl:"cluster"`
-	Description          *string             `mapstructure:"description" required:"false" cty:"description" hcl:"description"`
-	Enabled              *bool               `mapstructure:"enabled" required:"false" cty:"enabled" hcl:"enabled"`
-	MachineType          *string             `mapstructure:"machine_type" required:"false" cty:"machine_type" hcl:"machine_type"`
-	AllowHotplug         *bool               `mapstructure:"allow_hotplug" required:"false" cty:"allow_hotplug" hcl:"allow_hotplug"`
-	DisablePowercycle    *bool               `mapstructure:"disable_powercycle" required:"false" cty:"disable_powercycle" hcl:"disable_powercycle"`
-	CPUCores             *int                `mapstructure:"cpu_cores" required:"false" cty:"cpu_cores" hcl:"cpu_cores"`
-	CPUType              *string             `mapstructure:"cpu_type" required:"false" cty:"cpu_type" hcl:"cpu_type"`
-	RAM                  *int                `mapstructure:"ram" required:"false" cty:"ram" hcl:"ram"`
-	Console              *string             `mapstructure:"console" required:"false" cty:"console" hcl:"console"`
-	Display              *string             `mapstructure:"display" required:"false" cty:"display" hcl:"display"`
-	Video                *string             `mapstructure:"video" required:"false" cty:"video" hcl:"video"`
-	Sound                *string             `mapstructure:"sound" required:"false" cty:"sound" hcl:"sound"`
-	OSFamily             *string             `mapstructure:"os_family" required:"false" cty:"os_family" hcl:"os_family"`
-	OSDescription        *string             `mapstructure:"os_description" required:"false" cty:"os_description" hcl:"os_description"`
-	RTCBase              *string             `mapstructure:"rtc_base" required:"false" cty:"rtc_base" hcl:"rtc_base"`
-	BootOrder            *string             `mapstructure:"boot_order" required:"false" cty:"boot_order" hcl:"boot_order"`
-	ConsolePassEnabled   *bool               `mapstructure:"console_pass_enabled" required:"false" cty:"console_pass_enabled" hcl:"console_pass_enabled"`
-	ConsolePass          *string             `mapstructure:"console_pass" required:"false" cty:"console_pass" hcl:"console_pass"`
-	USBTablet            *bool               `mapstructure:"usb_tablet" required:"false" cty:"usb_tablet" hcl:"usb_tablet"`
-	UEFI                 *bool               `mapstructure:"uefi" required:"false" cty:"uefi" hcl:"uefi"`
-	SecureBoot           *bool               `mapstructure:"secure_boot" required:"false" cty:"secure_boot" hcl:"secure_boot"`
-	SerialPort           *bool               `mapstructure:"serial_port" required:"false" cty:"serial_port" hcl:"serial_port"`
-	BootDelay            *int                `mapstructure:"boot_delay" required:"false" cty:"boot_delay" hcl:"boot_delay"`
-	PreferredNode        *string             `mapstructure:"preferred_node" required:"false" cty:"preferred_node" hcl:"preferred_node"`
-	SnapshotProfile      *string             `mapstructure:"snapshot_profile" required:"false" cty:"snapshot_profile" hcl:"snapshot_profile"`
-	CloudInitDataSource  *string             `mapstructure:"cloud_init_data_source" required:"false" cty:"cloud_init_data_source" hcl:"cloud_init_data_source"`
-	PowerState           *bool               `mapstructure:"power_state" required:"false" cty:"power_state" hcl:"power_state"`
-	GuestAgent           *bool               `mapstructure:"guest_agent" required:"false" cty:"guest_agent" hcl:"guest_agent"`
-	HAGroup              *string             `mapstructure:"ha_group" required:"false" cty:"ha_group" hcl:"ha_group"`
-	Advanced             *string             `mapstructure:"advanced" required:"false" cty:"advanced" hcl:"advanced"`
-	NestedVirtualization *bool               `mapstructure:"nested_virtualization" required:"false" cty:"nested_virtualization" hcl:"nested_virtualization"`
-	DisableHypervisor    *bool               `mapstructure:"disable_hypervisor" required:"false" cty:"disable_hypervisor" hcl:"disable_hypervisor"`
-	VmDiskConfigs        []FlatVmDiskConfig  `mapstructure:"vm_disks" required:"false" cty:"vm_disks" hcl:"vm_disks"`
-	VmNicConfigs         []FlatVmNicConfig   `mapstructure:"vm_nics" required:"false" cty:"vm_nics" hcl:"vm_nics"`
-	CloudInitFiles       []FlatCloudInitFile `mapstructure:"cloud_init_files" required:"false" cty:"cloud_init_files" hcl:"cloud_init_files"`
+	Machine              *int                 `mapstructure:"machine" required:"false" cty:"machine" hcl:"machine"`
+	Name                 *string              `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
+	Cluster              *string              `mapstructure:"cluster" required:"false" cty:"cluster" hcl:"cluster"`
+	Description          *string              `mapstructure:"description" required:"false" cty:"description" hcl:"description"`
+	Enabled              *bool                `mapstructure:"enabled" required:"false" cty:"enabled" hcl:"enabled"`
+	MachineType          *string              `mapstructure:"machine_type" required:"false" cty:"machine_type" hcl:"machine_type"`
+	AllowHotplug         *bool                `mapstructure:"allow_hotplug" required:"false" cty:"allow_hotplug" hcl:"allow_hotplug"`
+	DisablePowercycle    *bool                `mapstructure:"disable_powercycle" required:"false" cty:"disable_powercycle" hcl:"disable_powercycle"`
+	CPUCores             *int                 `mapstructure:"cpu_cores" required:"false" cty:"cpu_cores" hcl:"cpu_cores"`
+	CPUType              *string              `mapstructure:"cpu_type" required:"false" cty:"cpu_type" hcl:"cpu_type"`
+	RAM                  *int                 `mapstructure:"ram" required:"false" cty:"ram" hcl:"ram"`
+	Console              *string              `mapstructure:"console" required:"false" cty:"console" hcl:"console"`
+	Display              *string              `mapstructure:"display" required:"false" cty:"display" hcl:"display"`
+	Video                *string              `mapstructure:"video" required:"false" cty:"video" hcl:"video"`
+	Sound                *string              `mapstructure:"sound" required:"false" cty:"sound" hcl:"sound"`
+	OSFamily             *string              `mapstructure:"os_family" required:"false" cty:"os_family" hcl:"os_family"`
+	OSDescription        *string              `mapstructure:"os_description" required:"false" cty:"os_description" hcl:"os_description"`
+	RTCBase              *string              `mapstructure:"rtc_base" required:"false" cty:"rtc_base" hcl:"rtc_base"`
+	BootOrder            *string              `mapstructure:"boot_order" required:"false" cty:"boot_order" hcl:"boot_order"`
+	ConsolePassEnabled   *bool                `mapstructure:"console_pass_enabled" required:"false" cty:"console_pass_enabled" hcl:"console_pass_enabled"`
+	ConsolePass          *string              `mapstructure:"console_pass" required:"false" cty:"console_pass" hcl:"console_pass"`
+	USBTablet            *bool                `mapstructure:"usb_tablet" required:"false" cty:"usb_tablet" hcl:"usb_tablet"`
+	UEFI                 *bool                `mapstructure:"uefi" required:"false" cty:"uefi" hcl:"uefi"`
+	SecureBoot           *bool                `mapstructure:"secure_boot" required:"false" cty:"secure_boot" hcl:"secure_boot"`
+	SerialPort           *bool                `mapstructure:"serial_port" required:"false" cty:"serial_port" hcl:"serial_port"`
+	BootDelay            *int                 `mapstructure:"boot_delay" required:"false" cty:"boot_delay" hcl:"boot_delay"`
+	PreferredNode        *string              `mapstructure:"preferred_node" required:"false" cty:"preferred_node" hcl:"preferred_node"`
+	PreferredNodeName    *string              `mapstructure:"preferred_node_name" required:"false" cty:"preferred_node_name" hcl:"preferred_node_name"`
+	SnapshotProfile      *string              `mapstructure:"snapshot_profile" required:"false" cty:"snapshot_profile" hcl:"snapshot_profile"`
+	SnapshotProfileName  *string              `mapstructure:"snapshot_profile_name" required:"false" cty:"snapshot_profile_name" hcl:"snapshot_profile_name"`
+	CloudInitDataSource  *string              `mapstructure:"cloud_init_data_source" required:"false" cty:"cloud_init_data_source" hcl:"cloud_init_data_source"`
+	PowerState           *bool                `mapstructure:"power_state" required:"false" cty:"power_state" hcl:"power_state"`
+	GuestAgent           *bool                `mapstructure:"guest_agent" required:"false" cty:"guest_agent" hcl:"guest_agent"`
+	HAGroup              *string              `mapstructure:"ha_group" required:"false" cty:"ha_group" hcl:"ha_group"`
+	Advanced             *string              `mapstructure:"advanced" required:"false" cty:"advanced" hcl:"advanced"`
+	NestedVirtualization *bool                `mapstructure:"nested_virtualization" required:"false" cty:"nested_virtualization" hcl:"nested_virtualization"`
+	DisableHypervisor    *bool                `mapstructure:"disable_hypervisor" required:"false" cty:"disable_hypervisor" hcl:"disable_hypervisor"`
+	VmDiskConfigs        []FlatVmDiskConfig   `mapstructure:"vm_disks" required:"false" cty:"vm_disks" hcl:"vm_disks"`
+	VmNicConfigs         []FlatVmNicConfig    `mapstructure:"vm_nics" required:"false" cty:"vm_nics" hcl:"vm_nics"`
+	CloudInitFiles       []FlatCloudInitFile  `mapstructure:"cloud_init_files" required:"false" cty:"cloud_init_files" hcl:"cloud_init_files"`
+	CloudInit            *FlatCloudInitConfig `mapstructure:"cloud_init" required:"false" cty:"cloud_init" hcl:"cloud_init"`
 }
 
 // FlatVmDiskConfig is an auto-generated flat version of VmDiskConfig.
@@ -120,14 +166,18 @@ type FlatVmDiskConfig struct {
 	Interface           *string `mapstructure:"interface" required:"false" cty:"interface" hcl:"interface"`
 	Media               *string `mapstructure:"media" required:"false" cty:"media" hcl:"media"`
 	MediaSource         *int    `mapstructure:"media_source" required:"false" cty:"media_source" hcl:"media_source"`
+	MediaSourceName     *string `mapstructure:"media_source_name" required:"false" cty:"media_source_name" hcl:"media_source_name"`
+	CloneFromVM         *string `mapstructure:"clone_from_vm" required:"false" cty:"clone_from_vm" hcl:"clone_from_vm"`
+	CloneFromDrive      *string `mapstructure:"clone_from_drive" required:"false" cty:"clone_from_drive" hcl:"clone_from_drive"`
 	PreferredTier       *string `mapstructure:"preferred_tier" required:"false" cty:"preferred_tier" hcl:"preferred_tier"`
-	DiskSize            *int64  `mapstructure:"disksize" required:"false" cty:"disksize" hcl:"disksize"`
+	DiskSize            *string `mapstructure:"disksize" required:"false" cty:"disksize" hcl:"disksize"`
 	Enabled             *bool   `mapstructure:"enabled" required:"false" cty:"enabled" hcl:"enabled"`
 	ReadOnly            *bool   `mapstructure:"readonly" required:"false" cty:"readonly" hcl:"readonly"`
 	Serial              *string `mapstructure:"serial" required:"false" cty:"serial" hcl:"serial"`
 	Asset               *string `mapstructure:"asset" required:"false" cty:"asset" hcl:"asset"`
 	OrderId             *int    `mapstructure:"orderid" required:"false" cty:"orderid" hcl:"orderid"`
 	PreserveDriveFormat *bool   `mapstructure:"preserve_drive_format" required:"false" cty:"preserve_drive_format" hcl:"preserve_drive_format"`
+	BuildOnly           *bool   `mapstructure:"build_only" required:"false" cty:"build_only" hcl:"build_only"`
 }
 
 // FlatVmNicConfig is an auto-generated flat version of VmNicConfig.
@@ -139,17 +189,55 @@ type FlatVmNicConfig struct {
 	Driver          *string `mapstructure:"driver" required:"false" cty:"driver" hcl:"driver"`
 	Model           *string `mapstructure:"model" required:"false" cty:"model" hcl:"model"`
 	VNET            *int    `mapstructure:"vnet" required:"false" cty:"vnet" hcl:"vnet"`
+	VNETName        *string `mapstructure:"vnet_name" required:"false" cty:"vnet_name" hcl:"vnet_name"`
 	MAC             *string `mapstructure:"macaddress" required:"false" cty:"macaddress" hcl:"macaddress"`
 	IPAddress       *string `mapstructure:"ipaddress" required:"false" cty:"ipaddress" hcl:"ipaddress"`
 	AssignIPAddress *bool   `mapstructure:"assign_ipaddress" required:"false" cty:"assign_ipaddress" hcl:"assign_ipaddress"`
 	Enabled         *bool   `mapstructure:"enabled" required:"false" cty:"enabled" hcl:"enabled"`
+	BuildOnly       *bool   `mapstructure:"build_only" required:"false" cty:"build_only" hcl:"build_only"`
 }
 
 // FlatCloudInitFile is an auto-generated flat version of CloudInitFile.
 type FlatCloudInitFile struct {
-	Name     *string  `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
-	Contents *string  `mapstructure:"contents" required:"false" cty:"contents" hcl:"contents"`
-	Files    []string `mapstructure:"files" required:"false" cty:"files" hcl:"files"`
+	Name         *string           `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
+	Contents     *string           `mapstructure:"contents" required:"false" cty:"contents" hcl:"contents"`
+	Files        []string          `mapstructure:"files" required:"false" cty:"files" hcl:"files"`
+	Assembly     *string           `mapstructure:"assembly" required:"false" cty:"assembly" hcl:"assembly"`
+	TemplateVars map[string]string `mapstructure:"template_vars" required:"false" cty:"template_vars" hcl:"template_vars"`
+	SkipTemplate *bool             `mapstructure:"skip_template" required:"false" cty:"skip_template" hcl:"skip_template"`
+}
+
+// FlatCloudInitConfig is an auto-generated flat version of CloudInitConfig.
+type FlatCloudInitConfig struct {
+	Hostname   *string                      `mapstructure:"hostname" required:"false" cty:"hostname" hcl:"hostname"`
+	Users      []FlatCloudInitUser          `mapstructure:"users" required:"false" cty:"users" hcl:"users"`
+	Packages   []string                     `mapstructure:"packages" required:"false" cty:"packages" hcl:"packages"`
+	Runcmd     []string                     `mapstructure:"runcmd" required:"false" cty:"runcmd" hcl:"runcmd"`
+	WriteFiles []FlatCloudInitWriteFile     `mapstructure:"write_files" required:"false" cty:"write_files" hcl:"write_files"`
+	Network    []FlatCloudInitNetworkConfig `mapstructure:"network" required:"false" cty:"network" hcl:"network"`
+}
+
+// FlatCloudInitUser is an auto-generated flat version of CloudInitUser.
+type FlatCloudInitUser struct {
+	Name              *string  `mapstructure:"name" required:"true" cty:"name" hcl:"name"`
+	SSHAuthorizedKeys []string `mapstructure:"ssh_authorized_keys" required:"false" cty:"ssh_authorized_keys" hcl:"ssh_authorized_keys"`
+	Sudo              *string  `mapstructure:"sudo" required:"false" cty:"sudo" hcl:"sudo"`
+}
+
+// FlatCloudInitWriteFile is an auto-generated flat version of CloudInitWriteFile.
+type FlatCloudInitWriteFile struct {
+	Path        *string `mapstructure:"path" required:"true" cty:"path" hcl:"path"`
+	Content     *string `mapstructure:"content" required:"false" cty:"content" hcl:"content"`
+	Owner       *string `mapstructure:"owner" required:"false" cty:"owner" hcl:"owner"`
+	Permissions *string `mapstructure:"permissions" required:"false" cty:"permissions" hcl:"permissions"`
+}
+
+// FlatCloudInitNetworkConfig is an auto-generated flat version of CloudInitNetworkConfig.
+type FlatCloudInitNetworkConfig struct {
+	NIC     *string  `mapstructure:"nic" required:"true" cty:"nic" hcl:"nic"`
+	Address *string  `mapstructure:"address" required:"false" cty:"address" hcl:"address"`
+	Gateway *string  `mapstructure:"gateway" required:"false" cty:"gateway" hcl:"gateway"`
+	DNS     []string `mapstructure:"dns" required:"false" cty:"dns" hcl:"dns"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -217,6 +305,9 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"ssh_proxy_password":           &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
 		"ssh_keep_alive_interval":      &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
 		"ssh_read_write_timeout":       &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
+		"ssh_clear_authorized_keys":    &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
+		"temporary_key_pair_type":      &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
+		"temporary_key_pair_bits":      &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
 		// WinRM-specific configuration fields
 		"winrm_username": &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
 		"winrm_password": &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
@@ -227,12 +318,50 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"winrm_use_ssl":  &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
 		"winrm_insecure": &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
 		"winrm_use_ntlm": &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		// HTTP server fields
+		"http_directory":        &hcldec.AttrSpec{Name: "http_directory", Type: cty.String, Required: false},
+		"http_content":          &hcldec.AttrSpec{Name: "http_content", Type: cty.Map(cty.String), Required: false},
+		"http_port_min":         &hcldec.AttrSpec{Name: "http_port_min", Type: cty.Number, Required: false},
+		"http_port_max":         &hcldec.AttrSpec{Name: "http_port_max", Type: cty.Number, Required: false},
+		"http_bind_address":     &hcldec.AttrSpec{Name: "http_bind_address", Type: cty.String, Required: false},
+		"http_interface":        &hcldec.AttrSpec{Name: "http_interface", Type: cty.String, Required: false},
+		"http_network_protocol": &hcldec.AttrSpec{Name: "http_network_protocol", Type: cty.String, Required: false},
 		// Shutdown configuration fields
-		"shutdown_command": &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
-		"shutdown_timeout": &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
+		"shutdown_command":       &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
+		"shutdown_timeout":       &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
+		"shutdown_poll_interval": &hcldec.AttrSpec{Name: "shutdown_poll_interval", Type: cty.String, Required: false},
 		// Power-on timeout configuration fields
 		"power_on_timeout": &hcldec.AttrSpec{Name: "power_on_timeout", Type: cty.String, Required: false},
 		"boot_timeout":     &hcldec.AttrSpec{Name: "boot_timeout", Type: cty.String, Required: false},
+		// Disk import configuration fields
+		"disk_import_timeout": &hcldec.AttrSpec{Name: "disk_import_timeout", Type: cty.String, Required: false},
+		// Generalize configuration fields
+		"generalize":                &hcldec.AttrSpec{Name: "generalize", Type: cty.Bool, Required: false},
+		"generalize_timeout":        &hcldec.AttrSpec{Name: "generalize_timeout", Type: cty.String, Required: false},
+		"sysprep_unattend":          &hcldec.AttrSpec{Name: "sysprep_unattend", Type: cty.String, Required: false},
+		"compact":                   &hcldec.AttrSpec{Name: "compact", Type: cty.Bool, Required: false},
+		"compact_timeout":           &hcldec.AttrSpec{Name: "compact_timeout", Type: cty.String, Required: false},
+		"sdelete_path":              &hcldec.AttrSpec{Name: "sdelete_path", Type: cty.String, Required: false},
+		"resume_from_checkpoint":    &hcldec.AttrSpec{Name: "resume_from_checkpoint", Type: cty.Bool, Required: false},
+		"finalize_template":         &hcldec.AttrSpec{Name: "finalize_template", Type: cty.Bool, Required: false},
+		"template_cpu_cores":        &hcldec.AttrSpec{Name: "template_cpu_cores", Type: cty.Number, Required: false},
+		"template_ram":              &hcldec.AttrSpec{Name: "template_ram", Type: cty.Number, Required: false},
+		"template_cloud_init_files": &hcldec.BlockListSpec{TypeName: "template_cloud_init_files", Nested: hcldec.ObjectSpec((*FlatCloudInitFile)(nil).HCL2Spec())},
+		"keep_cloud_init_files":     &hcldec.AttrSpec{Name: "keep_cloud_init_files", Type: cty.Bool, Required: false},
+		"existing_vm_name":          &hcldec.AttrSpec{Name: "existing_vm_name", Type: cty.String, Required: false},
+		"existing_vm_key":           &hcldec.AttrSpec{Name: "existing_vm_key", Type: cty.Number, Required: false},
+		"existing_vm_snapshot":      &hcldec.AttrSpec{Name: "existing_vm_snapshot", Type: cty.Bool, Required: false},
+		"import_source":             &hcldec.AttrSpec{Name: "import_source", Type: cty.String, Required: false},
+		"import_networks":           &hcldec.AttrSpec{Name: "import_networks", Type: cty.Map(cty.String), Required: false},
+		"import_disk_interface":     &hcldec.AttrSpec{Name: "import_disk_interface", Type: cty.String, Required: false},
+		"warm_pool":                 &hcldec.AttrSpec{Name: "warm_pool", Type: cty.Bool, Required: false},
+		"warm_pool_max_age":         &hcldec.AttrSpec{Name: "warm_pool_max_age", Type: cty.String, Required: false},
+		"warm_pool_refresh":         &hcldec.AttrSpec{Name: "warm_pool_refresh", Type: cty.Bool, Required: false},
+		"warm_pool_evict":           &hcldec.AttrSpec{Name: "warm_pool_evict", Type: cty.List(cty.String), Required: false},
+		"skip_if_unchanged":         &hcldec.AttrSpec{Name: "skip_if_unchanged", Type: cty.Bool, Required: false},
+		"content_hash_files":        &hcldec.AttrSpec{Name: "content_hash_files", Type: cty.List(cty.String), Required: false},
+		"git_commit":                &hcldec.AttrSpec{Name: "git_commit", Type: cty.String, Required: false},
+		"provenance_file":           &hcldec.AttrSpec{Name: "provenance_file", Type: cty.String, Required: false},
 		// ClusterConfig fields
 		"vergeio_username": &hcldec.AttrSpec{Name: "vergeio_username", Type: cty.String, Required: false},
 		"vergeio_password": &hcldec.AttrSpec{Name: "vergeio_password", Type: cty.String, Required: false},
@@ -267,7 +396,9 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"serial_port":            &hcldec.AttrSpec{Name: "serial_port", Type: cty.Bool, Required: false},
 		"boot_delay":             &hcldec.AttrSpec{Name: "boot_delay", Type: cty.Number, Required: false},
 		"preferred_node":         &hcldec.AttrSpec{Name: "preferred_node", Type: cty.String, Required: false},
+		"preferred_node_name":    &hcldec.AttrSpec{Name: "preferred_node_name", Type: cty.String, Required: false},
 		"snapshot_profile":       &hcldec.AttrSpec{Name: "snapshot_profile", Type: cty.String, Required: false},
+		"snapshot_profile_name":  &hcldec.AttrSpec{Name: "snapshot_profile_name", Type: cty.String, Required: false},
 		"cloud_init_data_source": &hcldec.AttrSpec{Name: "cloud_init_data_source", Type: cty.String, Required: false},
 		"power_state":            &hcldec.AttrSpec{Name: "power_state", Type: cty.Bool, Required: false},
 		"guest_agent":            &hcldec.AttrSpec{Name: "guest_agent", Type: cty.Bool, Required: false},
@@ -278,6 +409,7 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"vm_disks":               &hcldec.BlockListSpec{TypeName: "vm_disks", Nested: hcldec.ObjectSpec((*FlatVmDiskConfig)(nil).HCL2Spec())},
 		"vm_nics":                &hcldec.BlockListSpec{TypeName: "vm_nics", Nested: hcldec.ObjectSpec((*FlatVmNicConfig)(nil).HCL2Spec())},
 		"cloud_init_files":       &hcldec.BlockListSpec{TypeName: "cloud_init_files", Nested: hcldec.ObjectSpec((*FlatCloudInitFile)(nil).HCL2Spec())},
+		"cloud_init":             &hcldec.BlockSpec{TypeName: "cloud_init", Nested: hcldec.ObjectSpec((*FlatCloudInitConfig)(nil).HCL2Spec())},
 	}
 	return s
 }
@@ -291,14 +423,18 @@ func (*FlatVmDiskConfig) HCL2Spec() map[string]hcldec.Spec {
 		"interface":             &hcldec.AttrSpec{Name: "interface", Type: cty.String, Required: false},
 		"media":                 &hcldec.AttrSpec{Name: "media", Type: cty.String, Required: false},
 		"media_source":          &hcldec.AttrSpec{Name: "media_source", Type: cty.Number, Required: false},
+		"media_source_name":     &hcldec.AttrSpec{Name: "media_source_name", Type: cty.String, Required: false},
+		"clone_from_vm":         &hcldec.AttrSpec{Name: "clone_from_vm", Type: cty.String, Required: false},
+		"clone_from_drive":      &hcldec.AttrSpec{Name: "clone_from_drive", Type: cty.String, Required: false},
 		"preferred_tier":        &hcldec.AttrSpec{Name: "preferred_tier", Type: cty.String, Required: false},
-		"disksize":              &hcldec.AttrSpec{Name: "disksize", Type: cty.Number, Required: false},
+		"disksize":              &hcldec.AttrSpec{Name: "disksize", Type: cty.String, Required: false},
 		"enabled":               &hcldec.AttrSpec{Name: "enabled", Type: cty.Bool, Required: false},
 		"readonly":              &hcldec.AttrSpec{Name: "readonly", Type: cty.Bool, Required: false},
 		"serial":                &hcldec.AttrSpec{Name: "serial", Type: cty.String, Required: false},
 		"asset":                 &hcldec.AttrSpec{Name: "asset", Type: cty.String, Required: false},
 		"orderid":               &hcldec.AttrSpec{Name: "orderid", Type: cty.Number, Required: false},
 		"preserve_drive_format": &hcldec.AttrSpec{Name: "preserve_drive_format", Type: cty.Bool, Required: false},
+		"build_only":            &hcldec.AttrSpec{Name: "build_only", Type: cty.Bool, Required: false},
 	}
 	return s
 }
@@ -313,10 +449,12 @@ func (*FlatVmNicConfig) HCL2Spec() map[string]hcldec.Spec {
 		"driver":           &hcldec.AttrSpec{Name: "driver", Type: cty.String, Required: false},
 		"model":            &hcldec.AttrSpec{Name: "model", Type: cty.String, Required: false},
 		"vnet":             &hcldec.AttrSpec{Name: "vnet", Type: cty.Number, Required: false},
+		"vnet_name":        &hcldec.AttrSpec{Name: "vnet_name", Type: cty.String, Required: false},
 		"macaddress":       &hcldec.AttrSpec{Name: "macaddress", Type: cty.String, Required: false},
 		"ipaddress":        &hcldec.AttrSpec{Name: "ipaddress", Type: cty.String, Required: false},
 		"assign_ipaddress": &hcldec.AttrSpec{Name: "assign_ipaddress", Type: cty.Bool, Required: false},
 		"enabled":          &hcldec.AttrSpec{Name: "enabled", Type: cty.Bool, Required: false},
+		"build_only":       &hcldec.AttrSpec{Name: "build_only", Type: cty.Bool, Required: false},
 	}
 	return s
 }
@@ -324,9 +462,77 @@ func (*FlatVmNicConfig) HCL2Spec() map[string]hcldec.Spec {
 // HCL2Spec returns the hcl spec of a CloudInitFile.
 func (*FlatCloudInitFile) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"name":     &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
-		"contents": &hcldec.AttrSpec{Name: "contents", Type: cty.String, Required: false},
-		"files":    &hcldec.AttrSpec{Name: "files", Type: cty.List(cty.String), Required: false},
+		"name":          &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"contents":      &hcldec.AttrSpec{Name: "contents", Type: cty.String, Required: false},
+		"files":         &hcldec.AttrSpec{Name: "files", Type: cty.List(cty.String), Required: false},
+		"assembly":      &hcldec.AttrSpec{Name: "assembly", Type: cty.String, Required: false},
+		"template_vars": &hcldec.AttrSpec{Name: "template_vars", Type: cty.Map(cty.String), Required: false},
+		"skip_template": &hcldec.AttrSpec{Name: "skip_template", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatMapstructure returns a new FlatCloudInitConfig.
+func (*CloudInitConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatCloudInitConfig)
+}
+
+// HCL2Spec returns the hcl spec of a CloudInitConfig.
+func (*FlatCloudInitConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"hostname":    &hcldec.AttrSpec{Name: "hostname", Type: cty.String, Required: false},
+		"users":       &hcldec.BlockListSpec{TypeName: "users", Nested: hcldec.ObjectSpec((*FlatCloudInitUser)(nil).HCL2Spec())},
+		"packages":    &hcldec.AttrSpec{Name: "packages", Type: cty.List(cty.String), Required: false},
+		"runcmd":      &hcldec.AttrSpec{Name: "runcmd", Type: cty.List(cty.String), Required: false},
+		"write_files": &hcldec.BlockListSpec{TypeName: "write_files", Nested: hcldec.ObjectSpec((*FlatCloudInitWriteFile)(nil).HCL2Spec())},
+		"network":     &hcldec.BlockListSpec{TypeName: "network", Nested: hcldec.ObjectSpec((*FlatCloudInitNetworkConfig)(nil).HCL2Spec())},
+	}
+	return s
+}
+
+// FlatMapstructure returns a new FlatCloudInitUser.
+func (*CloudInitUser) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatCloudInitUser)
+}
+
+// HCL2Spec returns the hcl spec of a CloudInitUser.
+func (*FlatCloudInitUser) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"name":                &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"ssh_authorized_keys": &hcldec.AttrSpec{Name: "ssh_authorized_keys", Type: cty.List(cty.String), Required: false},
+		"sudo":                &hcldec.AttrSpec{Name: "sudo", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatMapstructure returns a new FlatCloudInitWriteFile.
+func (*CloudInitWriteFile) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatCloudInitWriteFile)
+}
+
+// HCL2Spec returns the hcl spec of a CloudInitWriteFile.
+func (*FlatCloudInitWriteFile) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"path":        &hcldec.AttrSpec{Name: "path", Type: cty.String, Required: false},
+		"content":     &hcldec.AttrSpec{Name: "content", Type: cty.String, Required: false},
+		"owner":       &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"permissions": &hcldec.AttrSpec{Name: "permissions", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatMapstructure returns a new FlatCloudInitNetworkConfig.
+func (*CloudInitNetworkConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatCloudInitNetworkConfig)
+}
+
+// HCL2Spec returns the hcl spec of a CloudInitNetworkConfig.
+func (*FlatCloudInitNetworkConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"nic":     &hcldec.AttrSpec{Name: "nic", Type: cty.String, Required: false},
+		"address": &hcldec.AttrSpec{Name: "address", Type: cty.String, Required: false},
+		"gateway": &hcldec.AttrSpec{Name: "gateway", Type: cty.String, Required: false},
+		"dns":     &hcldec.AttrSpec{Name: "dns", Type: cty.List(cty.String), Required: false},
 	}
 	return s
 }
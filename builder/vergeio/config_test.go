@@ -0,0 +1,592 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package vergeio
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"vergeio_endpoint": "cluster.example.com",
+		"vergeio_username": "admin",
+		"vergeio_password": "secret",
+		"name":             "packer-test",
+		"ssh_username":     "packer",
+		"ssh_password":     "packer",
+		"machine_type":     "q35",
+		"os_family":        "linux",
+		"vm_disks": []map[string]interface{}{
+			{"name": "os", "interface": "virtio-scsi", "media": "import", "media_source": 12},
+		},
+		"vm_nics": []map[string]interface{}{
+			{"name": "nic", "interface": "virtio", "vnet": 3},
+		},
+	}
+}
+
+func TestBuilderPrepare_Valid(t *testing.T) {
+	var b Builder
+	_, warnings, err := b.Prepare(testConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+}
+
+func TestBuilderPrepare_InvalidVMConfig(t *testing.T) {
+	raw := testConfig()
+	raw["machine_type"] = "q53"
+	raw["os_family"] = "linus"
+	raw["rtc_base"] = "gmt"
+	raw["secure_boot"] = true
+	raw["vm_disks"] = []map[string]interface{}{
+		{"name": "os", "interface": "virtio-scsi", "media": "import", "media_source": 12},
+		{"name": "data", "interface": "scsi", "media": "blank", "disksize": 10},
+		{"name": "clone", "media": "clone"},
+	}
+	raw["vm_nics"] = []map[string]interface{}{
+		{"name": "nic", "interface": "virtio", "driver": "virtio-net"},
+	}
+
+	var b Builder
+	_, _, err := b.Prepare(raw)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	multiErr, ok := err.(*packer.MultiError)
+	if !ok {
+		t.Fatalf("expected a *packer.MultiError, got %T", err)
+	}
+
+	expected := []string{
+		"machine_type:",
+		"os_family:",
+		"rtc_base:",
+		"secure_boot:",
+		"vm_disks[1].interface:",
+		"vm_disks[1].media:",
+		"vm_disks[2].media_source:",
+		"vm_nics[0].driver:",
+		"vm_nics[0].vnet:",
+	}
+	for _, prefix := range expected {
+		found := false
+		for _, e := range multiErr.Errors {
+			if strings.HasPrefix(e.Error(), prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected an error starting with %q in %s", prefix, err)
+		}
+	}
+}
+
+func TestBuilderPrepare_NameReferences(t *testing.T) {
+	raw := testConfig()
+	raw["preferred_node_name"] = "node1"
+	raw["vm_disks"] = []map[string]interface{}{
+		{"name": "os", "interface": "virtio-scsi", "media": "import", "media_source_name": "ubuntu-24.04.qcow2"},
+		{"name": "data", "interface": "virtio-scsi", "media": "clone", "clone_from_vm": "golden", "clone_from_drive": "data"},
+	}
+	raw["vm_nics"] = []map[string]interface{}{
+		{"name": "nic", "interface": "virtio", "vnet_name": "External"},
+	}
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	raw["preferred_node"] = "1"
+	raw["vm_disks"] = []map[string]interface{}{
+		{"name": "os", "media": "import", "media_source": 12, "media_source_name": "ubuntu-24.04.qcow2"},
+		{"name": "data", "media": "import", "clone_from_drive": "data", "media_source": 12},
+	}
+	raw["vm_nics"] = []map[string]interface{}{
+		{"name": "nic", "vnet": 3, "vnet_name": "External"},
+	}
+
+	_, _, err := new(Builder).Prepare(raw)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, prefix := range []string{
+		"preferred_node_name:",
+		"vm_disks[0]: only one of",
+		"vm_disks[1].clone_from_drive:",
+		"vm_nics[0].vnet_name:",
+	} {
+		if !strings.Contains(err.Error(), prefix) {
+			t.Errorf("expected an error starting with %q in %s", prefix, err)
+		}
+	}
+}
+
+func TestParseDiskSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "40", want: 40 << 30},
+		{in: "40GiB", want: 40 << 30},
+		{in: "500G", want: 500 << 30},
+		{in: "500 GB", want: 500 << 30},
+		{in: "1.5TiB", want: 3 << 39},
+		{in: "512m", want: 512 << 20},
+		{in: "1000000B", want: 1 << 20},
+		{in: "0", wantErr: true},
+		{in: "-5G", wantErr: true},
+		{in: "40 parsecs", wantErr: true},
+		{in: "GiB", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := parseDiskSize(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseDiskSize(%q): expected an error, got %d", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDiskSize(%q): unexpected error: %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseDiskSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestBuilderPrepare_DiskSizeNormalised(t *testing.T) {
+	raw := testConfig()
+	raw["vm_disks"] = []map[string]interface{}{
+		{"name": "os", "media": "import", "media_source": 12, "disksize": "40GiB"},
+		{"name": "data", "media": "disk", "disksize": 10},
+	}
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	disks := b.config.VmConfig.VmDiskConfigs
+	if disks[0].sizeBytes != 40<<30 || disks[1].sizeBytes != 10<<30 {
+		t.Fatalf("unexpected sizes: %d, %d", disks[0].sizeBytes, disks[1].sizeBytes)
+	}
+}
+
+func TestBuilderPrepare_CloudInitBlock(t *testing.T) {
+	raw := testConfig()
+	raw["cloud_init"] = map[string]interface{}{
+		"hostname": "builder",
+		"users": []map[string]interface{}{
+			{"name": "ops", "sudo": "ALL=(ALL) NOPASSWD:ALL", "ssh_authorized_keys": []string{"ssh-ed25519 AAAAops"}},
+		},
+		"packages": []string{"curl"},
+		"network": []map[string]interface{}{
+			{"nic": "eth0", "address": "192.168.10.20/24", "gateway": "192.168.10.1", "dns": []string{"1.1.1.1"}},
+		},
+	}
+	raw["cloud_init_files"] = []map[string]interface{}{
+		{"name": "user-data", "contents": "#cloud-config\npackages: [git]\n"},
+	}
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	files := make(map[string]string)
+	for _, file := range b.config.CloudInitFiles {
+		files[file.Name] = file.Contents
+	}
+	if userData := files["user-data"]; !strings.Contains(userData, "curl") || !strings.Contains(userData, "git") ||
+		!strings.Contains(userData, "ssh-ed25519 AAAAops") {
+		t.Fatalf("rendered user-data was not merged with the file:\n%s", userData)
+	}
+	if !strings.Contains(files["meta-data"], "local-hostname: builder") {
+		t.Fatalf("unexpected meta-data:\n%s", files["meta-data"])
+	}
+	if !strings.Contains(files["network-config"], "192.168.10.20/24") || !strings.Contains(files["network-config"], "via: 192.168.10.1") {
+		t.Fatalf("unexpected network-config:\n%s", files["network-config"])
+	}
+	if b.config.CloudInitDataSource != "nocloud" {
+		t.Fatalf("expected the nocloud datasource, got %q", b.config.CloudInitDataSource)
+	}
+
+	var step StepPowerOn
+	if ip, err := step.extractIPFromCloudInit(&b.config); err != nil || ip != "192.168.10.20" {
+		t.Fatalf("expected the static IP from the cloud_init block, got %q (%v)", ip, err)
+	}
+
+	raw["cloud_init"] = map[string]interface{}{
+		"network": []map[string]interface{}{{"nic": "eth0", "address": "192.168.10.20"}},
+	}
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "CIDR") {
+		t.Fatalf("expected a CIDR error, got %v", err)
+	}
+}
+
+func TestBuilderPrepare_CloudInitTemplate(t *testing.T) {
+	// Inline contents are always checked as templates by Packer, skip_template needs files
+	metaData := filepath.Join(t.TempDir(), "meta-data.yml")
+	if err := os.WriteFile(metaData, []byte("local-hostname: {{ not a template"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := testConfig()
+	delete(raw, "ssh_password")
+	raw["cloud_init_data_source"] = "nocloud"
+	raw["cloud_init_files"] = []map[string]interface{}{
+		{
+			"name":          "user-data",
+			"contents":      "#cloud-config\nhostname: {{ .Name }}-{{ .Vars.site }}\nssh_authorized_keys:\n  - {{ .SSHPublicKey }}\n",
+			"template_vars": map[string]string{"site": "ams"},
+		},
+		{"name": "meta-data", "files": []string{metaData}, "skip_template": true},
+	}
+
+	var b Builder
+	_, _, err := b.Prepare(raw)
+	if err == nil || !strings.Contains(err.Error(), "meta-data") {
+		t.Fatalf("expected the untemplated meta-data to fail YAML validation, got %v", err)
+	}
+
+	if err := os.WriteFile(metaData, []byte("local-hostname: '{{ literal }}'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b = Builder{}
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	userData := resolveCloudInitPlaceholders(b.config.CloudInitFiles[0].Contents, "ssh-ed25519 AAAAkey\n", "", 0)
+	if !strings.Contains(userData, "hostname: packer-test-ams") || !strings.Contains(userData, "- ssh-ed25519 AAAAkey\n") {
+		t.Fatalf("unexpected rendered user-data:\n%s", userData)
+	}
+	if b.config.CloudInitFiles[1].Contents != "local-hostname: '{{ literal }}'\n" {
+		t.Fatalf("skip_template contents were changed: %q", b.config.CloudInitFiles[1].Contents)
+	}
+
+	raw["ssh_private_key_file"] = "id_ed25519"
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "SSHPublicKey") {
+		t.Fatalf("expected an error for SSHPublicKey without a temporary key, got %v", err)
+	}
+
+	delete(raw, "ssh_private_key_file")
+	raw["ssh_password"] = "packer"
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "because ssh_password is set") {
+		t.Fatalf("expected the error to name ssh_password, got %v", err)
+	}
+}
+
+func TestBuilderPrepare_ReusedInstanceIDWarning(t *testing.T) {
+	t.Setenv("PACKER_CACHE_DIR", t.TempDir())
+
+	raw := testConfig()
+	raw["cloud_init_data_source"] = "nocloud"
+	raw["cloud_init_files"] = []map[string]interface{}{
+		{"name": "meta-data", "contents": "instance-id: packer-test-vm\n"},
+	}
+
+	if _, warnings, err := new(Builder).Prepare(raw); err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warning before the instance-id was used, got %v, %v", warnings, err)
+	}
+
+	if err := recordInstanceID("packer-test-vm"); err != nil {
+		t.Fatal(err)
+	}
+	_, warnings, err := new(Builder).Prepare(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "packer-test-vm") {
+		t.Fatalf("expected a warning for the reused instance-id, got %v", warnings)
+	}
+}
+
+func TestBuilderPrepare_TemplateCloudInitFiles(t *testing.T) {
+	raw := testConfig()
+	raw["cloud_init_data_source"] = "nocloud"
+	raw["template_cloud_init_files"] = []map[string]interface{}{
+		{"name": "user-data", "contents": "#cloud-config\nhostname: {{ .Name }}\n"},
+	}
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := b.config.TemplateCloudInitFiles[0].Contents; got != "#cloud-config\nhostname: packer-test\n" {
+		t.Fatalf("unexpected rendered template file: %q", got)
+	}
+
+	raw["keep_cloud_init_files"] = true
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "keep_cloud_init_files") {
+		t.Fatalf("expected a conflict error, got %v", err)
+	}
+
+	delete(raw, "keep_cloud_init_files")
+	raw["template_cloud_init_files"] = []map[string]interface{}{
+		{"name": "user-data", "contents": "#cloud-config\nssh_authorized_keys:\n  - {{ .SSHPublicKey }}\n"},
+	}
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "only exist during the build") {
+		t.Fatalf("expected an error for a build value in a template file, got %v", err)
+	}
+}
+
+func TestBuilderPrepare_ResumeFromCheckpoint(t *testing.T) {
+	raw := testConfig()
+	raw["resume_from_checkpoint"] = true
+
+	var b Builder
+	generated, _, err := b.Prepare(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(generated) < 2 || generated[0] != "CheckpointPrefix" || generated[1] != "CompletedCheckpoints" {
+		t.Fatalf("expected the checkpoint build variables, got %v", generated)
+	}
+
+	prefix := func(b *Builder) string {
+		prefix, err := b.config.checkpointPrefix()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return prefix
+	}
+
+	var again Builder
+	if _, _, err := again.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if prefix(&b) != prefix(&again) {
+		t.Fatalf("checkpoint prefix is not stable: %s != %s", prefix(&b), prefix(&again))
+	}
+
+	raw["cpu_cores"] = 8
+	var changed Builder
+	if _, _, err := changed.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if prefix(&b) == prefix(&changed) {
+		t.Fatal("a changed VM configuration must change the checkpoint prefix")
+	}
+
+	delete(raw, "ssh_password")
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "resume_from_checkpoint") {
+		t.Fatalf("expected an error for resuming with a temporary SSH key, got %v", err)
+	}
+}
+
+func TestBuilderPrepare_ExistingVM(t *testing.T) {
+	raw := testConfig()
+	delete(raw, "vm_disks")
+	delete(raw, "vm_nics")
+	raw["existing_vm_name"] = "reference-vm"
+	raw["existing_vm_snapshot"] = true
+	raw["guest_agent"] = true
+
+	var b Builder
+	_, warnings, err := b.Prepare(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if !b.config.attachExisting() {
+		t.Fatal("expected the build to attach to the existing VM")
+	}
+
+	raw["existing_vm_key"] = 42
+	raw["name"] = "reference-vm"
+	raw["generalize"] = true
+	raw["vm_nics"] = testConfig()["vm_nics"]
+	_, _, err = new(Builder).Prepare(raw)
+	if err == nil {
+		t.Fatal("expected errors for an invalid existing VM config")
+	}
+	for _, field := range []string{"existing_vm_key", "name", "generalize", "vm_nics"} {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("expected an error for %s, got: %s", field, err)
+		}
+	}
+}
+
+func TestBuilderPrepare_WarmPool(t *testing.T) {
+	raw := testConfig()
+	raw["warm_pool"] = true
+	raw["warm_pool_max_age"] = "24h"
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	poolName := func(b *Builder) string {
+		name, err := b.config.warmPoolName()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return name
+	}
+
+	raw["name"] = "packer-test-2"
+	var renamed Builder
+	if _, _, err := renamed.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if poolName(&b) != poolName(&renamed) {
+		t.Fatal("the template name must not change the warm pool entry")
+	}
+
+	raw["cpu_cores"] = 8
+	var changed Builder
+	if _, _, err := changed.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if poolName(&b) == poolName(&changed) {
+		t.Fatal("a changed VM configuration must change the warm pool entry")
+	}
+
+	raw["warm_pool_evict"] = []string{WarmPoolPrefix}
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "warm_pool_evict[0]") {
+		t.Fatalf("expected an error for an empty entry, got %v", err)
+	}
+	delete(raw, "warm_pool_evict")
+
+	step := &StepWarmPoolClone{Evict: []string{"0123456789abcdef", WarmPoolPrefix + "fedcba9876543210"}}
+	if !step.evicted(WarmPoolPrefix+"0123456789abcdef") || !step.evicted(WarmPoolPrefix+"fedcba9876543210") || step.evicted(poolName(&b)) {
+		t.Fatal("entries must be evicted by pool key or entry name only")
+	}
+
+	raw["resume_from_checkpoint"] = true
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "warm_pool: conflicts") {
+		t.Fatalf("expected a conflict with resume_from_checkpoint, got %v", err)
+	}
+}
+
+func TestBuilderPrepare_ContentHash(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "install.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\napt-get install -y nginx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := testConfig()
+	raw["skip_if_unchanged"] = true
+	raw["content_hash_files"] = []string{filepath.Join(dir, "*.sh")}
+
+	var b Builder
+	if _, _, err := b.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hashes := func(b *Builder) (string, string) {
+		hash, err := b.config.contentHash()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		prefix, err := b.config.checkpointPrefix()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return hash, prefix
+	}
+
+	var same Builder
+	if _, _, err := same.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hash, prefix := hashes(&b)
+	if sameHash, _ := hashes(&same); hash != sameHash {
+		t.Fatal("the content hash must be deterministic")
+	}
+
+	if err := os.WriteFile(script, []byte("#!/bin/sh\napt-get install -y apache2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var changed Builder
+	if _, _, err := changed.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	changedHash, changedPrefix := hashes(&changed)
+	if hash == changedHash {
+		t.Fatal("a changed script must change the content hash")
+	}
+	if prefix == changedPrefix {
+		t.Fatal("a changed script must change the checkpoint prefix")
+	}
+
+	description := stampDescription("Web server template\n", contentHashStamp, "old")
+	description = stampDescription(description, contentHashStamp, "new")
+	if description != "Web server template\npacker-content-hash: new" || descriptionStamp(description, contentHashStamp) != "new" {
+		t.Errorf("unexpected stamped description: %q", description)
+	}
+
+	raw["content_hash_files"] = []string{filepath.Join(dir, "*.ps1")}
+	if _, _, err := new(Builder).Prepare(raw); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Fatalf("expected an error for a pattern without files, got %v", err)
+	}
+
+	// What the HTTP server serves shapes the template as well
+	httpDir := filepath.Join(dir, "http")
+	if err := os.MkdirAll(httpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	seed := filepath.Join(httpDir, "user-data")
+	if err := os.WriteFile(seed, []byte("#cloud-config\npackages: [nginx]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	raw["content_hash_files"] = []string{filepath.Join(dir, "*.sh")}
+	raw["http_directory"] = httpDir
+	var served Builder
+	if _, _, err := served.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	servedHash, _ := hashes(&served)
+	if err := os.WriteFile(seed, []byte("#cloud-config\npackages: [apache2]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var reserved Builder
+	if _, _, err := reserved.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if reservedHash, _ := hashes(&reserved); servedHash == reservedHash {
+		t.Fatal("a changed file in http_directory must change the content hash")
+	}
+
+	delete(raw, "http_directory")
+	raw["http_content"] = map[string]string{"/user-data": "#cloud-config\n"}
+	var content Builder
+	if _, _, err := content.Prepare(raw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if contentHash, _ := hashes(&content); contentHash == changedHash {
+		t.Fatal("http_content must change the content hash")
+	}
+	delete(raw, "http_content")
+
+	delete(raw, "content_hash_files")
+	_, warnings, err := new(Builder).Prepare(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(warnings) == 0 || !strings.Contains(strings.Join(warnings, "\n"), "content_hash_files is empty") {
+		t.Fatalf("expected a warning for skip_if_unchanged without content_hash_files, got %v", warnings)
+	}
+}
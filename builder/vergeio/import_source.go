@@ -0,0 +1,455 @@
+package vergeio
+
+import (
+	"archive/tar"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// importFileTypes maps the disk image extensions that can be imported to catalog file types
+var importFileTypes = map[string]string{
+	".vmdk":  "vmdk",
+	".qcow2": "qcow2",
+	".img":   "raw",
+	".raw":   "raw",
+	".vhd":   "vhd",
+	".vhdx":  "vhdx",
+}
+
+// OVF resource types, from the CIM ResourceAllocationSettingData schema
+const (
+	ovfResourceCPU             = 3
+	ovfResourceMemory          = 4
+	ovfResourceIDEController   = 5
+	ovfResourceSCSIController  = 6
+	ovfResourceEthernetAdapter = 10
+	ovfResourceDiskDrive       = 17
+	ovfResourceOtherStorage    = 20
+)
+
+// ovfEnvelope is the part of an OVF descriptor the import reads. Tags carry no namespace, so
+// the ovf:, rasd: and vmw: prefixed elements and attributes all match.
+type ovfEnvelope struct {
+	Files  []ovfFile `xml:"References>File"`
+	Disks  []ovfDisk `xml:"DiskSection>Disk"`
+	System struct {
+		ID string `xml:"id,attr"`
+		OS struct {
+			OSType string `xml:"osType,attr"`
+		} `xml:"OperatingSystemSection"`
+		Items  []ovfItem  `xml:"VirtualHardwareSection>Item"`
+		Config []ovfValue `xml:"VirtualHardwareSection>Config"`
+	} `xml:"VirtualSystem"`
+}
+
+type ovfFile struct {
+	ID          string `xml:"id,attr"`
+	Href        string `xml:"href,attr"`
+	Compression string `xml:"compression,attr"`
+	ChunkSize   int64  `xml:"chunkSize,attr"`
+}
+
+type ovfDisk struct {
+	DiskID  string `xml:"diskId,attr"`
+	FileRef string `xml:"fileRef,attr"`
+}
+
+type ovfItem struct {
+	ElementName     string   `xml:"ElementName"`
+	InstanceID      string   `xml:"InstanceID"`
+	ResourceType    int      `xml:"ResourceType"`
+	ResourceSubType string   `xml:"ResourceSubType"`
+	VirtualQuantity int64    `xml:"VirtualQuantity"`
+	AllocationUnits string   `xml:"AllocationUnits"`
+	HostResource    []string `xml:"HostResource"`
+	Parent          string   `xml:"Parent"`
+	Connection      []string `xml:"Connection"`
+}
+
+type ovfValue struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// importSource is an appliance read from an OVA, an OVF descriptor with its disk files, or a
+// single disk image. Hardware values are zero when the source does not state them.
+type importSource struct {
+	Path     string
+	CPUCores int
+	RAM      int
+	OSFamily string
+	UEFI     bool
+	Disks    []importDisk
+	Nics     []importNic
+	// Warnings describe hardware without a close VergeIO equivalent
+	Warnings []string
+}
+
+// importDisk is a disk image of the source and the catalog file it is uploaded to
+type importDisk struct {
+	Name      string
+	Interface string
+	// Href is the image's path in the OVA, or relative to the descriptor
+	Href        string
+	Size        int64
+	FileType    string
+	CatalogName string
+}
+
+type importNic struct {
+	Name      string
+	Interface string
+	Network   string
+}
+
+// readImportSource reads the appliance at path, choosing the format by extension
+func readImportSource(sourcePath string) (*importSource, error) {
+	switch ext := strings.ToLower(filepath.Ext(sourcePath)); ext {
+	case ".ova":
+		return readOVA(sourcePath)
+	case ".ovf":
+		data, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		return parseOVF(sourcePath, data, func(href string) (int64, error) {
+			info, err := os.Stat(filepath.Join(filepath.Dir(sourcePath), filepath.FromSlash(href)))
+			if err != nil {
+				return 0, err
+			}
+			return info.Size(), nil
+		})
+	default:
+		if _, ok := importFileTypes[ext]; !ok {
+			return nil, fmt.Errorf("unsupported file type %q, expected .ova, .ovf or a disk image (%s)", ext, strings.Join(importExtensions(), ", "))
+		}
+		info, err := os.Stat(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		source := &importSource{Path: sourcePath}
+		disk, err := newImportDisk(sourcePath, "disk0", filepath.Base(sourcePath), info.Size())
+		if err != nil {
+			return nil, err
+		}
+		source.Disks = append(source.Disks, disk)
+		return source, nil
+	}
+}
+
+// readOVA parses the descriptor of an OVA. The OVF standard puts it first in the archive, the
+// disk sizes come from the tar headers.
+func readOVA(sourcePath string) (*importSource, error) {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var descriptor []byte
+	sizes := map[string]int64{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sourcePath, err)
+		}
+		sizes[ovaEntryName(header.Name)] = header.Size
+		if descriptor == nil && strings.EqualFold(path.Ext(header.Name), ".ovf") {
+			if descriptor, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("failed to read %s from %s: %w", header.Name, sourcePath, err)
+			}
+		}
+	}
+	if descriptor == nil {
+		return nil, fmt.Errorf("%s contains no .ovf descriptor", sourcePath)
+	}
+
+	return parseOVF(sourcePath, descriptor, func(href string) (int64, error) {
+		size, ok := sizes[ovaEntryName(href)]
+		if !ok {
+			return 0, fmt.Errorf("%s is not in the archive", href)
+		}
+		return size, nil
+	})
+}
+
+// ovaEntryName normalises a file name of an OVA, archives made with e.g. "tar -C dir ." name
+// their entries "./disk.vmdk" while the descriptor refers to "disk.vmdk"
+func ovaEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "./")
+}
+
+// parseOVF maps the descriptor's hardware onto an importSource. sizeOf returns the size of a
+// referenced file.
+func parseOVF(sourcePath string, data []byte, sizeOf func(href string) (int64, error)) (*importSource, error) {
+	var envelope ovfEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid OVF descriptor: %w", err)
+	}
+
+	source := &importSource{Path: sourcePath, OSFamily: ovfOSFamily(envelope.System.OS.OSType)}
+	for _, config := range envelope.System.Config {
+		if config.Key == "firmware" && config.Value == "efi" {
+			source.UEFI = true
+		}
+	}
+
+	files := make(map[string]ovfFile, len(envelope.Files))
+	for _, file := range envelope.Files {
+		files[file.ID] = file
+	}
+	disks := make(map[string]ovfDisk, len(envelope.Disks))
+	for _, disk := range envelope.Disks {
+		disks[disk.DiskID] = disk
+	}
+
+	// Disk drives name their controller by InstanceID
+	controllers := map[string]string{}
+	for _, item := range envelope.System.Items {
+		switch item.ResourceType {
+		case ovfResourceIDEController:
+			controllers[item.InstanceID] = "ide"
+		case ovfResourceSCSIController:
+			iface, ok := ovfSCSIInterface(item.ResourceSubType)
+			if !ok {
+				source.Warnings = append(source.Warnings, fmt.Sprintf("SCSI controller '%s' (%q) has no VergeIO equivalent, its disks use virtio-scsi, "+
+					"which needs virtio drivers in the guest, set import_disk_interface otherwise", item.ElementName, item.ResourceSubType))
+			}
+			controllers[item.InstanceID] = iface
+		case ovfResourceOtherStorage:
+			if strings.Contains(strings.ToLower(item.ResourceSubType), "ahci") {
+				controllers[item.InstanceID] = "ahci"
+			}
+		}
+	}
+
+	for _, item := range envelope.System.Items {
+		switch item.ResourceType {
+		case ovfResourceCPU:
+			source.CPUCores = int(item.VirtualQuantity)
+		case ovfResourceMemory:
+			ram, err := ovfMemoryMB(item.VirtualQuantity, item.AllocationUnits)
+			if err != nil {
+				return nil, err
+			}
+			source.RAM = ram
+		case ovfResourceEthernetAdapter:
+			nic := importNic{
+				Name:      item.ElementName,
+				Interface: ovfNicInterface(item.ResourceSubType),
+			}
+			if len(item.Connection) > 0 {
+				nic.Network = item.Connection[0]
+			}
+			if nic.Name == "" {
+				nic.Name = fmt.Sprintf("nic%d", len(source.Nics))
+			}
+			source.Nics = append(source.Nics, nic)
+		case ovfResourceDiskDrive:
+			if len(item.HostResource) == 0 {
+				continue
+			}
+			// HostResource is "ovf:/disk/<diskId>"
+			diskID := path.Base(item.HostResource[0])
+			disk, ok := disks[diskID]
+			if !ok {
+				return nil, fmt.Errorf("disk drive '%s' refers to unknown disk %q", item.ElementName, diskID)
+			}
+			if disk.FileRef == "" {
+				// A blank disk the appliance expects to be created, it carries no data
+				continue
+			}
+			file, ok := files[disk.FileRef]
+			if !ok {
+				return nil, fmt.Errorf("disk %q refers to unknown file %q", diskID, disk.FileRef)
+			}
+			if file.Compression != "" || file.ChunkSize > 0 {
+				return nil, fmt.Errorf("file %s is compressed or chunked, which is not supported", file.Href)
+			}
+			size, err := sizeOf(file.Href)
+			if err != nil {
+				return nil, fmt.Errorf("file %s: %w", file.Href, err)
+			}
+			name := item.ElementName
+			if name == "" {
+				name = diskID
+			}
+			imported, err := newImportDisk(sourcePath, name, file.Href, size)
+			if err != nil {
+				return nil, err
+			}
+			imported.Interface = controllers[item.Parent]
+			source.Disks = append(source.Disks, imported)
+		}
+	}
+
+	if len(source.Disks) == 0 {
+		return nil, fmt.Errorf("the OVF descriptor references no disk images")
+	}
+	return source, nil
+}
+
+// newImportDisk names the catalog file after the source, so images of different appliances
+// with the same file name do not collide
+func newImportDisk(sourcePath, name, href string, size int64) (importDisk, error) {
+	fileType, ok := importFileTypes[strings.ToLower(path.Ext(href))]
+	if !ok {
+		return importDisk{}, fmt.Errorf("disk image %s has an unsupported format, expected one of %s", href, strings.Join(importExtensions(), ", "))
+	}
+
+	base := strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+	catalogName := path.Base(href)
+	if !strings.HasPrefix(catalogName, base) {
+		catalogName = base + "-" + catalogName
+	}
+	return importDisk{Name: name, Href: href, Size: size, FileType: fileType, CatalogName: catalogName}, nil
+}
+
+// ovfMemoryMB converts the memory quantity to MB, units are given as e.g. "byte * 2^20"
+func ovfMemoryMB(quantity int64, units string) (int, error) {
+	switch strings.ReplaceAll(strings.ToLower(units), " ", "") {
+	case "", "byte*2^20", "megabytes", "mb":
+		return int(quantity), nil
+	case "byte*2^30", "gigabytes", "gb":
+		return int(quantity * 1024), nil
+	case "byte*2^10", "kilobytes", "kb":
+		return int(quantity / 1024), nil
+	case "byte", "bytes":
+		return int(quantity >> 20), nil
+	}
+	return 0, fmt.Errorf("unsupported memory allocation units %q", units)
+}
+
+// ovfSCSIInterface maps the OVF SCSI controller type to the VergeIO disk interface emulating
+// it. A type without one, e.g. VMware's paravirtual "VirtualSCSI", gets virtio-scsi and false.
+func ovfSCSIInterface(subType string) (string, bool) {
+	switch strings.ToLower(subType) {
+	case "lsilogic":
+		return "lsi53c895a", true
+	case "lsilogicsas":
+		return "mptsas1068", true
+	case "virtio-scsi":
+		return "virtio-scsi", true
+	}
+	return "virtio-scsi", false
+}
+
+// ovfNicInterface maps the OVF adapter type to the closest VergeIO NIC interface
+func ovfNicInterface(subType string) string {
+	switch strings.ToLower(subType) {
+	case "e1000":
+		return "e1000"
+	case "e1000e":
+		return "e1000e"
+	case "vmxnet3":
+		return "vmxnet3"
+	case "pcnet32":
+		return "pcnet"
+	}
+	return "virtio"
+}
+
+// ovfOSFamily maps a VMware osType such as "ubuntu64Guest" to an os_family. Unknown types
+// are left to the config.
+func ovfOSFamily(osType string) string {
+	osType = strings.ToLower(osType)
+	switch {
+	case strings.HasPrefix(osType, "win"):
+		return "windows"
+	case strings.Contains(osType, "freebsd"):
+		return "freebsd"
+	}
+	for _, linux := range []string{"linux", "ubuntu", "debian", "centos", "rhel", "sles", "oracle", "fedora", "rocky", "alma", "photon", "coreos"} {
+		if strings.Contains(osType, linux) {
+			return "linux"
+		}
+	}
+	return ""
+}
+
+func importExtensions() []string {
+	extensions := make([]string, 0, len(importFileTypes))
+	for ext := range importFileTypes {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// applyImportSource reads import_source and fills in what the config leaves unset. Imported
+// disks come before the configured vm_disks, the source's NICs are only used without vm_nics.
+func (c *Config) applyImportSource() ([]string, error) {
+	source, err := readImportSource(c.ImportSource)
+	if err != nil {
+		return nil, fmt.Errorf("import_source: %w", err)
+	}
+
+	if c.CPUCores == 0 {
+		c.CPUCores = source.CPUCores
+	}
+	if c.RAM == 0 {
+		c.RAM = source.RAM
+	}
+	if c.OSFamily == "" {
+		c.OSFamily = source.OSFamily
+	}
+	if source.UEFI {
+		c.UEFI = true
+	}
+
+	disks := make([]VmDiskConfig, 0, len(source.Disks)+len(c.VmDiskConfigs))
+	for _, disk := range source.Disks {
+		iface := disk.Interface
+		if c.ImportDiskInterface != "" {
+			iface = c.ImportDiskInterface
+		} else if iface == "" {
+			iface = "virtio-scsi"
+		}
+		disks = append(disks, VmDiskConfig{
+			Name:            disk.Name,
+			Interface:       iface,
+			Media:           "import",
+			MediaSourceName: disk.CatalogName,
+		})
+	}
+	c.VmDiskConfigs = append(disks, c.VmDiskConfigs...)
+
+	if len(c.VmNicConfigs) == 0 {
+		for _, nic := range source.Nics {
+			network := nic.Network
+			if mapped, ok := c.ImportNetworks[network]; ok {
+				network = mapped
+			}
+			if network == "" {
+				return nil, fmt.Errorf("import_source: NIC '%s' is not connected to a network, set vm_nics", nic.Name)
+			}
+			c.VmNicConfigs = append(c.VmNicConfigs, VmNicConfig{
+				Name:      nic.Name,
+				Interface: nic.Interface,
+				VNETName:  network,
+			})
+		}
+	}
+
+	c.importSource = source
+
+	// import_disk_interface overrides the interfaces the warnings are about
+	if c.ImportDiskInterface != "" {
+		return nil, nil
+	}
+	var warnings []string
+	for _, warning := range source.Warnings {
+		warnings = append(warnings, "import_source: "+warning)
+	}
+	return warnings, nil
+}
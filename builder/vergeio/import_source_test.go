@@ -0,0 +1,145 @@
+package vergeio
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOVF = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1"
+    xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData"
+    xmlns:vmw="http://www.vmware.com/schema/ovf">
+  <References>
+    <File ovf:id="file1" ovf:href="appliance-disk1.vmdk"/>
+  </References>
+  <DiskSection>
+    <Disk ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:capacity="20" ovf:capacityAllocationUnits="byte * 2^30"/>
+  </DiskSection>
+  <VirtualSystem ovf:id="appliance">
+    <OperatingSystemSection ovf:id="96" vmw:osType="ubuntu64Guest"/>
+    <VirtualHardwareSection>
+      <Item><rasd:ElementName>2 virtual CPU(s)</rasd:ElementName><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>3</rasd:ResourceType><rasd:VirtualQuantity>2</rasd:VirtualQuantity></Item>
+      <Item><rasd:AllocationUnits>byte * 2^30</rasd:AllocationUnits><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>4</rasd:VirtualQuantity></Item>
+      <Item><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>lsilogic</rasd:ResourceSubType><rasd:ResourceType>6</rasd:ResourceType></Item>
+      <Item><rasd:ElementName>Hard disk 1</rasd:ElementName><rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource><rasd:InstanceID>4</rasd:InstanceID><rasd:Parent>3</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>
+      <Item><rasd:Connection>VM Network</rasd:Connection><rasd:ElementName>Network adapter 1</rasd:ElementName><rasd:InstanceID>5</rasd:InstanceID><rasd:ResourceSubType>VmxNet3</rasd:ResourceSubType><rasd:ResourceType>10</rasd:ResourceType></Item>
+      <vmw:Config ovf:required="false" vmw:key="firmware" vmw:value="efi"/>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>`
+
+// writeTestOVA writes an OVA with the test descriptor and a small disk image, named the way
+// "tar -C dir ." names them
+func writeTestOVA(t *testing.T) string {
+	ova := filepath.Join(t.TempDir(), "appliance.ova")
+	f, err := os.Create(ova)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for name, contents := range map[string]string{"./appliance.ovf": testOVF, "./appliance-disk1.vmdk": "vmdk data"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ova
+}
+
+func TestBuilderPrepare_ImportSource(t *testing.T) {
+	raw := testConfig()
+	delete(raw, "vm_nics")
+	delete(raw, "os_family")
+	raw["import_source"] = writeTestOVA(t)
+	raw["import_networks"] = map[string]string{"VM Network": "internal"}
+	// os_family comes from the source, generalize and compact must see it
+	raw["generalize"] = true
+	raw["compact"] = true
+
+	var b Builder
+	_, warnings, err := b.Prepare(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, warning := range warnings {
+		if strings.HasPrefix(warning, "import_source") {
+			t.Errorf("unexpected warning: %s", warning)
+		}
+	}
+
+	c := b.config
+	if c.CPUCores != 2 || c.RAM != 4096 || c.OSFamily != "linux" || !c.UEFI {
+		t.Errorf("unexpected hardware: cpu_cores=%d ram=%d os_family=%q uefi=%v", c.CPUCores, c.RAM, c.OSFamily, c.UEFI)
+	}
+
+	if len(c.VmDiskConfigs) != 2 {
+		t.Fatalf("expected the imported disk before the configured one, got %+v", c.VmDiskConfigs)
+	}
+	disk := c.VmDiskConfigs[0]
+	if disk.Name != "Hard disk 1" || disk.Media != "import" || disk.Interface != "lsi53c895a" || disk.MediaSourceName != "appliance-disk1.vmdk" {
+		t.Errorf("unexpected imported disk: %+v", disk)
+	}
+	if source := c.importSource; source == nil || source.Disks[0].Size != int64(len("vmdk data")) {
+		t.Errorf("expected the disk size from the archive, got %+v", source)
+	}
+
+	if len(c.VmNicConfigs) != 1 {
+		t.Fatalf("expected one imported NIC, got %+v", c.VmNicConfigs)
+	}
+	if nic := c.VmNicConfigs[0]; nic.Interface != "vmxnet3" || nic.VNETName != "internal" {
+		t.Errorf("unexpected imported NIC: %+v", nic)
+	}
+
+	pvscsi := strings.Replace(testOVF, "lsilogic", "VirtualSCSI", 1)
+	source, err := parseOVF("appliance.ovf", []byte(pvscsi), func(string) (int64, error) { return 1, nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if source.Disks[0].Interface != "virtio-scsi" || len(source.Warnings) != 1 || !strings.Contains(source.Warnings[0], "VirtualSCSI") {
+		t.Errorf("expected virtio-scsi and a warning for a paravirtual SCSI controller, got %q, %v", source.Disks[0].Interface, source.Warnings)
+	}
+
+	raw["import_source"] = filepath.Join(t.TempDir(), "appliance.iso")
+	if _, _, err := new(Builder).Prepare(raw); err == nil {
+		t.Fatal("expected an error for an unsupported import source")
+	}
+}
+
+func TestStepImportUploadEachImage(t *testing.T) {
+	source, err := readImportSource(writeTestOVA(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	step := &StepImportUpload{Source: source}
+	disk := source.Disks[0]
+
+	var sums []string
+	err = step.eachImage(map[string]importDisk{ovaEntryName(disk.Href): disk}, func(disk importDisk, r io.Reader) error {
+		sum, err := sha256Hex(r)
+		sums = append(sums, sum)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want, _ := sha256Hex(strings.NewReader("vmdk data"))
+	if len(sums) != 1 || sums[0] != want {
+		t.Fatalf("expected the checksum of the image in the archive, got %v", sums)
+	}
+
+	missing := importDisk{Href: "missing.vmdk"}
+	if err := step.eachImage(map[string]importDisk{"missing.vmdk": missing}, func(importDisk, io.Reader) error { return nil }); err == nil {
+		t.Fatal("expected an error for an image that is not in the archive")
+	}
+}
@@ -0,0 +1,159 @@
+// This step builds on an existing VM instead of creating one
+// StepCapture clones it into the template once it is shut down, the VM itself is kept
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// attachSnapshotPrefix starts the name of the safety snapshot taken before the build touches the VM
+const attachSnapshotPrefix = "packer-safety-"
+
+// StepAttachVM looks up the existing VM, makes sure the template name is free, shuts the VM
+// down if it is running and optionally snapshots it. Later steps then power it on and
+// provision it like a VM the build created.
+//
+// When the build fails or is cancelled, the cleanup powers the VM off and rolls it back to the
+// safety snapshot. The snapshot is deleted either way.
+type StepAttachVM struct {
+	// Name or Key identify the existing VM
+	Name string
+	Key  int
+	// TemplateName is what StepCapture names the clone, no VM may carry it yet
+	TemplateName string
+	Snapshot     bool
+	// Timeout bounds each wait for the VM to power off
+	Timeout time.Duration
+
+	vmKey       string
+	snapshotKey string
+}
+
+func (s *StepAttachVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("attaching to the existing VM failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	var vmKey, vmName string
+	var machineID int
+	if s.Key != 0 {
+		vm, err := vmAPI.GetVM(ctx, strconv.Itoa(s.Key))
+		if err != nil {
+			return halt(err)
+		}
+		vmKey, vmName, machineID = strconv.Itoa(s.Key), vm.Name, vm.Machine
+	} else {
+		vm, err := vmAPI.GetVMByName(ctx, s.Name)
+		if err != nil {
+			return halt(err)
+		}
+		vmKey, vmName, machineID = strconv.Itoa(int(vm.Key)), s.Name, int(vm.ID)
+	}
+	ui.Say(fmt.Sprintf("Attaching to existing VM '%s' (key %s)...", vmName, vmKey))
+
+	// The clone would otherwise be indistinguishable from a VM that already carries the name
+	vms, err := vmAPI.GetVMs(ctx, s.TemplateName, 0, false)
+	if err != nil {
+		return halt(err)
+	}
+	for _, vm := range vms {
+		if !vm.IsSnapshot {
+			return halt(fmt.Errorf("a VM named '%s' already exists (key %d), the template needs a free name", s.TemplateName, vm.Key))
+		}
+	}
+
+	running, err := vmAPI.IsVMRunning(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+	if running != nil && *running {
+		ui.Message("Shutting down the running VM")
+		waitCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+		err := vmAPI.ShutdownVM(waitCtx, vmKey)
+		cancel()
+		if err != nil {
+			return halt(fmt.Errorf("VM did not shut down within %v: %w", s.Timeout, err))
+		}
+	}
+
+	if s.Snapshot {
+		name := attachSnapshotPrefix + time.Now().UTC().Format("20060102-150405")
+		ui.Message(fmt.Sprintf("Taking safety snapshot '%s'", name))
+		snapshotKey, err := vmAPI.SnapshotVM(ctx, vmKey, name, 0, false)
+		if err != nil {
+			return halt(err)
+		}
+		s.snapshotKey = snapshotKey
+	}
+	s.vmKey = vmKey
+
+	state.Put("vm_id", vmKey)
+	state.Put("instance_id", vmKey)
+	state.Put("machine_id", machineID)
+	state.Put("source_vm_id", vmKey)
+
+	return multistep.ActionContinue
+}
+
+func (s *StepAttachVM) Cleanup(state multistep.StateBag) {
+	if s.snapshotKey == "" {
+		return
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+	ctx := context.Background()
+
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if cancelled || halted {
+		ui.Say("Rolling the existing VM back to its safety snapshot...")
+		if err := s.rollback(ctx, vmAPI); err != nil {
+			ui.Error(fmt.Sprintf("Rolling back VM %s failed, snapshot %s is kept: %v", s.vmKey, s.snapshotKey, err))
+			return
+		}
+		ui.Message("VM rolled back")
+	}
+
+	if err := vmAPI.DeleteSnapshot(ctx, s.snapshotKey); err != nil {
+		ui.Error(fmt.Sprintf("Failed to delete safety snapshot %s: %v", s.snapshotKey, err))
+	}
+}
+
+// rollback powers the VM off, restoring a snapshot needs a stopped VM, and restores it
+func (s *StepAttachVM) rollback(ctx context.Context, vmAPI *client.VMApi) error {
+	running, err := vmAPI.IsVMRunning(ctx, s.vmKey)
+	if err != nil {
+		return err
+	}
+	if running != nil && *running {
+		if err := vmAPI.PowerOffVM(ctx, s.vmKey); err != nil {
+			return err
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+		if err := vmAPI.WaitForPowerState(waitCtx, s.vmKey, false); err != nil {
+			return err
+		}
+	}
+	return vmAPI.RestoreSnapshot(ctx, s.vmKey, s.snapshotKey)
+}
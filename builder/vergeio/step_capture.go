@@ -0,0 +1,80 @@
+// This step clones the shut down existing VM into the template
+// Later steps work on the clone, the existing VM keeps its provisioned state
+package vergeio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// StepCapture clones the VM StepAttachVM attached to under the template name and points the
+// build state at the clone, so StepFinalize and the artifact see the template. A cancelled or
+// failed build deletes the clone, the existing VM is left to StepAttachVM.
+type StepCapture struct {
+	TemplateName string
+
+	cloneKey string
+}
+
+func (s *StepCapture) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("capturing the template failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	ui.Say(fmt.Sprintf("Capturing VM %s as template '%s'...", vmKey, s.TemplateName))
+
+	cloneKey, err := vmAPI.CloneVM(ctx, vmKey, s.TemplateName)
+	if err != nil {
+		return halt(err)
+	}
+	s.cloneKey = cloneKey
+	clone, err := vmAPI.GetVM(ctx, cloneKey)
+	if err != nil {
+		return halt(err)
+	}
+
+	state.Put("vm_id", cloneKey)
+	state.Put("instance_id", cloneKey)
+	state.Put("machine_id", clone.Machine)
+
+	ui.Say(fmt.Sprintf("Template '%s' captured (key %s)", s.TemplateName, cloneKey))
+	return multistep.ActionContinue
+}
+
+func (s *StepCapture) Cleanup(state multistep.StateBag) {
+	if s.cloneKey == "" {
+		return
+	}
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	ui.Say(fmt.Sprintf("Deleting the unfinished template '%s'...", s.TemplateName))
+	if err := vmAPI.DeleteVM(context.Background(), s.cloneKey); err != nil {
+		ui.Error(fmt.Sprintf("Failed to delete VM %s, delete it manually: %v", s.cloneKey, err))
+		return
+	}
+	ui.Message("Unfinished template deleted")
+}
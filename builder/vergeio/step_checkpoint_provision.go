@@ -0,0 +1,141 @@
+// This step runs the provisioners, skipping the ones a resumed build already ran
+// The vergeio-checkpoint provisioner tells it where the restored checkpoint was taken
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// CheckpointMarkerCommand is the command the vergeio-checkpoint provisioner runs through the
+// communicator, followed by the checkpoint name. It never reaches the guest.
+const CheckpointMarkerCommand = "packer-vergeio-checkpoint"
+
+// StepCheckpointProvision runs the provisioners like commonsteps.StepProvision. After
+// StepResumeCheckpoint restored a checkpoint, every command and file transfer is skipped until
+// the vergeio-checkpoint provisioner of that checkpoint runs, so the provisioners that ran
+// before it are not run again. Provisioners that work on the Packer host, e.g. shell-local,
+// do not go through the communicator and still run.
+type StepCheckpointProvision struct {
+	provision *commonsteps.StepProvision
+	comm      *checkpointCommunicator
+}
+
+func (s *StepCheckpointProvision) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	restored, _ := state.Get("restored_checkpoint").(string)
+
+	s.provision = &commonsteps.StepProvision{}
+	if comm, ok := state.Get("communicator").(packersdk.Communicator); ok && comm != nil {
+		s.comm = &checkpointCommunicator{Communicator: comm, skipUntil: restored}
+		s.provision.Comm = s.comm
+	}
+	if restored != "" && s.comm != nil {
+		ui.Say(fmt.Sprintf("Skipping the provisioners that ran before checkpoint '%s'", restored))
+	}
+
+	if action := s.provision.Run(ctx, state); action != multistep.ActionContinue {
+		return action
+	}
+
+	if s.comm != nil && s.comm.skipping() {
+		err := fmt.Errorf("checkpoint '%s' was not reached, the provisioners changed since it was taken, "+
+			"delete VM '%s' or set resume_from_checkpoint = false", restored, state.Get("vm_config").(VmConfig).Name)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepCheckpointProvision) Cleanup(state multistep.StateBag) {
+	if s.provision == nil {
+		return
+	}
+	// The error-cleanup-provisioner always runs in full
+	if s.comm != nil {
+		s.comm.stopSkipping()
+	}
+	s.provision.Cleanup(state)
+}
+
+// checkpointCommunicator answers the checkpoint markers itself and, while skipUntil is set,
+// pretends every command succeeded and every transfer was done
+type checkpointCommunicator struct {
+	packersdk.Communicator
+
+	mu        sync.Mutex
+	skipUntil string
+}
+
+func (c *checkpointCommunicator) skipping() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skipUntil != ""
+}
+
+func (c *checkpointCommunicator) stopSkipping() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skipUntil = ""
+}
+
+func (c *checkpointCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	if name, ok := strings.CutPrefix(cmd.Command, CheckpointMarkerCommand+" "); ok {
+		c.mu.Lock()
+		if c.skipUntil == name {
+			log.Printf("[VergeIO]: Reached restored checkpoint '%s', running the remaining provisioners", name)
+			c.skipUntil = ""
+		}
+		c.mu.Unlock()
+		cmd.SetExited(0)
+		return nil
+	}
+	if c.skipping() {
+		log.Printf("[VergeIO]: Skipping command of a provisioner that ran before the restored checkpoint: %s", cmd.Command)
+		cmd.SetExited(0)
+		return nil
+	}
+	return c.Communicator.Start(ctx, cmd)
+}
+
+func (c *checkpointCommunicator) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	if c.skipping() {
+		log.Printf("[VergeIO]: Skipping upload of a provisioner that ran before the restored checkpoint: %s", dst)
+		return nil
+	}
+	return c.Communicator.Upload(dst, src, fi)
+}
+
+func (c *checkpointCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	if c.skipping() {
+		log.Printf("[VergeIO]: Skipping upload of a provisioner that ran before the restored checkpoint: %s", dst)
+		return nil
+	}
+	return c.Communicator.UploadDir(dst, src, exclude)
+}
+
+func (c *checkpointCommunicator) Download(src string, dst io.Writer) error {
+	if c.skipping() {
+		log.Printf("[VergeIO]: Skipping download of a provisioner that ran before the restored checkpoint: %s", src)
+		return nil
+	}
+	return c.Communicator.Download(src, dst)
+}
+
+func (c *checkpointCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	if c.skipping() {
+		log.Printf("[VergeIO]: Skipping download of a provisioner that ran before the restored checkpoint: %s", src)
+		return nil
+	}
+	return c.Communicator.DownloadDir(src, dst, exclude)
+}
@@ -0,0 +1,41 @@
+package vergeio
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestCheckpointCommunicator(t *testing.T) {
+	mock := &packersdk.MockCommunicator{}
+	comm := &checkpointCommunicator{Communicator: mock, skipUntil: "updates"}
+	ctx := context.Background()
+
+	run := func(command string) {
+		cmd := &packersdk.RemoteCmd{Command: command}
+		if err := comm.Start(ctx, cmd); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	run("apt-get install -y nginx")
+	if err := comm.Upload("/tmp/script.sh", strings.NewReader("echo"), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	run(CheckpointMarkerCommand + " packages")
+	if mock.StartCalled || mock.UploadCalled {
+		t.Fatal("commands and uploads before the restored checkpoint must be skipped")
+	}
+
+	run(CheckpointMarkerCommand + " updates")
+	if comm.skipping() || mock.StartCalled {
+		t.Fatal("the marker of the restored checkpoint must end skipping without reaching the guest")
+	}
+
+	run("reboot")
+	if !mock.StartCalled || mock.StartCmd.Command != "reboot" {
+		t.Fatal("commands after the restored checkpoint must reach the guest")
+	}
+}
@@ -0,0 +1,50 @@
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepCleanupTempKeys removes the build's temporary public key from the SSH user's
+// authorized_keys before shutdown, so the template does not keep trusting it
+type StepCleanupTempKeys struct {
+	Comm *communicator.Config
+}
+
+func (s *StepCleanupTempKeys) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok || comm == nil {
+		ui.Message("No communicator available - skipping temporary SSH key cleanup")
+		return multistep.ActionContinue
+	}
+
+	// The base64 key body is unique and never contains '|', so it is safe as a sed address
+	fields := strings.Fields(string(s.Comm.SSHPublicKey))
+	if len(fields) < 2 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Removing the temporary SSH public key from authorized_keys...")
+	cmd := &packersdk.RemoteCmd{
+		Command: fmt.Sprintf("sed -i.bak '\\|%s|d' ~/.ssh/authorized_keys; rm -f ~/.ssh/authorized_keys.bak", fields[1]),
+	}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		// Not fatal, the matching private key is discarded with the build anyway
+		ui.Error(fmt.Sprintf("Failed to remove the temporary SSH key: %s", err))
+		return multistep.ActionContinue
+	}
+	if cmd.ExitStatus() != 0 {
+		ui.Error(fmt.Sprintf("Removing the temporary SSH key exited with status %d", cmd.ExitStatus()))
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepCleanupTempKeys) Cleanup(state multistep.StateBag) {}
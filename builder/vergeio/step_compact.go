@@ -0,0 +1,206 @@
+// This step frees the blocks the guest no longer uses so they are not captured in the template
+// It runs before shutdown, while the communicator is still connected
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+const linuxCompactScriptPath = "/tmp/packer-compact.sh"
+
+// linuxCompactScript trims every local filesystem, zero-filling the ones that do not support
+// discard so the blocks can still be reclaimed. It exits non-zero when a filesystem could be
+// neither trimmed nor zero-filled.
+const linuxCompactScript = `#!/bin/sh
+if ! command -v findmnt >/dev/null 2>&1; then
+  fstrim -av
+  status=$?
+  rm -f "$0"
+  exit $status
+fi
+
+status=0
+for mnt in $(findmnt -rn -o TARGET -t ext2,ext3,ext4,xfs,btrfs); do
+  if ! fstrim -v "$mnt"; then
+    echo "$mnt does not support discard, zero-filling"
+    dd if=/dev/zero of="$mnt/packer-zerofill" bs=1M >/dev/null 2>&1
+    sync
+    if [ ! -s "$mnt/packer-zerofill" ]; then
+      echo "$mnt could not be zero-filled"
+      status=1
+    fi
+    rm -f "$mnt/packer-zerofill"
+  fi
+done
+
+rm -f "$0"
+exit $status
+`
+
+// windowsCompactCommand zero-fills the free space of every fixed volume with sdelete
+const windowsCompactCommand = `powershell -NoProfile -ExecutionPolicy Bypass -Command "` +
+	`Get-Volume | Where-Object { $_.DriveType -eq 'Fixed' -and $_.DriveLetter } | ForEach-Object { ` +
+	`& '%s' -accepteula -nobanner -z ($_.DriveLetter + ':'); if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE } }"`
+
+// driveUsageReport is the space a drive took on storage before and after compaction. It is
+// stored in the artifact as drive_usage.
+type driveUsageReport struct {
+	Name                 string `json:"name"`
+	UsedBytesBefore      int64  `json:"used_bytes_before"`
+	AllocatedBytesBefore int64  `json:"allocated_bytes_before"`
+	UsedBytesAfter       int64  `json:"used_bytes_after"`
+	AllocatedBytesAfter  int64  `json:"allocated_bytes_after"`
+}
+
+// StepCompact runs fstrim, falling back to zero-filling, on Linux guests and sdelete -z on
+// Windows guests, then reports how much storage each drive takes before and after.
+type StepCompact struct {
+	OSFamily string
+	// Username decides whether the Linux commands need sudo
+	Username string
+	// SdeletePath is the sdelete executable in the Windows guest
+	SdeletePath string
+	Timeout     time.Duration
+}
+
+func (s *StepCompact) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	machineID := state.Get("machine_id").(int)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("compaction failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok || comm == nil {
+		return halt(fmt.Errorf("no communicator available"))
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	driveAPI := client.NewDriveApi(c)
+
+	// StepVMCreate enables discard on the drives it creates. An attached, resumed or warm pool
+	// VM may have drives without it, they get it here so the template and its clones have it.
+	// The guest only sees it from its next boot, until then the script zero-fills them.
+	disks, err := driveAPI.ListVMDisks(ctx, machineID)
+	if err != nil {
+		return halt(err)
+	}
+	for _, disk := range disks {
+		if disk.Discard || disk.Media == "cdrom" || disk.Media == "efidisk" {
+			continue
+		}
+		ui.Message(fmt.Sprintf("Enabling discard on drive '%s'", disk.Name))
+		if err := driveAPI.UpdateVMDisk(ctx, strconv.Itoa(disk.Key), map[string]interface{}{"discard": true}); err != nil {
+			return halt(err)
+		}
+	}
+
+	before, err := driveAPI.ListVMDriveUsage(ctx, machineID)
+	if err != nil {
+		return halt(err)
+	}
+
+	ui.Say(fmt.Sprintf("Compacting the %s guest's drives...", s.OSFamily))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var command string
+	switch s.OSFamily {
+	case "windows":
+		command = fmt.Sprintf(windowsCompactCommand, s.SdeletePath)
+	default:
+		if err := comm.Upload(linuxCompactScriptPath, strings.NewReader(linuxCompactScript), nil); err != nil {
+			return halt(fmt.Errorf("failed to upload the compaction script: %w", err))
+		}
+		command = sudoCommand(s.Username, "sh "+linuxCompactScriptPath)
+	}
+
+	cmd := &packersdk.RemoteCmd{Command: command}
+	if err := cmd.RunWithUi(timeoutCtx, comm, ui); err != nil {
+		if timeoutCtx.Err() != nil && ctx.Err() == nil {
+			return halt(fmt.Errorf("not done within %v", s.Timeout))
+		}
+		return halt(err)
+	}
+	if status := cmd.ExitStatus(); status != 0 {
+		return halt(fmt.Errorf("compaction command exited with code %d", status))
+	}
+
+	after, err := driveAPI.ListVMDriveUsage(ctx, machineID)
+	if err != nil {
+		return halt(err)
+	}
+
+	reports := compareDriveUsage(before, after)
+	for _, r := range reports {
+		ui.Message(fmt.Sprintf("Drive '%s': used %s -> %s, allocated %s -> %s", r.Name,
+			formatBytes(r.UsedBytesBefore), formatBytes(r.UsedBytesAfter),
+			formatBytes(r.AllocatedBytesBefore), formatBytes(r.AllocatedBytesAfter)))
+	}
+	state.Put("drive_usage", reports)
+
+	ui.Say("Guest drives compacted")
+	return multistep.ActionContinue
+}
+
+func (s *StepCompact) Cleanup(state multistep.StateBag) {}
+
+// compareDriveUsage pairs the usage of each drive before and after, CD-ROMs are left out
+func compareDriveUsage(before, after []client.DriveUsage) []driveUsageReport {
+	byKey := make(map[int]client.DriveUsage, len(before))
+	for _, usage := range before {
+		byKey[usage.Key] = usage
+	}
+
+	var reports []driveUsageReport
+	for _, usage := range after {
+		if usage.Media == "cdrom" {
+			continue
+		}
+		prior := byKey[usage.Key]
+		reports = append(reports, driveUsageReport{
+			Name:                 usage.Name,
+			UsedBytesBefore:      prior.UsedBytes,
+			AllocatedBytesBefore: prior.AllocatedBytes,
+			UsedBytesAfter:       usage.UsedBytes,
+			AllocatedBytesAfter:  usage.AllocatedBytes,
+		})
+	}
+	return reports
+}
+
+// sudoCommand runs command with passwordless sudo unless the communicator user is root
+func sudoCommand(username, command string) string {
+	if username == "root" {
+		return command
+	}
+	return "sudo -n " + command
+}
+
+// formatBytes renders a byte count in binary units, e.g. "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,33 @@
+package vergeio
+
+import (
+	"testing"
+
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+func TestCompareDriveUsage(t *testing.T) {
+	before := []client.DriveUsage{
+		{Key: 1, Name: "root", Media: "disk", UsedBytes: 8 << 30, AllocatedBytes: 20 << 30},
+		{Key: 2, Name: "installer", Media: "cdrom", UsedBytes: 2 << 30, AllocatedBytes: 2 << 30},
+	}
+	after := []client.DriveUsage{
+		{Key: 1, Name: "root", Media: "disk", UsedBytes: 8 << 30, AllocatedBytes: 9 << 30},
+		{Key: 2, Name: "installer", Media: "cdrom", UsedBytes: 2 << 30, AllocatedBytes: 2 << 30},
+	}
+
+	reports := compareDriveUsage(before, after)
+	if len(reports) != 1 {
+		t.Fatalf("expected only the disk to be reported, got %v", reports)
+	}
+	want := driveUsageReport{Name: "root", UsedBytesBefore: 8 << 30, AllocatedBytesBefore: 20 << 30, UsedBytesAfter: 8 << 30, AllocatedBytesAfter: 9 << 30}
+	if reports[0] != want {
+		t.Fatalf("got %+v, want %+v", reports[0], want)
+	}
+
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 9 << 30: "9.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// This step hashes everything that goes into the template, StepFinalize stamps the hash on it
+// With skip_if_unchanged, a template that already carries the hash is returned instead of a build
+package vergeio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// contentHashStamp labels the content hash line in the template's description
+const contentHashStamp = "packer-content-hash"
+
+// loadContentHashFiles expands the patterns of ContentHashFiles and hashes every file, and
+// every file under HTTPDir. A pattern that matches nothing is an error, it is most likely a typo.
+func (c *Config) loadContentHashFiles() error {
+	c.contentFiles = map[string]string{}
+	for _, pattern := range c.ContentHashFiles {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("content_hash_files: invalid pattern %q: %w", pattern, err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("content_hash_files: %q matches no files", pattern)
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("content_hash_files: %w", err)
+			}
+			sum := sha256.Sum256(data)
+			c.contentFiles[filepath.ToSlash(path)] = hex.EncodeToString(sum[:])
+		}
+	}
+
+	// cloud-init and installers fetch these during the build, they shape the template too
+	c.httpFiles = map[string]string{}
+	if c.HTTPDir == "" {
+		return nil
+	}
+	err := filepath.WalkDir(c.HTTPDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.HTTPDir, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		c.httpFiles[filepath.ToSlash(rel)] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("http_directory: %w", err)
+	}
+	return nil
+}
+
+// contentHash identifies the template this config builds. It covers the VM config, with the
+// cloud-init contents and the media source keys resolved by preflight, the options applied
+// to the finished template, the files of ContentHashFiles and what the HTTP server serves.
+func (c *Config) contentHash() (string, error) {
+	return hashJSON(struct {
+		VM                     VmConfig
+		Generalize             bool
+		SysprepUnattend        string
+		Compact                bool
+		FinalizeTemplate       bool
+		TemplateCPUCores       int
+		TemplateRAM            int
+		TemplateCloudInitFiles []CloudInitFile
+		KeepCloudInitFiles     bool
+		Files                  map[string]string
+		HTTPFiles              map[string]string
+		HTTPContent            map[string]string
+	}{
+		VM:                     c.VmConfig,
+		Generalize:             c.Generalize,
+		SysprepUnattend:        c.SysprepUnattend,
+		Compact:                c.Compact,
+		FinalizeTemplate:       c.FinalizeTemplate,
+		TemplateCPUCores:       c.TemplateCPUCores,
+		TemplateRAM:            c.TemplateRAM,
+		TemplateCloudInitFiles: c.TemplateCloudInitFiles,
+		KeepCloudInitFiles:     c.KeepCloudInitFiles,
+		Files:                  c.contentFiles,
+		HTTPFiles:              c.httpFiles,
+		HTTPContent:            c.HTTPContent,
+	})
+}
+
+// stampDescription sets the "key: value" line of a VM description, replacing an earlier one
+func stampDescription(description, key, value string) string {
+	line := key + ": " + value
+	lines := strings.Split(description, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, key+": ") {
+			lines[i] = line
+			return strings.Join(lines, "\n")
+		}
+	}
+	if strings.TrimSpace(description) == "" {
+		return line
+	}
+	return strings.TrimRight(description, "\n") + "\n" + line
+}
+
+// descriptionStamp reads the value of the "key: value" line of a VM description
+func descriptionStamp(description, key string) string {
+	for _, l := range strings.Split(description, "\n") {
+		if value, ok := strings.CutPrefix(l, key+": "); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// StepContentHash puts the content hash of the build in state, once preflight has resolved the
+// media source keys. With SkipIfUnchanged it looks for a template of the same name that carries
+// the hash. If there is one, it becomes the artifact and the build halts without an error.
+type StepContentHash struct {
+	Config          *Config
+	SkipIfUnchanged bool
+}
+
+func (s *StepContentHash) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	hash, err := s.Config.contentHash()
+	if err != nil {
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	state.Put("content_hash", hash)
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	generatedData["ContentHash"] = hash
+
+	ui.Say(fmt.Sprintf("Content hash of this build: %s", hash))
+	if !s.SkipIfUnchanged {
+		return multistep.ActionContinue
+	}
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("looking for an unchanged template failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	name := s.Config.VmConfig.Name
+	vms, err := vmAPI.GetVMs(ctx, name, 0, false)
+	if err != nil {
+		return halt(err)
+	}
+	// Newest first, an outdated template of the same name may still be around
+	sort.Slice(vms, func(i, j int) bool { return vms[i].Key > vms[j].Key })
+
+	for _, info := range vms {
+		if info.IsSnapshot {
+			continue
+		}
+		vmKey := strconv.Itoa(int(info.Key))
+		vm, err := vmAPI.GetVM(ctx, vmKey)
+		if err != nil {
+			return halt(err)
+		}
+		if descriptionStamp(vm.Description, contentHashStamp) != hash {
+			ui.Message(fmt.Sprintf("Template '%s' (key %s) was built from different inputs", name, vmKey))
+			continue
+		}
+
+		state.Put("vm_id", vmKey)
+		state.Put("machine_id", int(info.ID))
+		state.Put("skipped", true)
+		ui.Say(fmt.Sprintf("Template '%s' (key %s) is up to date, skipping the build", name, vmKey))
+		return multistep.ActionHalt
+	}
+
+	ui.Message(fmt.Sprintf("No template '%s' with this content hash, building it", name))
+	return multistep.ActionContinue
+}
+
+func (s *StepContentHash) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,191 @@
+// This step strips build-time secrets and devices from the VM once it is shut down
+// What is left is the template other tenants clone from
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// StepFinalize turns the powered-off build VM into a template. Drives and NICs marked
+// build_only are removed, the template cloud-init files and hardware values are applied,
+// checkpoint snapshots taken during the build are deleted and the content hash is stamped on
+// the description. With Strip, the build's cloud-init files are removed, the console password
+// is disabled and CD-ROM media is ejected. Without it they are kept and listed in the output.
+type StepFinalize struct {
+	VmConfig VmConfig
+	// Strip removes what the build needed but clones should not get, set by finalize_template
+	Strip bool
+	// TemplateCPUCores and TemplateRAM replace the build's values when set
+	TemplateCPUCores int
+	TemplateRAM      int
+	// TemplateCloudInitFiles replace the build's cloud-init files, KeepCloudInitFiles leaves them
+	TemplateCloudInitFiles []CloudInitFile
+	KeepCloudInitFiles     bool
+	// CheckpointPrefix names the build's checkpoint snapshots, they are removed
+	CheckpointPrefix string
+}
+
+func (s *StepFinalize) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+	machineID := state.Get("machine_id").(int)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("finalizing the template failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Finalizing the template...")
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+	driveAPI := client.NewDriveApi(c)
+	nicAPI := client.NewNicApi(c)
+
+	if err := s.replaceCloudInitFiles(ctx, ui, vmAPI, vmKey); err != nil {
+		return halt(err)
+	}
+
+	// The VM is read as well, an existing VM may have a console password the config does not set
+	vm, err := vmAPI.GetVM(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+
+	fields := map[string]interface{}{}
+	if vm.ConsolePassEnabled || s.VmConfig.ConsolePassEnabled || s.VmConfig.ConsolePass != "" {
+		if s.Strip {
+			fields["console_pass_enabled"] = false
+			fields["console_pass"] = ""
+			ui.Message("Disabling the console password")
+		} else {
+			ui.Message("Keeping the console password, set finalize_template to disable it")
+		}
+	}
+	if s.TemplateCPUCores > 0 {
+		fields["cpu_cores"] = s.TemplateCPUCores
+		ui.Message(fmt.Sprintf("Setting CPU cores to %d", s.TemplateCPUCores))
+	}
+	if s.TemplateRAM > 0 {
+		fields["ram"] = s.TemplateRAM
+		ui.Message(fmt.Sprintf("Setting RAM to %d MB", s.TemplateRAM))
+	}
+	// skip_if_unchanged finds the template by this hash on the next build
+	if hash, ok := state.Get("content_hash").(string); ok {
+		fields["description"] = stampDescription(vm.Description, contentHashStamp, hash)
+	}
+	if len(fields) > 0 {
+		if err := vmAPI.UpdateVM(ctx, vmKey, fields); err != nil {
+			return halt(err)
+		}
+	}
+
+	// Build-only drives go first, so they are not ejected just before being removed
+	removed := map[string]bool{}
+	diskKeys, _ := state.Get("disk_keys").([]string)
+	for i, disk := range s.VmConfig.VmDiskConfigs {
+		if !disk.BuildOnly || i >= len(diskKeys) || diskKeys[i] == "" {
+			continue
+		}
+		ui.Message(fmt.Sprintf("Removing build-only drive '%s'", disk.Name))
+		if err := driveAPI.DeleteVMDisk(ctx, diskKeys[i]); err != nil {
+			return halt(err)
+		}
+		removed[diskKeys[i]] = true
+	}
+
+	disks, err := driveAPI.ListVMDisks(ctx, machineID)
+	if err != nil {
+		return halt(err)
+	}
+	for _, disk := range disks {
+		key := strconv.Itoa(disk.Key)
+		if disk.Media != "cdrom" || disk.MediaSource == 0 || removed[key] {
+			continue
+		}
+		if !s.Strip {
+			ui.Message(fmt.Sprintf("Keeping the media of CD-ROM '%s', set finalize_template to eject it", disk.Name))
+			continue
+		}
+		ui.Message(fmt.Sprintf("Ejecting the media of CD-ROM '%s'", disk.Name))
+		if err := driveAPI.EjectDiskMedia(ctx, key); err != nil {
+			return halt(err)
+		}
+	}
+
+	nicKeys, _ := state.Get("nic_keys").([]string)
+	for i, nic := range s.VmConfig.VmNicConfigs {
+		if !nic.BuildOnly || i >= len(nicKeys) || nicKeys[i] == "" {
+			continue
+		}
+		ui.Message(fmt.Sprintf("Removing build-only NIC '%s'", nic.Name))
+		if err := nicAPI.DeleteVMNic(ctx, nicKeys[i]); err != nil {
+			return halt(err)
+		}
+	}
+
+	snapshots, err := vmAPI.GetVMSnapshots(ctx, machineID, "")
+	if err != nil {
+		return halt(err)
+	}
+	for _, snapshot := range snapshots {
+		if !strings.HasPrefix(snapshot.Name, s.CheckpointPrefix) {
+			continue
+		}
+		ui.Message(fmt.Sprintf("Deleting checkpoint '%s'", strings.TrimPrefix(snapshot.Name, s.CheckpointPrefix)))
+		if err := vmAPI.DeleteSnapshot(ctx, strconv.Itoa(snapshot.Key)); err != nil {
+			return halt(err)
+		}
+	}
+
+	ui.Say("Template finalized")
+	return multistep.ActionContinue
+}
+
+// replaceCloudInitFiles removes the build's cloud-init files, which often carry passwords and
+// keys, and stores the template files in their place. Without Strip or template files, the
+// build's files are kept.
+func (s *StepFinalize) replaceCloudInitFiles(ctx context.Context, ui packersdk.Ui, vmAPI *client.VMApi, vmKey string) error {
+	if s.KeepCloudInitFiles {
+		return nil
+	}
+
+	files, err := vmAPI.ListCloudInitFiles(ctx, vmKey)
+	if err != nil {
+		return err
+	}
+	if !s.Strip && len(s.TemplateCloudInitFiles) == 0 {
+		for _, file := range files {
+			ui.Message(fmt.Sprintf("Keeping build cloud-init file '%s', set finalize_template to remove it", file.Name))
+		}
+		return nil
+	}
+	if len(files) > 0 {
+		ui.Message(fmt.Sprintf("Removing %d build cloud-init file(s)", len(files)))
+	}
+	for _, file := range files {
+		if err := vmAPI.DeleteCloudInitFile(ctx, file.Key); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range s.TemplateCloudInitFiles {
+		ui.Message(fmt.Sprintf("Adding template cloud-init file '%s'", file.Name))
+		if err := vmAPI.CreateCloudInitFile(ctx, vmKey, client.CloudInitFileAPI{Name: file.Name, Contents: file.Contents}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StepFinalize) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,153 @@
+// This step generalizes the guest OS so that VMs cloned from the template boot as new machines
+// The cleanup powers the VM off itself, StepShutdown then finds it already off
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+const (
+	linuxGeneralizeScriptPath = "/tmp/packer-generalize.sh"
+	windowsUnattendPath       = `C:\Windows\Temp\packer-unattend.xml`
+	windowsSysprepCommand     = `C:\Windows\System32\Sysprep\sysprep.exe /generalize /oobe /shutdown /quiet`
+)
+
+// linuxGeneralizeScript removes everything that identifies the build VM, then powers off.
+// The shutdown is delayed so the communicator sees the script exit cleanly.
+const linuxGeneralizeScript = `#!/bin/sh
+set -e
+
+if command -v cloud-init >/dev/null 2>&1; then
+  cloud-init clean --logs --seed
+fi
+
+# A new machine-id is generated on first boot
+truncate -s 0 /etc/machine-id
+rm -f /var/lib/dbus/machine-id
+
+# Host keys are regenerated by cloud-init or the ssh service on first boot
+rm -f /etc/ssh/ssh_host_*
+
+rm -f /var/lib/dhcp/*.leases /var/lib/dhclient/*.lease* /var/lib/NetworkManager/*.lease
+rm -rf /var/lib/systemd/network/* /run/systemd/netif/leases/*
+
+rm -f /root/.bash_history /home/*/.bash_history
+rm -f "$0"
+
+nohup sh -c 'sleep 2; shutdown -P now' >/dev/null 2>&1 &
+`
+
+// StepGeneralize runs an OS-family specific cleanup over the communicator and waits for the
+// VM to power off. Linux guests get cloud-init, machine-id, host key, DHCP lease and shell
+// history cleanup, Windows guests are sysprepped with an optional unattend file.
+type StepGeneralize struct {
+	OSFamily string
+	// Username decides whether the Linux cleanup needs sudo
+	Username string
+	// SysprepUnattend is a local unattend.xml passed to sysprep
+	SysprepUnattend string
+	// Timeout bounds the cleanup and the wait for power-off
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+func (s *StepGeneralize) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("generalize failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok || comm == nil {
+		return halt(fmt.Errorf("no communicator available"))
+	}
+
+	ui.Say(fmt.Sprintf("Generalizing the %s guest...", s.OSFamily))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	cmd, err := s.start(timeoutCtx, comm)
+	if err != nil {
+		return halt(err)
+	}
+
+	// The command may end with the connection as the guest powers off, so only an explicit
+	// failure exit code counts. The power state decides when it is done.
+	failed := make(chan error, 1)
+	go func() {
+		cmd.Wait()
+		if status := cmd.ExitStatus(); status != 0 && status != packersdk.CmdDisconnect {
+			failed <- fmt.Errorf("cleanup command exited with code %d", status)
+			cancel()
+		}
+	}()
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+	vmAPI.PollInterval = s.PollInterval
+
+	ui.Message(fmt.Sprintf("Waiting up to %v for the guest to power off...", s.Timeout))
+	if err := vmAPI.WaitForPowerState(timeoutCtx, vmKey, false); err != nil {
+		select {
+		case cmdErr := <-failed:
+			return halt(cmdErr)
+		default:
+		}
+		if ctx.Err() != nil {
+			return halt(ctx.Err())
+		}
+		return halt(fmt.Errorf("VM did not power off within %v: %w", s.Timeout, err))
+	}
+
+	ui.Say("Guest generalized and powered off")
+	state.Put("vm_generalized", true)
+	return multistep.ActionContinue
+}
+
+// start uploads what the cleanup needs and starts it
+func (s *StepGeneralize) start(ctx context.Context, comm packersdk.Communicator) (*packersdk.RemoteCmd, error) {
+	var command string
+	switch s.OSFamily {
+	case "windows":
+		command = windowsSysprepCommand
+		if s.SysprepUnattend != "" {
+			f, err := os.Open(s.SysprepUnattend)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open sysprep_unattend: %w", err)
+			}
+			defer f.Close()
+			if err := comm.Upload(windowsUnattendPath, f, nil); err != nil {
+				return nil, fmt.Errorf("failed to upload sysprep_unattend: %w", err)
+			}
+			command += " /unattend:" + windowsUnattendPath
+		}
+	default:
+		if err := comm.Upload(linuxGeneralizeScriptPath, strings.NewReader(linuxGeneralizeScript), nil); err != nil {
+			return nil, fmt.Errorf("failed to upload the cleanup script: %w", err)
+		}
+		command = sudoCommand(s.Username, "sh "+linuxGeneralizeScriptPath)
+	}
+
+	cmd := &packersdk.RemoteCmd{Command: command}
+	if err := comm.Start(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("failed to start the cleanup: %w", err)
+	}
+	return cmd, nil
+}
+
+func (s *StepGeneralize) Cleanup(state multistep.StateBag) {}
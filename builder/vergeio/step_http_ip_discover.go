@@ -0,0 +1,51 @@
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepHTTPIPDiscover finds the address the VM can reach the build's HTTP server on and
+// stores it as http_ip. An explicit http_bind_address is used as is, otherwise it is the
+// local address of the route to the VergeIO cluster, which the VM's network shares in the
+// usual setup.
+type StepHTTPIPDiscover struct {
+	HTTPAddress   string
+	ClusterConfig ClusterConfig
+}
+
+func (s *StepHTTPIPDiscover) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if s.HTTPAddress != "" && s.HTTPAddress != "0.0.0.0" {
+		state.Put("http_ip", s.HTTPAddress)
+		return multistep.ActionContinue
+	}
+
+	target := s.ClusterConfig.Endpoint
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, strconv.Itoa(s.ClusterConfig.Port))
+	}
+
+	// A UDP dial only resolves the route, nothing is sent
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		err = fmt.Errorf("failed to find the local address for the HTTP server, set http_bind_address: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	defer conn.Close()
+
+	ip := conn.LocalAddr().(*net.UDPAddr).IP.String()
+	ui.Message(fmt.Sprintf("HTTP server address for the VM: %s", ip))
+	state.Put("http_ip", ip)
+	return multistep.ActionContinue
+}
+
+func (s *StepHTTPIPDiscover) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,209 @@
+// This step uploads the disk images of import_source to the media catalog
+// It runs before preflight, which resolves the imported disks' media_source_name to the files
+package vergeio
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// StepImportUpload makes sure every disk image of the import source is in the media catalog.
+// Uploaded files carry the SHA-256 of the image in their description, and a catalog file with
+// the same name is only reused when it carries the checksum of the local image, so repeated
+// builds of the same appliance upload nothing while an updated image is never silently stale.
+// Uploaded files are kept for the same reason.
+type StepImportUpload struct {
+	Source *importSource
+}
+
+// imageChecksumStamp is the description line of an uploaded catalog file that holds the image's SHA-256
+const imageChecksumStamp = "packer-image-sha256"
+
+func (s *StepImportUpload) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("uploading the import source failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	fileAPI := client.NewFileApi(c)
+
+	ui.Say(fmt.Sprintf("Checking the media catalog for the disk images of %s...", filepath.Base(s.Source.Path)))
+
+	pending := map[string]importDisk{}
+	stored := map[string]importDisk{}
+	catalog := map[string]client.FileInfo{}
+	for _, disk := range s.Source.Disks {
+		files, err := fileAPI.GetFiles(ctx, disk.CatalogName)
+		if err != nil {
+			return halt(err)
+		}
+		switch {
+		case len(files) == 0:
+			pending[ovaEntryName(disk.Href)] = disk
+		case len(files) > 1:
+			return halt(fmt.Errorf("catalog file name '%s' is ambiguous: %d files match", disk.CatalogName, len(files)))
+		case files[0].Filesize != disk.Size:
+			return halt(fmt.Errorf("a different catalog file named '%s' already exists (%d bytes, the image has %d), rename or delete it",
+				disk.CatalogName, files[0].Filesize, disk.Size))
+		default:
+			stored[ovaEntryName(disk.Href)] = disk
+			catalog[disk.CatalogName] = files[0]
+		}
+	}
+
+	// An updated image often keeps its size, only the checksum tells it apart from the stored one
+	if len(stored) > 0 {
+		ui.Message(fmt.Sprintf("Comparing %d disk image(s) with the catalog files of the same name", len(stored)))
+		err := s.eachImage(stored, func(disk importDisk, r io.Reader) error {
+			sum, err := sha256Hex(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", disk.Href, err)
+			}
+			file := catalog[disk.CatalogName]
+			switch recorded := descriptionStamp(file.Description, imageChecksumStamp); recorded {
+			case sum:
+				ui.Message(fmt.Sprintf("Reusing catalog file '%s' (key %d)", disk.CatalogName, file.Key))
+				return nil
+			case "":
+				return fmt.Errorf("catalog file '%s' (key %d) has no image checksum, it was not uploaded by this builder, rename or delete it",
+					disk.CatalogName, file.Key)
+			default:
+				return fmt.Errorf("catalog file '%s' (key %d) holds a different image (sha256 %s, the image has %s), rename or delete it",
+					disk.CatalogName, file.Key, recorded, sum)
+			}
+		})
+		if err != nil {
+			return halt(err)
+		}
+	}
+	if len(pending) == 0 {
+		return multistep.ActionContinue
+	}
+
+	upload := func(disk importDisk, r io.Reader) error {
+		ui.Message(fmt.Sprintf("Uploading %s as '%s' (%s)", disk.Href, disk.CatalogName, formatBytes(disk.Size)))
+		key, err := fileAPI.CreateFile(ctx, disk.CatalogName, disk.FileType, disk.Size)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		reported := int64(0)
+		err = fileAPI.UploadFile(ctx, key, io.TeeReader(r, hash), disk.Size, func(sent int64) {
+			// Report every 10%, an empty image has nothing to report
+			if disk.Size > 0 && sent*10/disk.Size > reported*10/disk.Size {
+				ui.Message(fmt.Sprintf("  %s: %d%% (%s)", disk.CatalogName, sent*100/disk.Size, formatBytes(sent)))
+			}
+			reported = sent
+		})
+		// Without the checksum the next build can't reuse the file, so that counts as a failed upload too
+		if err == nil {
+			err = fileAPI.SetFileDescription(ctx, key, stampDescription("", imageChecksumStamp, hex.EncodeToString(hash.Sum(nil))))
+		}
+		if err != nil {
+			// A partial file of the right size would be reused by the next build
+			if delErr := fileAPI.DeleteFile(context.Background(), key); delErr != nil {
+				ui.Error(fmt.Sprintf("Failed to delete the partially uploaded file '%s' (key %d), delete it manually: %v", disk.CatalogName, key, delErr))
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := s.eachImage(pending, upload); err != nil {
+		return halt(err)
+	}
+
+	ui.Say("Disk images uploaded")
+	return multistep.ActionContinue
+}
+
+// eachImage calls fn with the contents of every disk image in disks, which is keyed by the
+// images' normalised href. It fails if an image is missing from the source.
+func (s *StepImportUpload) eachImage(disks map[string]importDisk, fn func(importDisk, io.Reader) error) error {
+	remaining := make(map[string]importDisk, len(disks))
+	for name, disk := range disks {
+		remaining[name] = disk
+	}
+
+	if strings.EqualFold(filepath.Ext(s.Source.Path), ".ova") {
+		if err := s.eachOVAImage(remaining, fn); err != nil {
+			return err
+		}
+	} else {
+		dir := filepath.Dir(s.Source.Path)
+		for name, disk := range remaining {
+			f, err := os.Open(filepath.Join(dir, filepath.FromSlash(disk.Href)))
+			if err != nil {
+				return err
+			}
+			err = fn(disk, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			delete(remaining, name)
+		}
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d disk image(s) were not found in %s", len(remaining), s.Source.Path)
+	}
+	return nil
+}
+
+// eachOVAImage streams the images straight out of the archive, in archive order, removing
+// each from remaining once fn is done with it
+func (s *StepImportUpload) eachOVAImage(remaining map[string]importDisk, fn func(importDisk, io.Reader) error) error {
+	f, err := os.Open(s.Source.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for len(remaining) > 0 {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.Source.Path, err)
+		}
+		name := ovaEntryName(header.Name)
+		if disk, ok := remaining[name]; ok {
+			if err := fn(disk, tr); err != nil {
+				return err
+			}
+			delete(remaining, name)
+		}
+	}
+	return nil
+}
+
+// sha256Hex returns the hex SHA-256 of everything r yields
+func sha256Hex(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *StepImportUpload) Cleanup(state multistep.StateBag) {}
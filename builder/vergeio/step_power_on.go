@@ -27,7 +27,12 @@ type StepPowerOn struct {
 }
 
 // extractIPFromCloudInit parses cloud-init network-config to extract the static IP address
+// An address from the cloud_init network block is used as is, without parsing the rendered file
 func (s *StepPowerOn) extractIPFromCloudInit(config *Config) (string, error) {
+	if ip := config.VmConfig.CloudInit.staticIP(); ip != "" {
+		return ip, nil
+	}
+
 	// Look for network-config cloud-init file
 	for _, cloudInitFile := range config.VmConfig.CloudInitFiles {
 		if cloudInitFile.Name == "network-config" {
@@ -82,59 +87,33 @@ func (s *StepPowerOn) Run(ctx context.Context, state multistep.StateBag) multist
 	// Power on the VM
 	ui.Say("Sending power-on command to VM...")
 
-	// Call PowerOnVM with the VM Key
-	err := vmAPI.PowerOnVM(vmKeyStr)
-	if err != nil {
-		ui.Error(fmt.Sprintf("Failed to power on VM: %v", err))
-		state.Put("error", fmt.Errorf("failed to power on VM: %w", err))
-		return multistep.ActionHalt
-	}
-
-	ui.Say("Power-on command sent successfully")
-
-	// Wait for VM to reach running state
-	ui.Say("Waiting for VM to reach running state...")
-
 	// Create timeout context for power-on verification
 	timeoutCtx, cancel := context.WithTimeout(ctx, powerOnTimeout)
 	defer cancel()
 
-	// Poll every 5 seconds to check power state
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-timeoutCtx.Done():
+	// Call PowerOnVM with the VM Key, it polls the power state until the VM is running
+	ui.Say("Waiting for VM to reach running state...")
+	err := vmAPI.PowerOnVM(timeoutCtx, vmKeyStr)
+	if err != nil {
+		if ctx.Err() != nil {
+			ui.Error("Build cancelled while waiting for VM to power on")
+			state.Put("error", ctx.Err())
+			return multistep.ActionHalt
+		}
+		if timeoutCtx.Err() != nil {
 			ui.Error(fmt.Sprintf("Timeout waiting for VM to power on (waited %v)", powerOnTimeout))
 			ui.Error("The VM may have hardware issues or insufficient resources")
 			state.Put("error", fmt.Errorf("timeout waiting for VM to power on after %v", powerOnTimeout))
 			// Mark for cleanup
 			state.Put("vm_power_on_failed", true)
 			return multistep.ActionHalt
-
-		case <-ticker.C:
-			ui.Message("Checking VM power state...")
-
-			// Use VergeIO API to check if VM is actually running
-			isRunning, err := vmAPI.IsVMRunning(ctx, vmKeyStr)
-			if err != nil {
-				ui.Error(fmt.Sprintf("Failed to check VM power state: %v", err))
-				ui.Message("Continuing anyway - VM may still be starting up")
-				continue
-			}
-
-			if isRunning != nil && *isRunning {
-				ui.Say("VM is now powered on and running")
-				goto bootWait
-			} else {
-				ui.Message("VM is not yet running, continuing to wait...")
-				continue
-			}
 		}
+		ui.Error(fmt.Sprintf("Failed to power on VM: %v", err))
+		state.Put("error", fmt.Errorf("failed to power on VM: %w", err))
+		return multistep.ActionHalt
 	}
 
-bootWait:
+	ui.Say("VM is now powered on and running")
 
 	// Mark that VM has been powered on for cleanup purposes
 	state.Put("vm_powered_on", true)
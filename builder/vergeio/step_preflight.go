@@ -0,0 +1,528 @@
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// StepPreflight verifies the build against the live cluster before anything is created.
+// Names are resolved to keys, every referenced key is looked up, capacity is checked and all
+// problems are reported together, so a broken config fails in seconds instead of halfway
+// through VM creation.
+type StepPreflight struct {
+	Config *Config
+}
+
+// preflightPermissions are the tables the build reads from or writes to
+var preflightPermissions = []struct {
+	endpoint string
+	what     string
+}{
+	{client.VMEndpoint, "virtual machines"},
+	{client.DiskEndpoint, "machine drives"},
+	{client.NICEndpoint, "machine NICs"},
+	{client.NetworkEndpoint, "networks"},
+	{client.FileEndpoint, "media files"},
+	{client.ClusterEndpoint, "clusters"},
+	{client.NodeEndpoint, "nodes"},
+	{client.StorageTierEndpoint, "storage tiers"},
+}
+
+// writePermission is what the build does with the rows of one table
+type writePermission struct {
+	endpoint string
+	what     string
+	create   bool
+	modify   bool
+	delete   bool
+}
+
+// preflightWritePermissions are the tables the build creates or changes rows in, listing
+// them is not enough
+var preflightWritePermissions = []writePermission{
+	{client.VMEndpoint, "virtual machines", true, true, true},
+	{client.DiskEndpoint, "machine drives", true, true, false},
+	{client.NICEndpoint, "machine NICs", true, true, false},
+	{client.CloudInitFileEndpoint, "cloud-init files", true, false, true},
+	{client.VMActionEndpoint, "VM actions", true, false, false},
+}
+
+func (s *StepPreflight) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	ui.Say("Running preflight checks against the VergeIO cluster...")
+
+	cc := s.Config.ClusterConfig
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+
+	p := &preflight{
+		ctx:    ctx,
+		client: c,
+		vm:     &s.Config.VmConfig,
+		user:   cc.Username,
+		denied: make(map[string]bool),
+		errs:   &packer.MultiError{},
+	}
+
+	p.checkPermissions()
+	p.checkWritePermissions(s.Config.importSource != nil)
+	p.resolveNames()
+	p.checkNetworks()
+	p.checkMediaSources()
+	p.checkPlacement()
+	p.checkHAGroup()
+	p.checkStorage()
+
+	if len(p.errs.Errors) > 0 {
+		err := fmt.Errorf("preflight checks failed: %w", p.errs)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	for _, warning := range p.warnings {
+		ui.Message(fmt.Sprintf("Warning: %s", warning))
+	}
+
+	// StepVMCreate reads the VM config from state, hand it the resolved keys
+	state.Put("vm_config", s.Config.VmConfig)
+
+	ui.Say("Preflight checks passed")
+	return multistep.ActionContinue
+}
+
+func (s *StepPreflight) Cleanup(state multistep.StateBag) {
+	// Preflight only reads from the cluster, nothing to clean up
+}
+
+// preflight holds the state shared by the individual checks
+type preflight struct {
+	ctx    context.Context
+	client *client.Client
+	vm     *VmConfig
+	user   string
+
+	// denied records the endpoints the account may not list, checks depending on them are skipped
+	denied   map[string]bool
+	errs     *packer.MultiError
+	warnings []string
+
+	// fileSizes records the size of every verified media file, keyed by file key
+	fileSizes map[int]int64
+}
+
+func (p *preflight) fail(format string, args ...interface{}) {
+	p.errs = packer.MultiErrorAppend(p.errs, fmt.Errorf(format, args...))
+}
+
+func (p *preflight) checkPermissions() {
+	for _, perm := range preflightPermissions {
+		ok, err := p.client.CanList(perm.endpoint)
+		if err != nil {
+			p.fail("permissions: unable to read %s: %s", perm.what, err)
+			p.denied[perm.endpoint] = true
+			continue
+		}
+		if !ok {
+			p.fail("permissions: account '%s' is not allowed to list %s", p.user, perm.what)
+			p.denied[perm.endpoint] = true
+		}
+	}
+}
+
+// checkWritePermissions verifies the account may create and change what the build creates
+// and changes. The grants are only known when the account may read the permissions tables,
+// otherwise the check is skipped and a missing permission fails the build when it is used.
+func (p *preflight) checkWritePermissions(upload bool) {
+	permissions, ok, err := p.client.GetTablePermissions(p.user)
+	if err != nil {
+		p.fail("permissions: unable to read the grants of account '%s': %s", p.user, err)
+		return
+	}
+	if !ok {
+		log.Printf("[VergeIO]: Preflight - the grants of account '%s' are not readable, skipping the write permission check", p.user)
+		return
+	}
+
+	required := append([]writePermission{}, preflightWritePermissions...)
+	if upload {
+		required = append(required, writePermission{client.FileEndpoint, "media files", true, false, false})
+	}
+
+	root := permissions["/"]
+	for _, perm := range required {
+		table := strings.TrimPrefix(perm.endpoint, client.APIEndpoint+"/")
+		granted := permissions[table]
+		var missing []string
+		if perm.create && !granted.Create && !root.Create {
+			missing = append(missing, "create")
+		}
+		if perm.modify && !granted.Modify && !root.Modify {
+			missing = append(missing, "modify")
+		}
+		if perm.delete && !granted.Delete && !root.Delete {
+			missing = append(missing, "delete")
+		}
+		if len(missing) > 0 {
+			p.fail("permissions: account '%s' may not %s %s", p.user, strings.Join(missing, " or "), perm.what)
+		}
+	}
+}
+
+// resolveNames replaces every name-based reference with the key it names.
+// A name that matches nothing, or more than one object, is an error.
+func (p *preflight) resolveNames() {
+	vmAPI := client.NewVMApi(p.client)
+	clusterAPI := client.NewClusterApi(p.client)
+
+	if name := p.vm.PreferredNodeName; name != "" && !p.denied[client.NodeEndpoint] {
+		node, err := clusterAPI.GetNodeByName(p.ctx, name)
+		if err != nil {
+			p.fail("preferred_node_name: %s", err)
+		} else {
+			p.vm.PreferredNode = strconv.Itoa(node.Key)
+		}
+	}
+
+	if name := p.vm.SnapshotProfileName; name != "" {
+		profile, err := vmAPI.GetSnapshotProfileByName(p.ctx, name)
+		if err != nil {
+			p.fail("snapshot_profile_name: %s", err)
+		} else {
+			p.vm.SnapshotProfile = strconv.Itoa(profile.Key)
+		}
+	}
+
+	if !p.denied[client.NetworkEndpoint] {
+		networkAPI := client.NewNetworkApi(p.client)
+		for i := range p.vm.VmNicConfigs {
+			nic := &p.vm.VmNicConfigs[i]
+			if nic.VNETName == "" {
+				continue
+			}
+			network, err := networkAPI.GetNetworkByName(p.ctx, nic.VNETName)
+			if err != nil {
+				p.fail("vm_nics[%d].vnet_name: %s", i, err)
+				continue
+			}
+			nic.VNET = int(network.ID)
+		}
+	}
+
+	fileAPI := client.NewFileApi(p.client)
+	for i := range p.vm.VmDiskConfigs {
+		disk := &p.vm.VmDiskConfigs[i]
+		switch {
+		case disk.MediaSourceName != "" && !p.denied[client.FileEndpoint]:
+			file, err := fileAPI.GetFileByName(p.ctx, disk.MediaSourceName)
+			if err != nil {
+				p.fail("vm_disks[%d].media_source_name: %s", i, err)
+				continue
+			}
+			disk.MediaSource = file.Key
+		case disk.CloneFromVM != "" && !p.denied[client.VMEndpoint]:
+			source, err := resolveCloneSource(p.ctx, vmAPI, disk.CloneFromVM, disk.CloneFromDrive)
+			if err != nil {
+				p.fail("vm_disks[%d].clone_from_vm: %s", i, err)
+				continue
+			}
+			disk.MediaSource = source
+		}
+	}
+}
+
+// resolveCloneSource finds the media source key of a drive on another VM.
+// Without a drive name the VM must have exactly one disk.
+func resolveCloneSource(ctx context.Context, vmAPI *client.VMApi, vmName string, driveName string) (int, error) {
+	vm, err := vmAPI.GetVMByName(ctx, vmName)
+	if err != nil {
+		return 0, err
+	}
+
+	var matches []*client.VMDriveInfo
+	for _, drive := range vm.Drives {
+		if driveName != "" && drive.Name == driveName {
+			matches = append(matches, drive)
+		}
+		if driveName == "" && drive.Media == "disk" {
+			matches = append(matches, drive)
+		}
+	}
+
+	switch {
+	case len(matches) == 0 && driveName != "":
+		return 0, fmt.Errorf("VM '%s' has no drive named '%s'", vmName, driveName)
+	case len(matches) == 0:
+		return 0, fmt.Errorf("VM '%s' has no disk to clone", vmName)
+	case len(matches) > 1 && driveName != "":
+		return 0, fmt.Errorf("drive name '%s' is ambiguous: VM '%s' has %d drives with that name", driveName, vmName, len(matches))
+	case len(matches) > 1:
+		return 0, fmt.Errorf("VM '%s' has %d disks, set clone_from_drive to pick one", vmName, len(matches))
+	}
+
+	if matches[0].MediaSource == nil {
+		return 0, fmt.Errorf("drive '%s' on VM '%s' has no media source", matches[0].Name, vmName)
+	}
+	return int(matches[0].MediaSource.Key), nil
+}
+
+func (p *preflight) checkNetworks() {
+	if p.denied[client.NetworkEndpoint] {
+		return
+	}
+
+	networkAPI := client.NewNetworkApi(p.client)
+	for i, nic := range p.vm.VmNicConfigs {
+		if nic.VNET <= 0 {
+			continue
+		}
+		network, err := networkAPI.GetNetwork(p.ctx, nic.VNET)
+		if err != nil {
+			p.fail("vm_nics[%d].vnet: %s", i, err)
+			continue
+		}
+		log.Printf("[VergeIO]: Preflight - NIC '%s' uses network '%s' (%d)", nic.Name, network.Name, network.ID)
+	}
+}
+
+func (p *preflight) checkMediaSources() {
+	p.fileSizes = make(map[int]int64)
+	if p.denied[client.FileEndpoint] {
+		return
+	}
+
+	fileAPI := client.NewFileApi(p.client)
+	for i, disk := range p.vm.VmDiskConfigs {
+		// Clone sources point at existing drives rather than the file catalog
+		if disk.MediaSource <= 0 || disk.Media == "clone" {
+			continue
+		}
+		file, err := fileAPI.GetFile(p.ctx, disk.MediaSource)
+		if err != nil {
+			p.fail("vm_disks[%d].media_source: %s", i, err)
+			continue
+		}
+		p.fileSizes[file.Key] = file.Filesize
+		log.Printf("[VergeIO]: Preflight - disk '%s' uses file '%s' (%d bytes)", disk.Name, file.Name, file.Filesize)
+	}
+}
+
+// checkPlacement verifies the cluster and preferred node and that the VM fits on them.
+func (p *preflight) checkPlacement() {
+	if p.denied[client.ClusterEndpoint] || p.denied[client.NodeEndpoint] {
+		return
+	}
+
+	clusterAPI := client.NewClusterApi(p.client)
+	clusters, err := clusterAPI.GetClusters(p.ctx)
+	if err != nil {
+		p.fail("cluster: %s", err)
+		return
+	}
+	nodes, err := clusterAPI.GetNodes(p.ctx)
+	if err != nil {
+		p.fail("preferred_node: %s", err)
+		return
+	}
+
+	candidates := clusters
+	if p.vm.Cluster != "" {
+		candidates = nil
+		for _, cluster := range clusters {
+			if strconv.Itoa(cluster.Key) == p.vm.Cluster {
+				candidates = append(candidates, cluster)
+			}
+		}
+		if len(candidates) == 0 {
+			p.fail("cluster: cluster %s does not exist", p.vm.Cluster)
+			return
+		}
+	}
+
+	candidateNodes := make([]client.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		for _, cluster := range candidates {
+			if node.Cluster == cluster.Key {
+				candidateNodes = append(candidateNodes, node)
+			}
+		}
+	}
+
+	if p.vm.PreferredNode != "" {
+		var preferred *client.NodeInfo
+		for i := range nodes {
+			if strconv.Itoa(nodes[i].Key) == p.vm.PreferredNode {
+				preferred = &nodes[i]
+			}
+		}
+		switch {
+		case preferred == nil && p.vm.PreferredNodeName != "":
+			// Resolution already failed and was reported
+		case preferred == nil:
+			p.fail("preferred_node: node %s does not exist", p.vm.PreferredNode)
+		case !preferred.Running:
+			p.fail("preferred_node: node '%s' is not running", preferred.Name)
+		default:
+			candidateNodes = []client.NodeInfo{*preferred}
+		}
+	}
+
+	if p.vm.RAM > 0 {
+		var mostFree int64
+		for _, cluster := range candidates {
+			if free := cluster.TotalRAM - cluster.UsedRAM; free > mostFree {
+				mostFree = free
+			}
+		}
+		if int64(p.vm.RAM) > mostFree {
+			p.fail("ram: the VM needs %d MB but at most %d MB is free on the target cluster", p.vm.RAM, mostFree)
+		}
+	}
+
+	if p.vm.CPUCores > 0 && len(candidateNodes) > 0 {
+		maxCores := 0
+		for _, node := range candidateNodes {
+			if node.Cores > maxCores {
+				maxCores = node.Cores
+			}
+		}
+		if p.vm.CPUCores > maxCores {
+			p.fail("cpu_cores: the VM needs %d cores but the largest target node only has %d", p.vm.CPUCores, maxCores)
+		}
+	}
+}
+
+// checkHAGroup verifies the VM can run apart from the other VMs of its ha_group. The group is
+// a label shared by VMs, not an object, so a group no other VM uses is most likely a typo.
+func (p *preflight) checkHAGroup() {
+	if p.vm.HAGroup == "" || p.denied[client.VMEndpoint] || p.denied[client.NodeEndpoint] {
+		return
+	}
+
+	members, err := client.NewVMApi(p.client).FindVMsByHAGroup(p.ctx, p.vm.HAGroup)
+	if err != nil {
+		p.fail("ha_group: %s", err)
+		return
+	}
+	if len(members) == 0 {
+		p.warnings = append(p.warnings, fmt.Sprintf("ha_group: no other VM is in group '%s', check the name", p.vm.HAGroup))
+		return
+	}
+
+	nodes, err := client.NewClusterApi(p.client).GetNodes(p.ctx)
+	if err != nil {
+		p.fail("ha_group: %s", err)
+		return
+	}
+	running := 0
+	for _, node := range nodes {
+		if node.Running {
+			running++
+		}
+	}
+	if len(members) >= running {
+		p.fail("ha_group: group '%s' already has %d VM(s) and only %d node(s) are running, the VM cannot run apart from them",
+			p.vm.HAGroup, len(members), running)
+	}
+}
+
+// checkStorage sums the space every new drive needs per preferred tier and compares it
+// to what the tier has free. Drives without a preferred tier count against the default
+// tier of the VM's cluster.
+func (p *preflight) checkStorage() {
+	if p.denied[client.StorageTierEndpoint] {
+		return
+	}
+
+	needed := make(map[string]int64)
+	defaultTier, defaultKnown := "", false
+	for _, disk := range p.vm.VmDiskConfigs {
+		tier := disk.PreferredTier
+		if tier == "" {
+			if !defaultKnown {
+				defaultTier, defaultKnown = p.defaultTier(), true
+			}
+			if tier = defaultTier; tier == "" {
+				continue
+			}
+		}
+		size := disk.sizeBytes
+		switch disk.Media {
+		case "cdrom", "nonpersistent", "efidisk":
+			continue
+		case "import":
+			if fileSize := p.fileSizes[disk.MediaSource]; fileSize > size {
+				size = fileSize
+			}
+		}
+		needed[tier] += size
+	}
+	if len(needed) == 0 {
+		return
+	}
+
+	tiers, err := client.NewClusterApi(p.client).GetStorageTiers(p.ctx)
+	if err != nil {
+		p.fail("preferred_tier: %s", err)
+		return
+	}
+
+	tierNames := make([]string, 0, len(needed))
+	for tier := range needed {
+		tierNames = append(tierNames, tier)
+	}
+	sort.Strings(tierNames)
+
+	for _, name := range tierNames {
+		var tier *client.StorageTierInfo
+		for i := range tiers {
+			if strconv.Itoa(tiers[i].Tier) == name {
+				tier = &tiers[i]
+			}
+		}
+		if tier == nil {
+			p.fail("preferred_tier: storage tier %s does not exist", name)
+			continue
+		}
+		if free := tier.Capacity - tier.Used; needed[name] > free {
+			p.fail("preferred_tier: the VM's drives need %d bytes on tier %s but only %d bytes are free",
+				needed[name], name, free)
+		}
+	}
+}
+
+// defaultTier returns the default storage tier of the cluster the VM runs on, or "" if it
+// can't be told, e.g. when several clusters qualify and their defaults differ
+func (p *preflight) defaultTier() string {
+	if p.denied[client.ClusterEndpoint] {
+		return ""
+	}
+	clusters, err := client.NewClusterApi(p.client).GetClusters(p.ctx)
+	if err != nil {
+		log.Printf("[VergeIO]: Could not read the default storage tier, drives without preferred_tier are not checked: %s", err)
+		return ""
+	}
+
+	tier := 0
+	for _, cluster := range clusters {
+		if p.vm.Cluster != "" && strconv.Itoa(cluster.Key) != p.vm.Cluster {
+			continue
+		}
+		if cluster.DefaultTier == 0 || (tier != 0 && cluster.DefaultTier != tier) {
+			log.Printf("[VergeIO]: The default storage tier of the VM's cluster is unknown, drives without preferred_tier are not checked")
+			return ""
+		}
+		tier = cluster.DefaultTier
+	}
+	if tier == 0 {
+		return ""
+	}
+	return strconv.Itoa(tier)
+}
@@ -0,0 +1,265 @@
+// This step records how the template was made, on the VM and in an optional provenance file
+// The file is an in-toto statement with a SLSA provenance predicate
+package vergeio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+	"github.com/verge-io/packer-plugin-vergeio/version"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaPredicateType   = "https://slsa.dev/provenance/v1"
+
+	// drivesDigestAlgorithm names the subject digest, see drivesDigest
+	drivesDigestAlgorithm = "vergeioDrivesSha256"
+
+	// provenanceBuilderID identifies this plugin as the builder in the provenance
+	provenanceBuilderID = "https://github.com/verge-io/packer-plugin-vergeio"
+	provenanceBuildType = provenanceBuilderID + "/builder/vergeio@v1"
+)
+
+// sourceImage is a media file or drive a disk of the build was created from
+type sourceImage struct {
+	Disk   string
+	Source string
+	Media  string
+	Key    int
+}
+
+// sourceImages lists the disks that were created from another image, with the keys resolved
+// by preflight
+func (c *Config) sourceImages() []sourceImage {
+	var images []sourceImage
+	for _, disk := range c.VmDiskConfigs {
+		if disk.MediaSource <= 0 {
+			continue
+		}
+		source := disk.MediaSourceName
+		if disk.CloneFromVM != "" {
+			source = disk.CloneFromVM
+			if disk.CloneFromDrive != "" {
+				source += "/" + disk.CloneFromDrive
+			}
+		}
+		images = append(images, sourceImage{Disk: disk.Name, Source: source, Media: disk.Media, Key: disk.MediaSource})
+	}
+	return images
+}
+
+// provenanceStatement is an in-toto v1 statement, the predicate follows SLSA provenance v1
+type provenanceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenanceResource `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type provenanceResource struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]string    `json:"externalParameters"`
+		ResolvedDependencies []provenanceResource `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  string `json:"startedOn"`
+			FinishedOn string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// StepProvenance stamps the build provenance on the finished template's description, one
+// "packer-<field>: <value>" line per field, and writes the provenance statement to
+// ProvenanceFile when it is set.
+type StepProvenance struct {
+	Config *Config
+	// Started is when the build began
+	Started time.Time
+}
+
+func (s *StepProvenance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+	hash, _ := state.Get("content_hash").(string)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("recording the build provenance failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Recording the build provenance...")
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	finished := time.Now().UTC()
+	images := s.Config.sourceImages()
+
+	vm, err := vmAPI.GetVM(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+	var imageKeys []string
+	for _, image := range images {
+		imageKeys = append(imageKeys, strconv.Itoa(image.Key))
+	}
+	stamps := []struct {
+		key   string
+		value string
+	}{
+		{"packer-build", s.Config.PackerBuildName},
+		{"packer-build-time", finished.Format(time.RFC3339)},
+		{"packer-version", s.Config.PackerCoreVersion},
+		{"packer-plugin-vergeio-version", version.PluginVersion.FormattedVersion()},
+		{"packer-source-images", strings.Join(imageKeys, ", ")},
+		{"packer-git-commit", s.Config.GitCommit},
+	}
+	description := vm.Description
+	for _, stamp := range stamps {
+		if stamp.value != "" {
+			description = stampDescription(description, stamp.key, stamp.value)
+		}
+	}
+	if err := vmAPI.UpdateVM(ctx, vmKey, map[string]interface{}{"description": description}); err != nil {
+		return halt(err)
+	}
+
+	if s.Config.ProvenanceFile == "" {
+		return multistep.ActionContinue
+	}
+
+	disks, err := client.NewDriveApi(c).ListVMDisks(ctx, state.Get("machine_id").(int))
+	if err != nil {
+		return halt(err)
+	}
+	digest, err := drivesDigest(disks)
+	if err != nil {
+		return halt(err)
+	}
+
+	statement := s.statement(cc.Endpoint, vmKey, hash, digest, images, finished)
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return halt(err)
+	}
+	if dir := filepath.Dir(s.Config.ProvenanceFile); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return halt(err)
+		}
+	}
+	if err := os.WriteFile(s.Config.ProvenanceFile, append(data, '\n'), 0644); err != nil {
+		return halt(err)
+	}
+	state.Put("provenance_file", s.Config.ProvenanceFile)
+
+	ui.Message(fmt.Sprintf("Provenance written to %s", s.Config.ProvenanceFile))
+	return multistep.ActionContinue
+}
+
+// drivesDigest identifies the drives of the finished template. VergeIO does not expose a
+// digest of the drive contents, so it is the SHA-256 of each drive's key, name, interface and
+// size, CD-ROMs left out.
+func drivesDigest(disks []client.VMDiskResourceModel) (string, error) {
+	type drive struct {
+		Key       int    `json:"key"`
+		Name      string `json:"name"`
+		Interface string `json:"interface"`
+		Size      int64  `json:"size"`
+	}
+	var drives []drive
+	for _, disk := range disks {
+		if disk.Media == "cdrom" {
+			continue
+		}
+		drives = append(drives, drive{Key: disk.Key, Name: disk.Name, Interface: disk.Interface, Size: disk.DiskSize})
+	}
+	data, err := json.Marshal(drives)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the template drives: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// statement describes the build as an in-toto statement with a SLSA provenance predicate.
+// The subject is the template VM, identified by its key and the digest of its drives. The
+// content hash of the build is an input, it is one of the external parameters.
+func (s *StepProvenance) statement(endpoint, vmKey, hash, digest string, images []sourceImage, finished time.Time) provenanceStatement {
+	statement := provenanceStatement{
+		Type: inTotoStatementType,
+		Subject: []provenanceResource{{
+			Name:        "vms/" + vmKey,
+			URI:         fmt.Sprintf("vergeio://%s/vms/%s", endpoint, vmKey),
+			Digest:      map[string]string{drivesDigestAlgorithm: digest},
+			Annotations: map[string]string{"name": s.Config.VmConfig.Name},
+		}},
+		PredicateType: slsaPredicateType,
+	}
+
+	definition := &statement.Predicate.BuildDefinition
+	definition.BuildType = provenanceBuildType
+	definition.ExternalParameters = map[string]string{
+		"buildName":   s.Config.PackerBuildName,
+		"name":        s.Config.VmConfig.Name,
+		"contentHash": hash,
+	}
+	if s.Config.GitCommit != "" {
+		definition.ExternalParameters["gitCommit"] = s.Config.GitCommit
+		definition.ResolvedDependencies = append(definition.ResolvedDependencies, provenanceResource{
+			Name:   "source",
+			Digest: map[string]string{"gitCommit": s.Config.GitCommit},
+		})
+	}
+	for _, image := range images {
+		name := image.Source
+		if name == "" {
+			name = image.Disk
+		}
+		definition.ResolvedDependencies = append(definition.ResolvedDependencies, provenanceResource{
+			Name:        name,
+			URI:         fmt.Sprintf("vergeio://%s/media_sources/%d", endpoint, image.Key),
+			Annotations: map[string]string{"disk": image.Disk, "media": image.Media},
+		})
+	}
+
+	run := &statement.Predicate.RunDetails
+	run.Builder.ID = provenanceBuilderID
+	run.Builder.Version = map[string]string{
+		"packer":                s.Config.PackerCoreVersion,
+		"packer-plugin-vergeio": version.PluginVersion.FormattedVersion(),
+	}
+	run.Metadata.StartedOn = s.Started.UTC().Format(time.RFC3339)
+	run.Metadata.FinishedOn = finished.Format(time.RFC3339)
+
+	return statement
+}
+
+func (s *StepProvenance) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,62 @@
+package vergeio
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+func TestStepProvenance_Statement(t *testing.T) {
+	config := &Config{}
+	config.PackerBuildName = "ubuntu"
+	config.PackerCoreVersion = "1.11.2"
+	config.GitCommit = "3f2c9a1e8b7d6c5f4e3d2c1b0a9f8e7d6c5b4a39"
+	config.VmConfig.Name = "ubuntu-template"
+	config.VmDiskConfigs = []VmDiskConfig{
+		{Name: "root", Media: "import", MediaSourceName: "ubuntu-22.04.qcow2", MediaSource: 12},
+		{Name: "data", Media: "disk"},
+	}
+
+	started := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	step := &StepProvenance{Config: config, Started: started}
+	digest, err := drivesDigest([]client.VMDiskResourceModel{
+		{Key: 7, Name: "root", Interface: "virtio-scsi", Media: "disk", DiskSize: 20 << 30},
+		{Key: 8, Name: "installer", Media: "cdrom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := drivesDigest([]client.VMDiskResourceModel{{Key: 7, Name: "root", Interface: "virtio-scsi", Media: "disk", DiskSize: 20 << 30}}); same != digest {
+		t.Error("CD-ROMs must not change the drives digest")
+	}
+	statement := step.statement("cluster.example.com", "41", "0123456789abcdef", digest, config.sourceImages(), started.Add(10*time.Minute))
+
+	if statement.Type != inTotoStatementType || statement.PredicateType != slsaPredicateType {
+		t.Errorf("unexpected statement type %q and predicate type %q", statement.Type, statement.PredicateType)
+	}
+	subject := statement.Subject[0]
+	if subject.Name != "vms/41" || subject.URI != "vergeio://cluster.example.com/vms/41" || subject.Digest[drivesDigestAlgorithm] != digest ||
+		subject.Annotations["name"] != "ubuntu-template" {
+		t.Errorf("unexpected subject: %+v", subject)
+	}
+	if hash := statement.Predicate.BuildDefinition.ExternalParameters["contentHash"]; hash != "0123456789abcdef" {
+		t.Errorf("expected the content hash as an external parameter, got %q", hash)
+	}
+
+	dependencies := statement.Predicate.BuildDefinition.ResolvedDependencies
+	if len(dependencies) != 2 {
+		t.Fatalf("expected the commit and the imported image, got %+v", dependencies)
+	}
+	if dependencies[0].Digest["gitCommit"] != config.GitCommit {
+		t.Errorf("unexpected source dependency: %+v", dependencies[0])
+	}
+	if image := dependencies[1]; image.Name != "ubuntu-22.04.qcow2" || image.URI != "vergeio://cluster.example.com/media_sources/12" {
+		t.Errorf("unexpected image dependency: %+v", image)
+	}
+
+	metadata := statement.Predicate.RunDetails.Metadata
+	if metadata.StartedOn != "2026-10-18T12:00:00Z" || metadata.FinishedOn != "2026-10-18T12:10:00Z" {
+		t.Errorf("unexpected run times: %+v", metadata)
+	}
+}
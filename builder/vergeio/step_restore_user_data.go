@@ -0,0 +1,84 @@
+// This step takes the temporary SSH key back out of the cloud-init user-data once the VM is shut down
+package vergeio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// StepRestoreUserData puts the stored user-data of the powered-off VM back to what the config
+// asked for. StepVMCreate added the temporary SSH key to it, and a passwordless sudo user when
+// the SSH user wasn't listed. StepCleanupTempKeys only edits authorized_keys in the guest, so
+// without this every template would hand the key to the clones it boots. It runs whether or
+// not finalize_template is set.
+type StepRestoreUserData struct {
+	VmConfig VmConfig
+	Comm     *communicator.Config
+}
+
+func (s *StepRestoreUserData) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("restoring the cloud-init user-data failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	httpIP, _ := state.Get("http_ip").(string)
+	httpPort, _ := state.Get("http_port").(int)
+	original, hasOriginal := userDataFile(buildCloudInitFiles(s.VmConfig.CloudInitFiles, string(s.Comm.SSHPublicKey), httpIP, httpPort))
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	files, err := vmAPI.ListCloudInitFiles(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+
+	removed := false
+	for _, file := range files {
+		if file.Name != "user-data" {
+			continue
+		}
+		if err := vmAPI.DeleteCloudInitFile(ctx, file.Key); err != nil {
+			return halt(err)
+		}
+		removed = true
+	}
+	if !removed {
+		return multistep.ActionContinue
+	}
+
+	if !hasOriginal {
+		ui.Say("Removing the cloud-init user-data that only carried the temporary SSH key")
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Restoring the cloud-init user-data without the temporary SSH key")
+	if err := vmAPI.CreateCloudInitFile(ctx, vmKey, original); err != nil {
+		return halt(err)
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepRestoreUserData) Cleanup(state multistep.StateBag) {}
+
+// userDataFile returns the user-data among files, if there is one
+func userDataFile(files []client.CloudInitFileAPI) (client.CloudInitFileAPI, bool) {
+	for _, file := range files {
+		if file.Name == "user-data" {
+			return file, true
+		}
+	}
+	return client.CloudInitFileAPI{}, false
+}
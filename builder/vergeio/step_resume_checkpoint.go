@@ -0,0 +1,196 @@
+// This step picks a failed build up again from the last checkpoint snapshot it took
+// The vergeio-checkpoint provisioner takes the snapshots between provisioners
+package vergeio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// checkpointSnapshotPrefix starts the name of every checkpoint snapshot, the config hash and
+// the checkpoint name follow
+const checkpointSnapshotPrefix = "packer-checkpoint-"
+
+// resumePowerOffTimeout bounds the wait for a VM left running by the failed build to power off
+const resumePowerOffTimeout = 5 * time.Minute
+
+// configHash identifies the VM configuration and the files of ContentHashFiles, so checkpoints
+// of a changed config or changed provisioner scripts are not resumed
+func (c *Config) configHash() (string, error) {
+	return hashJSON(struct {
+		VM    VmConfig
+		Files map[string]string
+	}{
+		VM:    c.VmConfig,
+		Files: c.contentFiles,
+	})
+}
+
+// hashJSON is the first 16 hex digits of the SHA-256 of v's JSON encoding
+func hashJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the configuration: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// checkpointPrefix is the snapshot name prefix of this config's checkpoints. It reaches the
+// vergeio-checkpoint provisioner as the CheckpointPrefix build variable.
+func (c *Config) checkpointPrefix() (string, error) {
+	hash, err := c.configHash()
+	if err != nil {
+		return "", err
+	}
+	return checkpointSnapshotPrefix + hash + "-", nil
+}
+
+// StepResumeCheckpoint looks for the VM of an earlier, failed build with the same name and
+// restores its latest checkpoint of the same config. Later steps then reuse that VM instead
+// of creating one. Without a VM of that name the build starts from scratch.
+type StepResumeCheckpoint struct {
+	VmConfig VmConfig
+	Prefix   string
+}
+
+func (s *StepResumeCheckpoint) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("resuming from a checkpoint failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	ui.Say(fmt.Sprintf("Looking for checkpoints of VM '%s'...", s.VmConfig.Name))
+
+	vms, err := vmAPI.GetVMs(ctx, s.VmConfig.Name, 0, false)
+	if err != nil {
+		return halt(err)
+	}
+	var existing []client.VMInfo
+	for _, vm := range vms {
+		if !vm.IsSnapshot {
+			existing = append(existing, vm)
+		}
+	}
+	switch len(existing) {
+	case 0:
+		ui.Message("No VM from an earlier build found - starting from scratch")
+		return multistep.ActionContinue
+	case 1:
+	default:
+		return halt(fmt.Errorf("VM name '%s' is ambiguous: %d VMs match", s.VmConfig.Name, len(existing)))
+	}
+
+	vmKey := strconv.Itoa(int(existing[0].Key))
+	machineID := int(existing[0].ID)
+
+	snapshots, err := vmAPI.GetVMSnapshots(ctx, machineID, "")
+	if err != nil {
+		return halt(err)
+	}
+	// Snapshots come oldest first, so the last one is where the build got to
+	var latest *client.VMSnapshotInfo
+	var completed []string
+	for i, snapshot := range snapshots {
+		if name, ok := strings.CutPrefix(snapshot.Name, s.Prefix); ok {
+			completed = append(completed, name)
+			latest = &snapshots[i]
+		}
+	}
+	if latest == nil {
+		return halt(fmt.Errorf("VM '%s' exists but has no checkpoints of this configuration, delete it or set resume_from_checkpoint = false", s.VmConfig.Name))
+	}
+
+	running, err := vmAPI.IsVMRunning(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+	if running != nil && *running {
+		ui.Message("Powering off the VM left running by the earlier build")
+		// PowerOffVM waits for the power state itself, bounded by waitCtx
+		waitCtx, cancel := context.WithTimeout(ctx, resumePowerOffTimeout)
+		err := vmAPI.PowerOffVM(waitCtx, vmKey)
+		cancel()
+		if err != nil {
+			return halt(err)
+		}
+	}
+
+	ui.Message(fmt.Sprintf("Restoring checkpoint '%s'", strings.TrimPrefix(latest.Name, s.Prefix)))
+	if err := vmAPI.RestoreSnapshot(ctx, vmKey, strconv.Itoa(latest.Key)); err != nil {
+		return halt(err)
+	}
+
+	diskKeys, nicKeys, err := deviceKeys(ctx, c, machineID, s.VmConfig)
+	if err != nil {
+		return halt(err)
+	}
+
+	state.Put("vm_id", vmKey)
+	state.Put("instance_id", vmKey)
+	state.Put("machine_id", machineID)
+	state.Put("disk_keys", diskKeys)
+	state.Put("nic_keys", nicKeys)
+	state.Put("vm_resumed", true)
+	state.Put("restored_checkpoint", strings.TrimPrefix(latest.Name, s.Prefix))
+
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	generatedData["CompletedCheckpoints"] = strings.Join(completed, ",")
+
+	ui.Say(fmt.Sprintf("Resumed VM '%s' after checkpoint(s): %s", s.VmConfig.Name, strings.Join(completed, ", ")))
+	return multistep.ActionContinue
+}
+
+func (s *StepResumeCheckpoint) Cleanup(state multistep.StateBag) {}
+
+// deviceKeys finds the keys of the VM's drives and NICs by the orderid StepVMCreate gave them,
+// names need not be unique or set. Later steps find the devices by config position, as
+// StepVMCreate leaves them, a device that is missing gets an empty key.
+func deviceKeys(ctx context.Context, c *client.Client, machineID int, vm VmConfig) ([]string, []string, error) {
+	disks, err := client.NewDriveApi(c).ListVMDisks(ctx, machineID)
+	if err != nil {
+		return nil, nil, err
+	}
+	diskKeys := make([]string, len(vm.VmDiskConfigs))
+	for i, order := range diskOrderIds(vm.VmDiskConfigs) {
+		for _, disk := range disks {
+			if disk.OrderId == order {
+				diskKeys[i] = strconv.Itoa(disk.Key)
+				break
+			}
+		}
+	}
+
+	nics, err := client.NewNicApi(c).ListVMNics(ctx, machineID)
+	if err != nil {
+		return nil, nil, err
+	}
+	nicKeys := make([]string, len(vm.VmNicConfigs))
+	for i := range vm.VmNicConfigs {
+		for _, nic := range nics {
+			if nic.OrderId == i+1 {
+				nicKeys[i] = strconv.Itoa(nic.Key)
+				break
+			}
+		}
+	}
+	return diskKeys, nicKeys, nil
+}
@@ -20,36 +20,35 @@ import (
 type StepShutdown struct {
 	// Command is the shutdown command to run inside the VM
 	// Examples: "sudo shutdown -P now" (Linux), "shutdown /s /t 0" (Windows)
+	// When empty, the VM is shut down with an ACPI power-button event instead
 	Command string
 
-	// Timeout is how long to wait for the shutdown command to complete
+	// Timeout is how long to wait for the VM to power off gracefully
 	// If this timeout is exceeded, the VM will be forcefully powered off
 	Timeout time.Duration
+
+	// PollInterval is how often the VM power state is checked while waiting for it to power off
+	PollInterval time.Duration
 }
 
+// forcedShutdownTimeout bounds how long we wait for a killed VM to report powered off
+const forcedShutdownTimeout = 2 * time.Minute
+
 // Run executes the shutdown process
 // This method implements the multistep.Step interface required by Packer
 func (s *StepShutdown) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
 
-	// Check if we have a shutdown command configured
-	if s.Command == "" {
-		ui.Say("No shutdown command configured - leaving VM powered on")
-		ui.Message("Note: You may want to configure 'shutdown_command' for clean VM shutdown")
+	// The generalize stage powers the guest off itself
+	if generalized, _ := state.Get("vm_generalized").(bool); generalized {
+		ui.Say("VM was powered off by the generalize stage - skipping shutdown")
+		state.Put("vm_shutdown_completed", true)
 		return multistep.ActionContinue
 	}
 
 	ui.Say("Gracefully shutting down VM...")
 
-	// Get the communicator from state (set by Packer's communicator steps)
-	comm := state.Get("communicator").(packersdk.Communicator)
-	if comm == nil {
-		ui.Error("No communicator available - cannot send shutdown command")
-		ui.Error("VM will remain powered on")
-		return multistep.ActionContinue // Don't fail the build for this
-	}
-
-	// Get cluster configuration and VM ID for potential forced shutdown
+	// Get cluster configuration and VM ID for the hypervisor-side shutdown paths
 	cc := state.Get("cluster_config").(ClusterConfig)
 	vmId, vmIdExists := state.GetOk("vm_id")
 	vmIdStr := ""
@@ -64,6 +63,23 @@ func (s *StepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 		ui.Message(fmt.Sprintf("Using default shutdown timeout: %v", timeout))
 	}
 
+	// Every graceful attempt shares the same deadline, the forced power-off only happens after it
+	deadline := time.Now().Add(timeout)
+
+	// Without a shutdown command, ask the hypervisor to press the ACPI power button
+	if s.Command == "" {
+		ui.Message("No shutdown command configured - using ACPI shutdown via VergeIO")
+		return s.performACPIShutdown(ctx, state, vmIdStr, cc, ui, deadline)
+	}
+
+	// Get the communicator from state (set by Packer's communicator steps)
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok || comm == nil {
+		ui.Error("No communicator available - cannot send shutdown command")
+		ui.Error("Falling back to ACPI shutdown...")
+		return s.performACPIShutdown(ctx, state, vmIdStr, cc, ui, deadline)
+	}
+
 	ui.Say(fmt.Sprintf("Executing shutdown command: %s", s.Command))
 
 	// Phase 1: Send the shutdown command via the communicator
@@ -78,8 +94,8 @@ func (s *StepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 	err := comm.Start(ctx, cmd)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Failed to start shutdown command: %v", err))
-		ui.Error("Attempting forced shutdown...")
-		return s.performForcedShutdown(state, vmIdStr, cc, ui)
+		ui.Error("Falling back to ACPI shutdown...")
+		return s.performACPIShutdown(ctx, state, vmIdStr, cc, ui, deadline)
 	}
 
 	ui.Say("Shutdown command sent successfully")
@@ -88,7 +104,7 @@ func (s *StepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 	ui.Say("Phase 2: Waiting for VM to shut down...")
 
 	// Create timeout context for shutdown waiting
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	timeoutCtx, cancel := context.WithDeadline(ctx, deadline)
 	defer cancel()
 
 	// Monitor the command execution and wait for completion
@@ -101,59 +117,46 @@ func (s *StepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 	// Wait for either command completion or timeout
 	select {
 	case <-timeoutCtx.Done():
+		if ctx.Err() != nil {
+			ui.Error("Build cancelled during shutdown")
+			return multistep.ActionHalt
+		}
 		ui.Error(fmt.Sprintf("Shutdown command timed out after %v", timeout))
 		ui.Error("The VM may still be shutting down, or the command failed")
-		return s.performForcedShutdown(state, vmIdStr, cc, ui)
+		return s.performForcedShutdown(ctx, state, vmIdStr, cc, ui)
 
 	case <-cmdComplete:
 		if cmd.ExitStatus() == 0 {
 			ui.Say("Shutdown command completed successfully")
 		} else {
 			ui.Error(fmt.Sprintf("Shutdown command failed with exit code: %d", cmd.ExitStatus()))
-			ui.Error("Attempting forced shutdown...")
-			return s.performForcedShutdown(state, vmIdStr, cc, ui)
+			ui.Error("Falling back to ACPI shutdown...")
+			return s.performACPIShutdown(ctx, state, vmIdStr, cc, ui, deadline)
 		}
 	}
 
-	// Phase 3: Wait additional time for the VM to actually power off
+	// Phase 3: Poll the power state until the VM is actually powered off
 	ui.Say("Phase 3: Waiting for VM to power off...")
 
-	// Give the VM additional time to complete the shutdown process
-	shutdownWait := 30 * time.Second
-	ui.Message(fmt.Sprintf("Waiting %v for VM to complete shutdown process...", shutdownWait))
-
-	// Create shutdown timer
-	shutdownTimer := time.NewTimer(shutdownWait)
-	defer shutdownTimer.Stop()
-
-	select {
-	case <-ctx.Done():
-		ui.Error("Build cancelled during shutdown wait")
-		return multistep.ActionHalt
-
-	case <-shutdownTimer.C:
-		ui.Say("Shutdown wait period completed")
-	}
-
-	// Phase 4: Verify VM is actually powered off
-	ui.Say("Phase 4: Verifying VM power state...")
-
 	if vmIdStr != "" {
 		c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
 		vmAPI := client.NewVMApi(c)
+		vmAPI.PollInterval = s.PollInterval
+
+		ui.Message(fmt.Sprintf("Polling VM power state until it powers off (timeout: %v)...", timeout))
 
-		// Check VM power state
-		isRunning, err := vmAPI.IsVMRunning(ctx, vmIdStr)
+		err := vmAPI.WaitForPowerState(timeoutCtx, vmIdStr, false)
 		if err != nil {
-			ui.Error(fmt.Sprintf("Failed to verify VM power state: %v", err))
-			ui.Message("Assuming shutdown was successful despite verification failure")
-		} else if isRunning != nil && *isRunning {
-			ui.Error("VM is still running after shutdown attempt")
-			ui.Error("The graceful shutdown may have failed - VM remains powered on")
-			// Don't fail the build, just warn the user
-		} else {
-			ui.Say("VM power state verified: VM is powered off")
+			if ctx.Err() != nil {
+				ui.Error("Build cancelled during shutdown wait")
+				return multistep.ActionHalt
+			}
+			ui.Error("VM is still running after shutdown command")
+			ui.Error("Falling back to ACPI shutdown...")
+			return s.performACPIShutdown(ctx, state, vmIdStr, cc, ui, deadline)
 		}
+
+		ui.Say("VM power state verified: VM is powered off")
 	} else {
 		ui.Message("VM ID not available - skipping power state verification")
 	}
@@ -179,10 +182,50 @@ func (s *StepShutdown) Cleanup(state multistep.StateBag) {
 	ui.Message("StepShutdown cleanup completed")
 }
 
-// performForcedShutdown handles forced shutdown when graceful shutdown fails
-func (s *StepShutdown) performForcedShutdown(state multistep.StateBag, vmIdStr string, cc ClusterConfig, ui packersdk.Ui) multistep.StepAction {
-	// Phase 4: Forced shutdown if graceful shutdown failed
-	ui.Say("Phase 4: Performing forced shutdown...")
+// performACPIShutdown asks VergeIO to send an ACPI power-button event to the guest
+// and polls the power state until the VM is off or the shutdown deadline passes
+func (s *StepShutdown) performACPIShutdown(ctx context.Context, state multistep.StateBag, vmIdStr string, cc ClusterConfig, ui packersdk.Ui, deadline time.Time) multistep.StepAction {
+	ui.Say("Requesting ACPI shutdown from VergeIO...")
+
+	if vmIdStr == "" {
+		ui.Error("Cannot perform ACPI shutdown - VM ID not available")
+		ui.Error("VM may still be running - manual intervention may be required")
+		return multistep.ActionContinue // Don't fail the build
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+	vmAPI.PollInterval = s.PollInterval
+
+	remaining := time.Until(deadline).Round(time.Second)
+	if remaining <= 0 {
+		ui.Error("Shutdown timeout already exceeded - skipping ACPI shutdown")
+		return s.performForcedShutdown(ctx, state, vmIdStr, cc, ui)
+	}
+	ui.Message(fmt.Sprintf("Waiting up to %v for the guest to power off...", remaining))
+
+	acpiCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	if err := vmAPI.ShutdownVM(acpiCtx, vmIdStr); err != nil {
+		if ctx.Err() != nil {
+			ui.Error("Build cancelled during shutdown")
+			return multistep.ActionHalt
+		}
+		ui.Error(fmt.Sprintf("ACPI shutdown did not complete: %v", err))
+		return s.performForcedShutdown(ctx, state, vmIdStr, cc, ui)
+	}
+
+	ui.Say("VM powered off via ACPI shutdown")
+	state.Put("vm_shutdown_completed", true)
+	return multistep.ActionContinue
+}
+
+// performForcedShutdown pulls the plug on the VM once every graceful path has failed
+func (s *StepShutdown) performForcedShutdown(ctx context.Context, state multistep.StateBag, vmIdStr string, cc ClusterConfig, ui packersdk.Ui) multistep.StepAction {
+	// Last resort: forced shutdown if graceful shutdown failed
+	ui.Say("Performing forced shutdown...")
+	ui.Error("The VM is being powered off without a clean guest shutdown - unflushed writes may be lost")
 
 	if vmIdStr == "" {
 		ui.Error("Cannot perform forced shutdown - VM ID not available")
@@ -196,8 +239,11 @@ func (s *StepShutdown) performForcedShutdown(state multistep.StateBag, vmIdStr s
 
 	ui.Message(fmt.Sprintf("Performing forced power-off for VM ID: %s", vmIdStr))
 
+	killCtx, cancel := context.WithTimeout(ctx, forcedShutdownTimeout)
+	defer cancel()
+
 	// Perform forced power-off via VergeIO API
-	err := vmAPI.PowerOffVM(vmIdStr)
+	err := vmAPI.PowerOffVM(killCtx, vmIdStr)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Failed to perform forced power-off: %v", err))
 		ui.Error("VM may still be running - manual intervention may be required")
@@ -38,8 +38,8 @@ func (s *StepSimpleShutdown) Run(ctx context.Context, state multistep.StateBag)
 	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
 	vmAPI := client.NewVMApi(c)
 
-	// Call PowerOffVM to shut down the VM
-	err := vmAPI.PowerOffVM(vmKeyStr)
+	// Call PowerOffVM to shut down the VM, it waits until the VM reports powered off
+	err := vmAPI.PowerOffVM(ctx, vmKeyStr)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Failed to power off VM: %v", err))
 		ui.Error("VM may still be running - manual intervention may be required")
@@ -5,22 +5,41 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/uuid"
 	client "github.com/verge-io/packer-plugin-vergeio/client"
 )
 
+// deviceCreateConcurrency bounds how many drive and NIC create requests are in flight at once
+const deviceCreateConcurrency = 4
+
 // This is a definition of a builder step and should implement multistep.Step
 type StepVMCreate struct {
 	ClusterConfig ClusterConfig
 	VmConfig      VmConfig
+
+	// Comm carries the temporary SSH public key, if one was generated, to authorize through cloud-init
+	Comm *communicator.Config
+
+	// Discard lets the guest hand freed blocks back to storage, StepCompact relies on it
+	Discard bool
 }
 
 func (s *StepVMCreate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
 	ui.Say("Running StepVMCreate")
 
+	// StepResumeCheckpoint or StepWarmPoolClone already supplied the VM, there is nothing to create
+	if resumed, _ := state.Get("vm_resumed").(bool); resumed {
+		ui.Say("Reusing the VM supplied by an earlier step")
+		return multistep.ActionContinue
+	}
+
 	cc := state.Get("cluster_config").(ClusterConfig)
 	vm := state.Get("vm_config").(VmConfig)
 
@@ -69,118 +88,251 @@ func (s *StepVMCreate) Run(ctx context.Context, state multistep.StateBag) multis
 		VmDisks:              []interface{}{},
 	}
 
-	// Add the cloud init files
-	if vm.CloudInitFiles != nil {
-		for _, cloudInitFile := range vm.CloudInitFiles {
-			apiData.CloudInitFiles = append(apiData.CloudInitFiles, client.CloudInitFileAPI{
-				Name:     cloudInitFile.Name,
-				Contents: cloudInitFile.Contents,
-			})
+	// Add the cloud init files, with the build values that cloud-init templates used filled in
+	var sshPublicKey string
+	if s.Comm != nil {
+		sshPublicKey = string(s.Comm.SSHPublicKey)
+	}
+	httpIP, _ := state.Get("http_ip").(string)
+	httpPort, _ := state.Get("http_port").(int)
+	apiData.CloudInitFiles = buildCloudInitFiles(vm.CloudInitFiles, sshPublicKey, httpIP, httpPort)
+
+	// Authorize the throw-away SSH key generated for this build
+	if s.Comm != nil && len(s.Comm.SSHPublicKey) > 0 {
+		if err := authorizeTemporarySSHKey(&apiData, s.Comm.SSHUsername, string(s.Comm.SSHPublicKey)); err != nil {
+			err = fmt.Errorf("error adding the temporary SSH key to cloud-init user-data: %w", err)
+			ui.Error(err.Error())
+			state.Put("error", err)
+			return multistep.ActionHalt
+		}
+		ui.Message(fmt.Sprintf("Added the temporary SSH public key to cloud-init user-data for user '%s'", s.Comm.SSHUsername))
+	}
+
+	// Every build boots as a new cloud-init instance, so first-boot modules run on it and,
+	// later, on every clone of the template
+	var suppliedInstanceID string
+	if apiData.CloudInitDataSource != "" && apiData.CloudInitDataSource != "none" {
+		generatedID := fmt.Sprintf("iid-%s", uuid.TimeOrderedUUID())
+		instanceID, err := ensureMetaData(&apiData, vm.Name, generatedID)
+		if err != nil {
+			err = fmt.Errorf("error preparing cloud-init meta-data: %w", err)
+			ui.Error(err.Error())
+			state.Put("error", err)
+			return multistep.ActionHalt
+		}
+		if instanceID != generatedID {
+			suppliedInstanceID = instanceID
 		}
+		ui.Message(fmt.Sprintf("Cloud-init instance-id: %s", instanceID))
+		state.Put("cloud_init_instance_id", instanceID)
 	}
 
+	// The local history only knows the builds of this machine, the cluster knows every VM
+	// that booted with the supplied instance-id, including templates and their clones
+	if suppliedInstanceID != "" {
+		files, err := vmAPI.FindCloudInitFiles(ctx, "meta-data")
+		if err != nil {
+			log.Printf("[VergeIO]: Could not check cloud-init instance-id %s against the cluster: %s", suppliedInstanceID, err)
+		} else if owners := instanceIDOwners(files, suppliedInstanceID); len(owners) > 0 {
+			ui.Message(fmt.Sprintf("Warning: cloud-init instance-id %q is already used by %s, cloud-init skips its first-boot modules "+
+				"on clones of the template, remove it from meta-data to have a unique one generated", suppliedInstanceID, strings.Join(owners, ", ")))
+		}
+	}
+
+	// Everything created from here on is recorded in the transaction, and Cleanup rolls
+	// all of it back if the step fails at any point
+	tx := &vmCreateTransaction{}
+	state.Put("vm_create_transaction", tx)
+	fail := func(err error) multistep.StepAction {
+		ui.Error(err.Error())
+		state.Put("error", err)
+		state.Put("vm_creation_failed", true)
+		return multistep.ActionHalt
+	}
+
+	// Keep what was requested, CreateVM overwrites apiData with what it reads back
+	requested := apiData
+
 	// post the data to the API
-	err := vmAPI.CreateVM(ctx, &apiData) // vmAPI.Post(client.VMActionEndpoint, bytes.NewBuffer(bytesPayload))
+	err := vmAPI.CreateVM(ctx, &apiData)
+
+	// CreateVM sets the key as soon as the POST succeeds, even if reading the VM back fails,
+	// so record it before looking at the error
+	if apiData.Id != "" {
+		tx.recordVM(apiData.Id)
+		state.Put("vm_id", apiData.Id)       // Store VM ID for cleanup purposes
+		state.Put("instance_id", apiData.Id) // Provisioners see it as the ID build variable
+	}
 	if err != nil {
-		ui.Error(fmt.Sprintf("Error making POST request to %s: %s", client.VMEndpoint, err))
-		state.Put("error", fmt.Errorf("error making POST request to %s: %w", client.VMActionEndpoint, err))
-		return multistep.ActionHalt
+		return fail(fmt.Errorf("error creating VM via %s: %w", client.VMEndpoint, err))
 	}
 
 	// Get the machine ID from the created VM (populated by CreateVM -> readVM)
 	machineID := apiData.Machine
 	if machineID == 0 {
-		ui.Error("Failed to retrieve machine ID from created VM")
-		state.Put("error", fmt.Errorf("failed to retrieve machine ID from created VM"))
-		return multistep.ActionHalt
+		return fail(fmt.Errorf("failed to retrieve machine ID from created VM"))
 	}
 	ui.Say(fmt.Sprintf("VM created successfully with Machine ID: %d", machineID))
 
-	// Store the machine ID and VM ID in state for other steps to use
+	// Store the machine ID in state for other steps to use
 	state.Put("machine_id", machineID)
-	state.Put("vm_id", apiData.Id) // Store VM ID for cleanup purposes
-
-	// Create disks if any are defined
-	var importDiskKeys []string                        // Track disks that need import completion waiting
-	var importDiskConfigs []client.VMDiskResourceModel // Track disk configurations for size checking
-	if vm.VmDiskConfigs != nil {
-		for _, disk := range vm.VmDiskConfigs {
-			diskData := client.VMDiskResourceModel{
-				Machine:             machineID, // Use the actual machine ID from the created VM
-				Name:                disk.Name,
-				Description:         disk.Description,
-				Interface:           disk.Interface,
-				Media:               disk.Media,
-				MediaSource:         disk.MediaSource,
-				PreferredTier:       disk.PreferredTier,
-				DiskSize:            disk.DiskSize,
-				Enabled:             disk.Enabled,
-				ReadOnly:            disk.ReadOnly,
-				Serial:              disk.Serial,
-				Asset:               disk.Asset,
-				OrderId:             disk.OrderId,
-				PreserveDriveFormat: disk.PreserveDriveFormat,
+
+	// Build the drive and NIC requests up front so they can be sent concurrently.
+	// Devices are created in parallel, so the order the VM sees them in is pinned with
+	// orderid, counted from 1 since a zero orderid is not sent, unless the config already sets one.
+	disks := make([]client.VMDiskResourceModel, len(vm.VmDiskConfigs))
+	diskOrder := diskOrderIds(vm.VmDiskConfigs)
+	for i, disk := range vm.VmDiskConfigs {
+		disks[i] = client.VMDiskResourceModel{
+			Machine:             machineID, // Use the actual machine ID from the created VM
+			Name:                disk.Name,
+			Description:         disk.Description,
+			Interface:           disk.Interface,
+			Media:               disk.Media,
+			MediaSource:         disk.MediaSource,
+			PreferredTier:       disk.PreferredTier,
+			DiskSize:            disk.sizeBytes,
+			Enabled:             disk.Enabled,
+			ReadOnly:            disk.ReadOnly,
+			Serial:              disk.Serial,
+			Asset:               disk.Asset,
+			OrderId:             diskOrder[i],
+			PreserveDriveFormat: disk.PreserveDriveFormat,
+		}
+		if s.Discard && disk.Media != "cdrom" && disk.Media != "efidisk" {
+			disks[i].Discard = true
+		}
+	}
+
+	nics := make([]client.VMNicResourceModel, len(vm.VmNicConfigs))
+	for i, nic := range vm.VmNicConfigs {
+		nics[i] = client.VMNicResourceModel{
+			Machine:         machineID, // Use the actual machine ID from the created VM
+			Name:            nic.Name,
+			Description:     nic.Description,
+			Interface:       nic.Interface,
+			Driver:          nic.Driver,
+			Model:           nic.Model,
+			VNET:            nic.VNET,
+			MAC:             nic.MAC,
+			IPAddress:       nic.IPAddress,
+			AssignIPAddress: nic.AssignIPAddress,
+			Enabled:         nic.Enabled,
+			OrderId:         i + 1,
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Creating %d disk(s) and %d NIC(s) for VM '%s' (Machine ID: %d)", len(disks), len(nics), vm.Name, machineID))
+
+	// Keys are stored by position so later steps see the devices in config order,
+	// whatever order the requests complete in
+	diskKeys := make([]string, len(disks))
+	nicKeys := make([]string, len(nics))
+
+	var mu sync.Mutex
+	var errs *packersdk.MultiError
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, deviceCreateConcurrency)
+
+	createDevice := func(kind string, name string, create func() (string, error), record func(string), key *string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			created, err := create()
+			if err == nil {
+				record(created)
 			}
 
-			ui.Say(fmt.Sprintf("Creating disk '%s' for VM '%s' (Machine ID: %d)", disk.Name, vm.Name, machineID))
-			diskKey, err := driveAPI.CreateVMDiskWithKey(ctx, &diskData)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				ui.Error(fmt.Sprintf("Error creating disk '%s': %s", disk.Name, err))
-				ui.Error(fmt.Sprintf("Disk creation failed - VM '%s' will be cleaned up automatically", vm.Name))
-				state.Put("error", fmt.Errorf("error creating disk '%s': %w", disk.Name, err))
-				// Mark VM for cleanup - the Cleanup method will handle deletion
-				state.Put("vm_creation_failed", true)
-				return multistep.ActionHalt
+				ui.Error(fmt.Sprintf("Error creating %s '%s': %s", kind, name, err))
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("error creating %s '%s': %w", kind, name, err))
+				return
 			}
-			ui.Say(fmt.Sprintf("Successfully created disk '%s'", disk.Name))
+			*key = created
+			ui.Say(fmt.Sprintf("Successfully created %s '%s'", kind, name))
+		}()
+	}
 
-			// Track disks that need import completion waiting
-			if disk.Media == "import" {
-				log.Printf("[VergeIO]: Disk '%s' with media='import' will need import completion waiting (key: %s)", disk.Name, diskKey)
-				importDiskKeys = append(importDiskKeys, diskKey)
-				importDiskConfigs = append(importDiskConfigs, diskData)
-			}
+	for i := range disks {
+		createDevice("disk", disks[i].Name, func() (string, error) {
+			return driveAPI.CreateVMDiskWithKey(ctx, &disks[i])
+		}, tx.recordDisk, &diskKeys[i])
+	}
+	for i := range nics {
+		createDevice("NIC", nics[i].Name, func() (string, error) {
+			return nicAPI.CreateVMNicWithKey(ctx, &nics[i])
+		}, tx.recordNic, &nicKeys[i])
+	}
+	wg.Wait()
+
+	if errs != nil {
+		ui.Error(fmt.Sprintf("Device creation failed - VM '%s' will be rolled back", vm.Name))
+		return fail(errs)
+	}
+	ui.Say(fmt.Sprintf("Successfully created %d disk(s) and %d NIC(s) for VM '%s'", len(disks), len(nics), vm.Name))
+
+	// Read everything back so fields the cluster silently ignored fail the build now
+	// rather than surfacing as a broken template later
+	ui.Say("Verifying the created VM against the requested configuration...")
+	actualVM, err := vmAPI.GetVM(ctx, apiData.Id)
+	if err != nil {
+		return fail(fmt.Errorf("error reading back created VM: %w", err))
+	}
+	actualDisks, err := driveAPI.ListVMDisks(ctx, machineID)
+	if err != nil {
+		return fail(fmt.Errorf("error reading back created disks: %w", err))
+	}
+	actualNics, err := nicAPI.ListVMNics(ctx, machineID)
+	if err != nil {
+		return fail(fmt.Errorf("error reading back created NICs: %w", err))
+	}
+
+	diffs := diffCreatedVM(&requested, actualVM)
+	diffs = append(diffs, diffCreatedDisks(disks, diskKeys, actualDisks)...)
+	diffs = append(diffs, diffCreatedNics(nics, nicKeys, actualNics)...)
+	if len(diffs) > 0 {
+		return fail(fmt.Errorf("created VM does not match the requested configuration:\n  %s", strings.Join(diffs, "\n  ")))
+	}
+
+	// Keys in config order, StepFinalize removes the build_only devices by them
+	state.Put("disk_keys", diskKeys)
+	state.Put("nic_keys", nicKeys)
+
+	var importDiskKeys []string                                // Track disks that need import completion waiting
+	resizeDisks := make(map[string]client.VMDiskResourceModel) // Imported/cloned disks to grow to their requested size, by key
+	for i, disk := range disks {
+		// Track disks that need import completion waiting
+		if disk.Media == "import" {
+			log.Printf("[VergeIO]: Disk '%s' with media='import' will need import completion waiting (key: %s)", disk.Name, diskKeys[i])
+			importDiskKeys = append(importDiskKeys, diskKeys[i])
+		}
+
+		// Imported and cloned disks start at their source's size and may need to grow
+		if (disk.Media == "import" || disk.Media == "clone") && disk.DiskSize > 0 {
+			resizeDisks[diskKeys[i]] = disk
 		}
-		ui.Say(fmt.Sprintf("Successfully created %d disk(s) for VM '%s'", len(vm.VmDiskConfigs), vm.Name))
 	}
 
-	// Store import disk keys and configs in state for StepWaitForDiskImport to use
+	// Store import disk keys and the disks to resize in state for StepWaitForDiskImport to use
 	if len(importDiskKeys) > 0 {
-		log.Printf("[VergeIO]: Storing %d import disk keys and configs in state for import completion waiting and size checking", len(importDiskKeys))
+		log.Printf("[VergeIO]: Storing %d import disk keys in state for import completion waiting", len(importDiskKeys))
 		state.Put("import_disk_keys", importDiskKeys)
-		state.Put("import_disk_configs", importDiskConfigs)
-	}
-
-	// Create NICs if any are defined
-	if vm.VmNicConfigs != nil {
-		for _, nic := range vm.VmNicConfigs {
-			nicData := client.VMNicResourceModel{
-				Machine:         machineID, // Use the actual machine ID from the created VM
-				Name:            nic.Name,
-				Description:     nic.Description,
-				Interface:       nic.Interface,
-				Driver:          nic.Driver,
-				Model:           nic.Model,
-				VNET:            nic.VNET,
-				MAC:             nic.MAC,
-				IPAddress:       nic.IPAddress,
-				AssignIPAddress: nic.AssignIPAddress,
-				Enabled:         nic.Enabled,
-			}
+	}
+	if len(resizeDisks) > 0 {
+		log.Printf("[VergeIO]: Storing %d disk(s) in state for size checking", len(resizeDisks))
+		state.Put("resize_disks", resizeDisks)
+	}
 
-			ui.Say(fmt.Sprintf("Creating NIC '%s' for VM '%s' (Machine ID: %d)", nic.Name, vm.Name, machineID))
-			err := nicAPI.CreateVMNic(ctx, &nicData)
-			if err != nil {
-				ui.Error(fmt.Sprintf("Error creating NIC '%s': %s", nic.Name, err))
-				ui.Error(fmt.Sprintf("NIC creation failed - VM '%s' will be cleaned up automatically", vm.Name))
-				state.Put("error", fmt.Errorf("error creating NIC '%s': %w", nic.Name, err))
-				// Mark VM for cleanup - the Cleanup method will handle deletion
-				state.Put("vm_creation_failed", true)
-				return multistep.ActionHalt
-			}
-			ui.Say(fmt.Sprintf("Successfully created NIC '%s'", nic.Name))
+	// Supplied instance-ids are remembered, so Prepare can warn when a later build reuses one
+	if suppliedInstanceID != "" {
+		if err := recordInstanceID(suppliedInstanceID); err != nil {
+			log.Printf("[VergeIO]: Failed to record cloud-init instance-id %s: %s", suppliedInstanceID, err)
 		}
-		ui.Say(fmt.Sprintf("Successfully created %d NIC(s) for VM '%s'", len(vm.VmNicConfigs), vm.Name))
 	}
 
 	// VM and all components created successfully
@@ -191,35 +343,47 @@ func (s *StepVMCreate) Run(ctx context.Context, state multistep.StateBag) multis
 func (s *StepVMCreate) Cleanup(state multistep.StateBag) {
 	ui := state.Get("ui").(packersdk.Ui)
 
-	// Check if we need to clean up a VM
-	vmId, vmIdExists := state.GetOk("vm_id")
-	creationFailed, failureExists := state.GetOk("vm_creation_failed")
+	// Only roll back if something was created but the step failed
+	creationFailed, _ := state.Get("vm_creation_failed").(bool)
+	tx, _ := state.Get("vm_create_transaction").(*vmCreateTransaction)
+	if !creationFailed || tx == nil || tx.empty() {
+		ui.Say("No cleanup required for StepVMCreate")
+		return
+	}
 
-	// Only cleanup if VM was created but something went wrong
-	if vmIdExists && failureExists && creationFailed.(bool) {
-		ui.Say(fmt.Sprintf("Cleaning up failed VM creation - deleting VM ID: %s", vmId.(string)))
+	ui.Say("Cleaning up failed VM creation - rolling back the VM and its drives and NICs")
 
-		// Get cluster config to create API client
-		cc, ccExists := state.GetOk("cluster_config")
-		if !ccExists {
-			ui.Error("Cannot cleanup VM: cluster configuration not found in state")
-			return
-		}
+	// Get cluster config to create API client
+	cc, ccExists := state.GetOk("cluster_config")
+	if !ccExists {
+		ui.Error("Cannot cleanup VM: cluster configuration not found in state")
+		return
+	}
 
-		clusterConfig := cc.(ClusterConfig)
-		c := client.NewClient(clusterConfig.Endpoint, clusterConfig.Username, clusterConfig.Password, clusterConfig.Insecure)
-		vmAPI := client.NewVMApi(c)
+	clusterConfig := cc.(ClusterConfig)
+	c := client.NewClient(clusterConfig.Endpoint, clusterConfig.Username, clusterConfig.Password, clusterConfig.Insecure)
 
-		// Attempt to delete the VM (this will also delete all associated disks)
-		ctx := context.Background()
-		err := vmAPI.DeleteVM(ctx, vmId.(string))
-		if err != nil {
-			ui.Error(fmt.Sprintf("Failed to cleanup VM %s: %s", vmId.(string), err))
-			ui.Error("Manual cleanup may be required in VergeIO console")
-		} else {
-			ui.Say(fmt.Sprintf("Successfully cleaned up VM %s and all associated resources", vmId.(string)))
+	if err := tx.rollback(context.Background(), c); err != nil {
+		ui.Error(fmt.Sprintf("Failed to roll back VM creation: %s", err))
+		ui.Error("Manual cleanup may be required in VergeIO console")
+		return
+	}
+	ui.Say("Successfully rolled back the VM and all associated resources")
+}
+
+// diskOrderIds is the orderid of each drive: the configured ones if any drive sets one,
+// otherwise the config position counted from 1
+func diskOrderIds(disks []VmDiskConfig) []int {
+	configured := false
+	for _, disk := range disks {
+		configured = configured || disk.OrderId != 0
+	}
+	order := make([]int, len(disks))
+	for i, disk := range disks {
+		order[i] = i + 1
+		if configured {
+			order[i] = disk.OrderId
 		}
-	} else {
-		ui.Say("No cleanup required for StepVMCreate")
 	}
+	return order
 }
@@ -3,6 +3,8 @@ package vergeio
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
@@ -11,7 +13,7 @@ import (
 
 // StepWaitForDiskImport waits for any disks with media="import" to complete importing
 // before proceeding with VM power-on. This prevents the "Cannot power on a VM while
-// drives are importing" error.
+// drives are importing" error. Imported and cloned disks are then grown to their requested size.
 type StepWaitForDiskImport struct {
 	Config *Config
 }
@@ -19,62 +21,195 @@ type StepWaitForDiskImport struct {
 func (s *StepWaitForDiskImport) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packer.Ui)
 
+	// Create VergeIO client
+	vergeClient := client.NewClient(s.Config.Endpoint, s.Config.Username, s.Config.Password, s.Config.Insecure)
+	driveAPI := client.NewDriveApi(vergeClient)
+
 	// Check if there are any import disks to wait for
-	importDiskKeysInterface := state.Get("import_disk_keys")
-	if importDiskKeysInterface == nil {
+	importDiskKeys, _ := state.Get("import_disk_keys").([]string)
+	if len(importDiskKeys) == 0 {
 		ui.Say("No import disks to wait for - proceeding with power-on")
-		return multistep.ActionContinue
+	} else {
+		ui.Say(fmt.Sprintf("Waiting for %d disk(s) with media='import' to complete importing before power-on (timeout: %v)",
+			len(importDiskKeys), s.Config.DiskImportTimeout))
+
+		// All disks are polled concurrently and share a single timeout
+		reporter := newDiskImportReporter(ui)
+		err := driveAPI.WaitForDiskImportCompletion(ctx, importDiskKeys, s.Config.DiskImportTimeout, reporter.Report)
+		reporter.Close()
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for disk import completion: %s", err))
+			state.Put("error", fmt.Errorf("disk import failed: %w", err))
+			return multistep.ActionHalt
+		}
+
+		ui.Say("All disk imports completed successfully")
 	}
 
-	importDiskKeys, ok := importDiskKeysInterface.([]string)
-	if !ok || len(importDiskKeys) == 0 {
-		ui.Say("No import disks to wait for - proceeding with power-on")
-		return multistep.ActionContinue
+	// Imported and cloned disks take their source's size, grow them to the requested size
+	resizeDisks, _ := state.Get("resize_disks").(map[string]client.VMDiskResourceModel)
+	if len(resizeDisks) > 0 {
+		ui.Say(fmt.Sprintf("Checking and resizing %d imported/cloned disk(s) if needed", len(resizeDisks)))
+
+		warnings, err := driveAPI.GrowDisks(ctx, resizeDisks)
+		for _, warning := range warnings {
+			ui.Message(fmt.Sprintf("Warning: %s", warning))
+		}
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error checking/resizing disks: %s", err))
+			state.Put("error", fmt.Errorf("disk resize failed: %w", err))
+			return multistep.ActionHalt
+		}
+
+		ui.Say("All disk size checks and resizing completed successfully")
 	}
 
-	ui.Say(fmt.Sprintf("Waiting for %d disk(s) with media='import' to complete importing before power-on", len(importDiskKeys)))
+	ui.Say("Ready for VM power-on")
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForDiskImport) Cleanup(state multistep.StateBag) {
+	// No cleanup needed for this step
+	// VM cleanup is handled by StepVMCreate if needed
+}
 
-	// Create VergeIO client
-	vergeClient := client.NewClient(s.Config.Endpoint, s.Config.Username, s.Config.Password, s.Config.Insecure)
-	driveAPI := client.NewDriveApi(vergeClient)
+// diskImportReporter turns import status reads into UI output. Disks whose size is known
+// get a progress bar, the others a message whenever their status changes.
+type diskImportReporter struct {
+	ui packer.Ui
+
+	mu       sync.Mutex
+	disks    map[string]*diskImportState
+	finished sync.WaitGroup
+}
+
+type diskImportState struct {
+	status   string
+	reported int64
+	bar      *progressFeed
+}
+
+func newDiskImportReporter(ui packer.Ui) *diskImportReporter {
+	return &diskImportReporter{
+		ui:    ui,
+		disks: make(map[string]*diskImportState),
+	}
+}
 
-	// Wait for import completion with a reasonable retry limit
-	// 10 retries * 5 seconds = 50 seconds max wait time per disk
-	maxRetries := 20 // Increased to 100 seconds max wait time per disk
+// Report is called concurrently by the polling goroutines
+func (r *diskImportReporter) Report(progress client.DiskImportProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	err := driveAPI.WaitForDiskImportCompletion(ctx, importDiskKeys, maxRetries)
-	if err != nil {
-		ui.Error(fmt.Sprintf("Error waiting for disk import completion: %s", err))
-		state.Put("error", fmt.Errorf("disk import failed: %w", err))
-		return multistep.ActionHalt
+	disk, ok := r.disks[progress.Key]
+	if !ok {
+		disk = &diskImportState{}
+		r.disks[progress.Key] = disk
 	}
 
-	ui.Say("All disk imports completed successfully")
+	name := progress.Name
+	if name == "" {
+		name = fmt.Sprintf("disk %s", progress.Key)
+	}
 
-	// Get the disk configurations to check sizes
-	importDiskConfigsInterface := state.Get("import_disk_configs")
-	if importDiskConfigsInterface != nil {
-		importDiskConfigs, ok := importDiskConfigsInterface.([]client.VMDiskResourceModel)
-		if ok && len(importDiskConfigs) > 0 {
-			ui.Say(fmt.Sprintf("Checking and resizing %d imported disk(s) if needed", len(importDiskConfigs)))
+	if progress.Importing() && progress.Percent() >= 0 {
+		if disk.bar == nil {
+			disk.bar = newProgressFeed()
+			tracked := r.ui.TrackProgress(fmt.Sprintf("Importing %s", name), 0, progress.TotalBytes, disk.bar)
+			r.finished.Add(1)
+			go func() {
+				defer r.finished.Done()
+				_, _ = io.Copy(io.Discard, tracked)
+				tracked.Close()
+			}()
+		}
+		if delta := progress.ImportedBytes - disk.reported; delta > 0 {
+			disk.bar.Add(delta)
+			disk.reported = progress.ImportedBytes
+		}
+	}
 
-			// Check and resize imported disks if their size doesn't match the requested size
-			err = driveAPI.CheckAndResizeImportedDisks(ctx, importDiskConfigs, importDiskKeys)
-			if err != nil {
-				ui.Error(fmt.Sprintf("Error checking/resizing imported disks: %s", err))
-				state.Put("error", fmt.Errorf("disk resize after import failed: %w", err))
-				return multistep.ActionHalt
-			}
+	if progress.Status == disk.status {
+		return
+	}
+	disk.status = progress.Status
+
+	switch {
+	case progress.Failed():
+		r.ui.Error(fmt.Sprintf("Disk '%s' import failed: %s %s", name, progress.Status, progress.StatusInfo))
+	case progress.Importing():
+		if progress.Percent() >= 0 {
+			r.ui.Message(fmt.Sprintf("Disk '%s' importing: %d%% (%d of %d bytes)", name, progress.Percent(), progress.ImportedBytes, progress.TotalBytes))
+		} else {
+			r.ui.Message(fmt.Sprintf("Disk '%s' importing...", name))
+		}
+	default:
+		if disk.bar != nil {
+			disk.bar.Close()
+		}
+		r.ui.Message(fmt.Sprintf("Disk '%s' import finished with status: %s", name, progress.Status))
+	}
+}
 
-			ui.Say("All imported disk size checks and resizing completed successfully")
+// Close stops every progress bar that is still running
+func (r *diskImportReporter) Close() {
+	r.mu.Lock()
+	for _, disk := range r.disks {
+		if disk.bar != nil {
+			disk.bar.Close()
 		}
 	}
+	r.mu.Unlock()
 
-	ui.Say("Ready for VM power-on")
-	return multistep.ActionContinue
+	r.finished.Wait()
 }
 
-func (s *StepWaitForDiskImport) Cleanup(state multistep.StateBag) {
-	// No cleanup needed for this step
-	// VM cleanup is handled by StepVMCreate if needed
+// progressFeed is an io.ReadCloser that yields as many bytes as have been added to it.
+// It drives Packer's progress bar for work that happens on the cluster rather than in a local stream.
+type progressFeed struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int64
+	closed  bool
+}
+
+func newProgressFeed() *progressFeed {
+	f := &progressFeed{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Add makes n more bytes available to the reader
+func (f *progressFeed) Add(n int64) {
+	f.mu.Lock()
+	f.pending += n
+	f.mu.Unlock()
+	f.cond.Signal()
+}
+
+func (f *progressFeed) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.pending == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.pending == 0 {
+		return 0, io.EOF
+	}
+
+	n := int64(len(p))
+	if n > f.pending {
+		n = f.pending
+	}
+	f.pending -= n
+	return int(n), nil
+}
+
+func (f *progressFeed) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.cond.Broadcast()
+	return nil
 }
@@ -0,0 +1,196 @@
+// These steps keep a warm pool of booted, not yet provisioned VMs to clone builds from
+// A pool entry is a powered-off clone of a build VM, taken once its communicator connected
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+// WarmPoolPrefix starts the name of every warm pool entry, the pool key follows
+const WarmPoolPrefix = "packer-warm-"
+
+// warmPoolKey identifies the source config and cloud-init of a build. The VM name and
+// description are left out, so builds that only differ in the template name share an entry.
+func (c *Config) warmPoolKey() (string, error) {
+	vm := c.VmConfig
+	vm.Name = ""
+	vm.Description = ""
+	return hashJSON(vm)
+}
+
+// warmPoolName is the name of the pool entry of this config
+func (c *Config) warmPoolName() (string, error) {
+	key, err := c.warmPoolKey()
+	if err != nil {
+		return "", err
+	}
+	return WarmPoolPrefix + key, nil
+}
+
+// StepWarmPoolClone evicts expired pool entries and clones the build VM from the entry of this
+// config, if there is one. StepVMCreate and the disk import then have nothing to do. Without
+// an entry the build creates the VM as usual and StepWarmPoolFill adds it to the pool.
+type StepWarmPoolClone struct {
+	VmConfig VmConfig
+	PoolName string
+	// MaxAge evicts entries of any config that are older, zero keeps them
+	MaxAge time.Duration
+	// Refresh evicts the entry of this config, so it is filled again
+	Refresh bool
+	// Evict lists entries to evict, by entry name or pool key
+	Evict []string
+}
+
+func (s *StepWarmPoolClone) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+
+	halt := func(err error) multistep.StepAction {
+		err = fmt.Errorf("cloning from the warm pool failed: %w", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	ui.Say(fmt.Sprintf("Looking for warm pool entry '%s'...", s.PoolName))
+
+	entries, err := vmAPI.FindVMsByPrefix(ctx, WarmPoolPrefix)
+	if err != nil {
+		return halt(err)
+	}
+
+	var entry *client.VMSummary
+	for i, e := range entries {
+		age := time.Since(time.Unix(e.Created, 0)).Round(time.Second)
+		expired := s.MaxAge > 0 && age > s.MaxAge
+		if expired || (s.Refresh && e.Name == s.PoolName) || s.evicted(e.Name) {
+			ui.Message(fmt.Sprintf("Evicting warm pool entry '%s' (age %v)", e.Name, age))
+			if err := vmAPI.DeleteVM(ctx, strconv.Itoa(e.Key)); err != nil {
+				return halt(err)
+			}
+			continue
+		}
+		if e.Name == s.PoolName {
+			entry = &entries[i]
+		}
+	}
+
+	if entry == nil {
+		ui.Message("No warm pool entry for this configuration, it is filled once the VM has booted")
+		state.Put("warm_pool_fill", true)
+		return multistep.ActionContinue
+	}
+
+	ui.Message(fmt.Sprintf("Cloning '%s' from warm pool entry '%s'", s.VmConfig.Name, entry.Name))
+	vmKey, err := vmAPI.CloneVM(ctx, strconv.Itoa(entry.Key), s.VmConfig.Name)
+	if err != nil {
+		return halt(err)
+	}
+	// The clone carries the entry's description, the template gets the configured one
+	if err := vmAPI.UpdateVM(ctx, vmKey, map[string]interface{}{"description": s.VmConfig.Description}); err != nil {
+		return halt(err)
+	}
+	vm, err := vmAPI.GetVM(ctx, vmKey)
+	if err != nil {
+		return halt(err)
+	}
+	diskKeys, nicKeys, err := deviceKeys(ctx, c, vm.Machine, s.VmConfig)
+	if err != nil {
+		return halt(err)
+	}
+
+	state.Put("vm_id", vmKey)
+	state.Put("instance_id", vmKey)
+	state.Put("machine_id", vm.Machine)
+	state.Put("disk_keys", diskKeys)
+	state.Put("nic_keys", nicKeys)
+	state.Put("vm_resumed", true)
+
+	ui.Say(fmt.Sprintf("VM '%s' cloned from the warm pool (key %s)", s.VmConfig.Name, vmKey))
+	return multistep.ActionContinue
+}
+
+// evicted reports whether the entry named name is listed in Evict
+func (s *StepWarmPoolClone) evicted(name string) bool {
+	for _, entry := range s.Evict {
+		if WarmPoolPrefix+strings.TrimPrefix(entry, WarmPoolPrefix) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StepWarmPoolClone) Cleanup(state multistep.StateBag) {}
+
+// warmPoolSettleTimeout bounds the wait for a Linux guest to finish its first boot
+const warmPoolSettleTimeout = 15 * time.Minute
+
+// warmPoolSettleCommand waits for cloud-init to finish its first boot, so the entry does not
+// capture it halfway, and flushes the guest's writes to its disks
+const warmPoolSettleCommand = `if command -v cloud-init >/dev/null 2>&1; then cloud-init status --wait >/dev/null; fi; status=$?; sync; exit $status`
+
+// StepWarmPoolFill clones the booted build VM into the pool entry of its config, before any
+// provisioner has run. On Linux it first waits for cloud-init and flushes the disks. The guest
+// is still copied while it runs, so clones of the entry boot like after a power cut. A failure
+// only costs the next build its head start, so it is reported without failing the build.
+type StepWarmPoolFill struct {
+	PoolName string
+	// Description says which build filled the entry
+	Description string
+	// OSFamily decides whether the guest is settled through the communicator first
+	OSFamily string
+}
+
+func (s *StepWarmPoolFill) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if fill, _ := state.Get("warm_pool_fill").(bool); !fill {
+		return multistep.ActionContinue
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	cc := state.Get("cluster_config").(ClusterConfig)
+	vmKey := state.Get("vm_id").(string)
+
+	c := client.NewClient(cc.Endpoint, cc.Username, cc.Password, cc.Insecure)
+	vmAPI := client.NewVMApi(c)
+
+	ui.Say(fmt.Sprintf("Filling warm pool entry '%s'...", s.PoolName))
+
+	if comm, ok := state.Get("communicator").(packersdk.Communicator); ok && comm != nil && s.OSFamily != "windows" {
+		settleCtx, cancel := context.WithTimeout(ctx, warmPoolSettleTimeout)
+		cmd := &packersdk.RemoteCmd{Command: warmPoolSettleCommand}
+		err := cmd.RunWithUi(settleCtx, comm, ui)
+		cancel()
+		if err == nil && cmd.ExitStatus() != 0 {
+			err = fmt.Errorf("cloud-init status exited with code %d", cmd.ExitStatus())
+		}
+		if err != nil {
+			ui.Error(fmt.Sprintf("The guest did not finish its first boot cleanly, the warm pool is not filled: %v", err))
+			return multistep.ActionContinue
+		}
+	}
+
+	entryKey, err := vmAPI.CloneVM(ctx, vmKey, s.PoolName)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to fill the warm pool, the next build starts from scratch: %v", err))
+		return multistep.ActionContinue
+	}
+	if err := vmAPI.UpdateVM(ctx, entryKey, map[string]interface{}{"description": s.Description}); err != nil {
+		ui.Error(fmt.Sprintf("Failed to describe warm pool entry %s: %v", entryKey, err))
+	}
+
+	ui.Message(fmt.Sprintf("Warm pool entry '%s' created (key %s)", s.PoolName, entryKey))
+	return multistep.ActionContinue
+}
+
+func (s *StepWarmPoolFill) Cleanup(state multistep.StateBag) {}
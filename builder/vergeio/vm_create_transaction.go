@@ -0,0 +1,204 @@
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+const (
+	// rollbackAttempts is how often each delete is tried before the resource is reported as leaked
+	rollbackAttempts = 3
+	// rollbackRetryDelay is the pause between delete attempts
+	rollbackRetryDelay = 5 * time.Second
+)
+
+// vmCreateTransaction records every resource StepVMCreate creates, as soon as its key is
+// known, so that a failure at any later point can undo all of it.
+// It is safe for concurrent use by the drive and NIC workers.
+type vmCreateTransaction struct {
+	mu       sync.Mutex
+	vmKey    string
+	diskKeys []string
+	nicKeys  []string
+}
+
+func (t *vmCreateTransaction) recordVM(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.vmKey = key
+}
+
+func (t *vmCreateTransaction) recordDisk(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.diskKeys = append(t.diskKeys, key)
+}
+
+func (t *vmCreateTransaction) recordNic(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nicKeys = append(t.nicKeys, key)
+}
+
+// empty reports whether nothing has been created yet
+func (t *vmCreateTransaction) empty() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.vmKey == "" && len(t.diskKeys) == 0 && len(t.nicKeys) == 0
+}
+
+// rollback deletes the recorded drives and NICs, then the VM. Every delete is retried and
+// every resource that could not be removed is returned in the error, so nothing leaks silently.
+func (t *vmCreateTransaction) rollback(ctx context.Context, c *client.Client) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	driveAPI := client.NewDriveApi(c)
+	nicAPI := client.NewNicApi(c)
+	vmAPI := client.NewVMApi(c)
+
+	var errs *packer.MultiError
+	for _, key := range t.diskKeys {
+		if err := retryDelete(ctx, func() error { return driveAPI.DeleteVMDisk(ctx, key) }); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("disk %s: %w", key, err))
+		}
+	}
+	for _, key := range t.nicKeys {
+		if err := retryDelete(ctx, func() error { return nicAPI.DeleteVMNic(ctx, key) }); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("NIC %s: %w", key, err))
+		}
+	}
+	if t.vmKey != "" {
+		if err := retryDelete(ctx, func() error { return vmAPI.DeleteVM(ctx, t.vmKey) }); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("VM %s: %w", t.vmKey, err))
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	t.vmKey, t.diskKeys, t.nicKeys = "", nil, nil
+	return nil
+}
+
+func retryDelete(ctx context.Context, del func() error) error {
+	var err error
+	for attempt := 1; attempt <= rollbackAttempts; attempt++ {
+		if err = del(); err == nil {
+			return nil
+		}
+		log.Printf("[VergeIO]: Rollback delete attempt %d/%d failed: %s", attempt, rollbackAttempts, err)
+		if attempt < rollbackAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rollbackRetryDelay):
+			}
+		}
+	}
+	return err
+}
+
+// diffCreatedVM compares the VM read back after creation with what was requested and
+// describes every field the cluster silently ignored or changed. Fields left at their zero
+// value were not requested and are not compared. machine_type is skipped because the
+// cluster may resolve aliases such as "q35" to a versioned type.
+func diffCreatedVM(requested, actual *client.VMAPIResourceModel) []string {
+	var diffs []string
+	compare := func(field string, want, got interface{}, requested bool) {
+		if requested && want != got {
+			diffs = append(diffs, fmt.Sprintf("%s: requested %v, got %v", field, want, got))
+		}
+	}
+
+	compare("name", requested.Name, actual.Name, requested.Name != "")
+	compare("cpu_cores", requested.CPUCores, actual.CPUCores, requested.CPUCores != 0)
+	compare("ram", requested.RAM, actual.RAM, requested.RAM != 0)
+	compare("os_family", requested.OSFamily, actual.OSFamily, requested.OSFamily != "")
+	compare("rtc_base", requested.RTCBase, actual.RTCBase, requested.RTCBase != "")
+	compare("uefi", requested.UEFI, actual.UEFI, requested.UEFI)
+	compare("secure_boot", requested.SecureBoot, actual.SecureBoot, requested.SecureBoot)
+	compare("guest_agent", requested.GuestAgent, actual.GuestAgent, requested.GuestAgent)
+	compare("nested_virtualization", requested.NestedVirtualization, actual.NestedVirtualization, requested.NestedVirtualization)
+	compare("preferred_node", requested.PreferredNode, actual.PreferredNode, requested.PreferredNode != "")
+	compare("snapshot_profile", requested.SnapshotProfile, actual.SnapshotProfile, requested.SnapshotProfile != "")
+	compare("cloud_init_data_source", requested.CloudInitDataSource, actual.CloudInitDataSource, requested.CloudInitDataSource != "")
+
+	return diffs
+}
+
+// diffCreatedDisks compares the drives read back after creation with the requested ones,
+// matched by key. Sizes are only compared for blank disks, imported and cloned drives take
+// their size from the source until they are grown. Their media isn't compared either, VergeIO
+// switches it to disk once the copy has finished.
+func diffCreatedDisks(requested []client.VMDiskResourceModel, keys []string, actual []client.VMDiskResourceModel) []string {
+	byKey := make(map[string]client.VMDiskResourceModel, len(actual))
+	for _, disk := range actual {
+		byKey[strconv.Itoa(disk.Key)] = disk
+	}
+
+	var diffs []string
+	for i, want := range requested {
+		path := fmt.Sprintf("vm_disks[%d]", i)
+		got, ok := byKey[keys[i]]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: drive %s is missing from the VM", path, keys[i]))
+			continue
+		}
+		if want.Name != "" && want.Name != got.Name {
+			diffs = append(diffs, fmt.Sprintf("%s.name: requested %s, got %s", path, want.Name, got.Name))
+		}
+		if want.Interface != "" && want.Interface != got.Interface {
+			diffs = append(diffs, fmt.Sprintf("%s.interface: requested %s, got %s", path, want.Interface, got.Interface))
+		}
+		copied := want.Media == "import" || want.Media == "clone"
+		if want.Media != "" && !copied && want.Media != got.Media {
+			diffs = append(diffs, fmt.Sprintf("%s.media: requested %s, got %s", path, want.Media, got.Media))
+		}
+		if want.PreferredTier != "" && want.PreferredTier != got.PreferredTier {
+			diffs = append(diffs, fmt.Sprintf("%s.preferred_tier: requested %s, got %s", path, want.PreferredTier, got.PreferredTier))
+		}
+		if want.Discard && !got.Discard {
+			diffs = append(diffs, fmt.Sprintf("%s.discard: requested true, got false", path))
+		}
+		if (want.Media == "" || want.Media == "disk") && want.DiskSize > 0 && want.DiskSize != got.DiskSize {
+			diffs = append(diffs, fmt.Sprintf("%s.disksize: requested %d bytes, got %d bytes", path, want.DiskSize, got.DiskSize))
+		}
+	}
+	return diffs
+}
+
+// diffCreatedNics compares the NICs read back after creation with the requested ones, matched by key
+func diffCreatedNics(requested []client.VMNicResourceModel, keys []string, actual []client.VMNicResourceModel) []string {
+	byKey := make(map[string]client.VMNicResourceModel, len(actual))
+	for _, nic := range actual {
+		byKey[strconv.Itoa(nic.Key)] = nic
+	}
+
+	var diffs []string
+	for i, want := range requested {
+		path := fmt.Sprintf("vm_nics[%d]", i)
+		got, ok := byKey[keys[i]]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: NIC %s is missing from the VM", path, keys[i]))
+			continue
+		}
+		if want.Name != "" && want.Name != got.Name {
+			diffs = append(diffs, fmt.Sprintf("%s.name: requested %s, got %s", path, want.Name, got.Name))
+		}
+		if want.Interface != "" && want.Interface != got.Interface {
+			diffs = append(diffs, fmt.Sprintf("%s.interface: requested %s, got %s", path, want.Interface, got.Interface))
+		}
+		if want.VNET != 0 && want.VNET != got.VNET {
+			diffs = append(diffs, fmt.Sprintf("%s.vnet: requested %d, got %d", path, want.VNET, got.VNET))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,60 @@
+package vergeio
+
+import (
+	"strings"
+	"testing"
+
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+func TestDiffCreatedVM(t *testing.T) {
+	requested := &client.VMAPIResourceModel{Name: "packer-test", CPUCores: 4, RAM: 8192, UEFI: true}
+	actual := &client.VMAPIResourceModel{Name: "packer-test", CPUCores: 4, RAM: 4096, UEFI: false, GuestAgent: true}
+
+	diffs := diffCreatedVM(requested, actual)
+	if len(diffs) != 2 {
+		t.Fatalf("expected 2 differences, got %v", diffs)
+	}
+	if !strings.HasPrefix(diffs[0], "ram:") || !strings.HasPrefix(diffs[1], "uefi:") {
+		t.Fatalf("unexpected differences: %v", diffs)
+	}
+}
+
+func TestDiffCreatedDisks(t *testing.T) {
+	requested := []client.VMDiskResourceModel{
+		{Name: "os", Interface: "virtio-scsi", Media: "import", DiskSize: 40 << 30},
+		{Name: "data", Interface: "virtio-scsi", Media: "disk", DiskSize: 10 << 30},
+		{Name: "logs", Media: "disk", DiskSize: 1 << 30},
+		{Name: "base", Media: "clone"},
+	}
+	keys := []string{"11", "12", "13", "14"}
+	actual := []client.VMDiskResourceModel{
+		// Import size comes from the source image until the drive is grown
+		{Key: 12, Name: "data", Interface: "ide", Media: "disk", DiskSize: 10 << 30},
+		{Key: 11, Name: "os", Interface: "virtio-scsi", Media: "import", DiskSize: 2 << 30},
+		// A clone reads back as a plain disk once the copy has finished
+		{Key: 14, Name: "base", Media: "disk", DiskSize: 20 << 30},
+	}
+
+	diffs := diffCreatedDisks(requested, keys, actual)
+	want := []string{"vm_disks[1].interface:", "vm_disks[2]: drive 13 is missing"}
+	if len(diffs) != len(want) {
+		t.Fatalf("expected %d differences, got %v", len(want), diffs)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(diffs[i], prefix) {
+			t.Errorf("expected %q to start with %q", diffs[i], prefix)
+		}
+	}
+}
+
+func TestVMCreateTransactionEmpty(t *testing.T) {
+	tx := &vmCreateTransaction{}
+	if !tx.empty() {
+		t.Fatal("new transaction should be empty")
+	}
+	tx.recordNic("7")
+	if tx.empty() {
+		t.Fatal("transaction with a NIC should not be empty")
+	}
+}
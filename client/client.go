@@ -5,8 +5,10 @@ package vergeio
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,6 +21,12 @@ const (
 	APIEndpoint = "api/v4"
 )
 
+// Polling defaults used while waiting for asynchronous Verge.IO actions to settle.
+const (
+	DefaultPollInterval  = 2 * time.Second
+	DefaultActionTimeout = 15 * time.Minute
+)
+
 // IClient interface.
 type IClient interface {
 	Name() string
@@ -204,3 +212,63 @@ func (c *Client) Put(endpoint string, jsonpayload *bytes.Buffer) (*http.Response
 func (c *Client) Delete(endpoint string) (*http.Response, error) {
 	return c.Do("DELETE", endpoint, nil, nil)
 }
+
+// CanList reports whether the account may list the given endpoint.
+// A 401/403 means the permission is missing, any other failure is returned as an error.
+func (c *Client) CanList(endpoint string) (bool, error) {
+	resp, err := c.Get(endpoint, &Options{Fields: "$key", Limit: "1"})
+	if err == nil {
+		resp.Body.Close()
+		return true, nil
+	}
+
+	var apiErr Error
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == 401 || apiErr.StatusCode == 403) {
+		return false, nil
+	}
+	return false, err
+}
+
+// waitFor polls check every interval until it reports done, returns an error, or ctx ends.
+// If ctx carries no deadline, DefaultActionTimeout is applied so a stuck action can't hang forever.
+func waitFor(ctx context.Context, interval time.Duration, description string, check func() (bool, error)) error {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultActionTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		log.Printf("[VergeIO]: Still waiting for %s", description)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for %s: %w", description, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// sleepWithContext pauses for d, returning early with ctx's error if ctx ends first.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
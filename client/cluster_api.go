@@ -0,0 +1,142 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package vergeio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	ClusterEndpoint     = APIEndpoint + "/clusters"
+	NodeEndpoint        = APIEndpoint + "/nodes"
+	StorageTierEndpoint = APIEndpoint + "/storage_tiers"
+)
+
+// ClusterApi provides methods for reading cluster, node and storage capacity
+type ClusterApi struct {
+	name   string
+	client *Client
+}
+
+// ClusterInfo represents a compute cluster and its current RAM/core usage (RAM in MB)
+type ClusterInfo struct {
+	Key        int    `json:"$key,omitempty"`
+	Name       string `json:"name,omitempty"`
+	TotalRAM   int64  `json:"total_ram,omitempty"`
+	UsedRAM    int64  `json:"used_ram,omitempty"`
+	TotalCores int    `json:"total_cores,omitempty"`
+	UsedCores  int    `json:"used_cores,omitempty"`
+	// DefaultTier is the storage tier drives without a preferred tier are placed on
+	DefaultTier int `json:"default_tier,omitempty"`
+}
+
+// NodeInfo represents a physical node (RAM in MB)
+type NodeInfo struct {
+	Key     int    `json:"$key,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Cluster int    `json:"cluster,omitempty"`
+	RAM     int64  `json:"ram,omitempty"`
+	Cores   int    `json:"cores,omitempty"`
+	Running bool   `json:"running,omitempty"`
+}
+
+// StorageTierInfo represents a vSAN storage tier and its capacity in bytes
+type StorageTierInfo struct {
+	Key         int    `json:"$key,omitempty"`
+	Tier        int    `json:"tier,omitempty"`
+	Description string `json:"description,omitempty"`
+	Capacity    int64  `json:"capacity,omitempty"`
+	Used        int64  `json:"used,omitempty"`
+}
+
+// NewClusterApi creates a new ClusterApi instance
+func NewClusterApi(c *Client) *ClusterApi {
+	return &ClusterApi{
+		name:   "Cluster Api",
+		client: c,
+	}
+}
+
+func (ca *ClusterApi) Name() string {
+	return ca.name
+}
+
+// GetClusters lists the compute clusters with their RAM and core usage
+func (ca *ClusterApi) GetClusters(ctx context.Context) ([]ClusterInfo, error) {
+	var clusters []ClusterInfo
+	err := ca.list(ClusterEndpoint, &Options{
+		Fields: "$key,name,status#total_ram as total_ram,status#used_ram as used_ram,status#online_cores as total_cores,status#used_cores as used_cores,default_tier",
+	}, &clusters)
+	return clusters, err
+}
+
+// GetNodes lists the physical nodes
+func (ca *ClusterApi) GetNodes(ctx context.Context) ([]NodeInfo, error) {
+	var nodes []NodeInfo
+	err := ca.list(NodeEndpoint, &Options{
+		Fields: "$key,name,cluster,ram,cores,machine#status#running as running",
+		Filter: "physical eq true",
+	}, &nodes)
+	return nodes, err
+}
+
+// GetNodeByName retrieves the single node with the given name
+func (ca *ClusterApi) GetNodeByName(ctx context.Context, name string) (*NodeInfo, error) {
+	nodes, err := ca.GetNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node by name '%s': %w", name, err)
+	}
+
+	var matches []NodeInfo
+	var keys []string
+	for _, node := range nodes {
+		if node.Name == name {
+			matches = append(matches, node)
+			keys = append(keys, strconv.Itoa(node.Key))
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("node with name '%s' not found", name)
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, fmt.Errorf("node name '%s' is ambiguous: %d nodes match (keys %s)", name, len(matches), strings.Join(keys, ", "))
+}
+
+// GetStorageTiers lists the storage tiers with their capacity and usage
+func (ca *ClusterApi) GetStorageTiers(ctx context.Context) ([]StorageTierInfo, error) {
+	var tiers []StorageTierInfo
+	err := ca.list(StorageTierEndpoint, &Options{
+		Fields: "$key,tier,description,status#capacity as capacity,status#used as used",
+	}, &tiers)
+	return tiers, err
+}
+
+func (ca *ClusterApi) list(endpoint string, opts *Options, out interface{}) error {
+	apiResp, err := ca.client.Get(endpoint, opts)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", endpoint, err)
+	}
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	if err := json.NewDecoder(apiResp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", endpoint, err)
+	}
+
+	log.Printf("[VergeIO]: Read %s", endpoint)
+	return nil
+}
@@ -10,7 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +21,9 @@ const (
 	DiskEndpoint = APIEndpoint + "/machine_drives"
 )
 
+// diskFields is the field list used when reading drives back from the API.
+const diskFields = "$key,machine,name,disksize,interface,media,description,enabled,serial,media_source,preferred_tier,readonly,preserve_drive_format,asset,orderid,discard"
+
 func NewDriveApi(c *Client) *DriveApi {
 	return &DriveApi{
 		name:   "Drive Api",
@@ -34,6 +40,36 @@ func (da *DriveApi) Name() string {
 	return da.name
 }
 
+// GetValidDiskInterfaces returns the drive interface values accepted by the API
+func GetValidDiskInterfaces() []string {
+	return []string{
+		"virtio",
+		"ide",
+		"ahci",
+		"virtio-scsi",
+		"virtio-scsi-dedicated",
+		"lsi53c895a",
+		"megasas",
+		"megasas-gen2",
+		"lsi53c810",
+		"am53c974",
+		"dc390",
+		"mptsas1068",
+	}
+}
+
+// GetValidDiskMedia returns the drive media values accepted by the API
+func GetValidDiskMedia() []string {
+	return []string{
+		"disk",
+		"cdrom",
+		"clone",
+		"import",
+		"efidisk",
+		"nonpersistent",
+	}
+}
+
 type VMDriveAPIDataSourceModel struct {
 	Key           int                                `json:"$key,omitempty"`
 	Name          string                             `json:"name,omitempty"`
@@ -53,13 +89,24 @@ type VMDiskResourceModel struct {
 	Media               string `json:"media,omitempty"`
 	MediaSource         int    `json:"media_source,omitempty"`
 	PreferredTier       string `json:"preferred_tier,omitempty"`
-	DiskSize            int64  `json:"disksize,omitempty"`
+	DiskSize            int64  `json:"disksize,omitempty"` // bytes
 	Enabled             bool   `json:"enabled,omitempty"`
 	ReadOnly            bool   `json:"readonly,omitempty"`
 	Serial              string `json:"serial,omitempty"`
 	Asset               string `json:"asset,omitempty"`
 	OrderId             int    `json:"orderid,omitempty"`
 	PreserveDriveFormat bool   `json:"preserve_drive_format,omitempty"`
+	Discard             bool   `json:"discard,omitempty"`
+}
+
+// DriveUsage is the space a drive takes on the storage tier. AllocatedBytes only goes down
+// when the guest discards or zeroes the blocks it freed.
+type DriveUsage struct {
+	Key            int    `json:"$key,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Media          string `json:"media,omitempty"`
+	UsedBytes      int64  `json:"used_bytes,omitempty"`
+	AllocatedBytes int64  `json:"allocated_bytes,omitempty"`
 }
 
 type VMDriveMediaSourceDataSourceModel struct {
@@ -171,45 +218,146 @@ func (da *DriveApi) CheckDiskImportStatus(ctx context.Context, diskKey string) (
 	return diskStatus.PowerState, nil
 }
 
-// WaitForDiskImportCompletion waits for all disks with media="import" to complete importing
-func (da *DriveApi) WaitForDiskImportCompletion(ctx context.Context, diskKeys []string, maxRetries int) error {
+// DiskImportPollInterval is how often each importing disk is polled
+const DiskImportPollInterval = 5 * time.Second
+
+// diskErrorStatuses are drive states that mean an import will never complete
+var diskErrorStatuses = map[string]bool{
+	"error":   true,
+	"failed":  true,
+	"missing": true,
+}
+
+// DiskImportProgress is the import state of a single disk as reported by the API
+type DiskImportProgress struct {
+	Key           string `json:"-"`
+	Name          string `json:"name,omitempty"`
+	Status        string `json:"status,omitempty"`
+	StatusInfo    string `json:"status_info,omitempty"`
+	ImportedBytes int64  `json:"imported_bytes,omitempty"`
+	TotalBytes    int64  `json:"total_bytes,omitempty"`
+}
+
+// Importing reports whether the disk is still being imported
+func (p *DiskImportProgress) Importing() bool {
+	return strings.ToLower(p.Status) == "importing"
+}
+
+// Failed reports whether the disk landed in an error state
+func (p *DiskImportProgress) Failed() bool {
+	return diskErrorStatuses[strings.ToLower(p.Status)]
+}
+
+// Percent returns the import progress in percent, or -1 if the API doesn't expose the totals
+func (p *DiskImportProgress) Percent() int {
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	percent := int(p.ImportedBytes * 100 / p.TotalBytes)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// GetDiskImportProgress reads the import status and byte counters of a disk by its key
+func (da *DriveApi) GetDiskImportProgress(ctx context.Context, diskKey string) (*DiskImportProgress, error) {
+	apiResp, err := da.client.Get(fmt.Sprintf("%s/%s", DiskEndpoint, diskKey), &Options{
+		Fields: "name,status#status as status,status#status_info as status_info,media_source#used_bytes as imported_bytes,disksize as total_bytes",
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk status: %w", err)
+	}
+
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var progress DiskImportProgress
+	if err := json.NewDecoder(apiResp.Body).Decode(&progress); err != nil {
+		return nil, fmt.Errorf("failed to decode disk status response: %w", err)
+	}
+	progress.Key = diskKey
+
+	log.Printf("[VergeIO]: Disk %s import status: %s (%d/%d bytes)", diskKey, progress.Status, progress.ImportedBytes, progress.TotalBytes)
+	return &progress, nil
+}
+
+// WaitForDiskImportCompletion polls all disks with media="import" concurrently until every import
+// has finished, one of them fails, or the shared timeout expires. Failed status reads are retried,
+// only a disk in an error state ends the wait early. onProgress, if set, is called from the
+// polling goroutines after every status read.
+func (da *DriveApi) WaitForDiskImportCompletion(ctx context.Context, diskKeys []string, timeout time.Duration, onProgress func(DiskImportProgress)) error {
 	if len(diskKeys) == 0 {
 		log.Printf("[VergeIO]: No disks to wait for import completion")
 		return nil
 	}
 
-	log.Printf("[VergeIO]: Waiting for import completion of %d disk(s)", len(diskKeys))
+	log.Printf("[VergeIO]: Waiting for import completion of %d disk(s) (timeout: %v)", len(diskKeys), timeout)
 
-	// Initial delay to allow API to process the import request
-	time.Sleep(5 * time.Second)
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	for _, diskKey := range diskKeys {
-		log.Printf("[VergeIO]: Checking import status for disk: %s", diskKey)
-		
-		retries := 0
-		for retries < maxRetries {
-			status, err := da.CheckDiskImportStatus(ctx, diskKey)
-			if err != nil {
-				return fmt.Errorf("failed to check disk import status: %w", err)
-			}
+	// Initial delay to allow API to process the import request before the first status read
+	if err := sleepWithContext(waitCtx, DiskImportPollInterval); err != nil {
+		return fmt.Errorf("stopped waiting for disk imports: %w", err)
+	}
 
-			log.Printf("[VergeIO]: Disk %s import status: %s (attempt %d/%d)", diskKey, status, retries+1, maxRetries)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			// Stop polling the other disks, the build can't continue anyway
+			cancel()
+		})
+	}
 
-			// Check if import is complete (status is not "importing")
-			if strings.ToLower(status) != "importing" {
-				log.Printf("[VergeIO]: Disk %s import completed with status: %s", diskKey, status)
-				break
+	for _, diskKey := range diskKeys {
+		wg.Add(1)
+		go func(diskKey string) {
+			defer wg.Done()
+
+			err := waitFor(waitCtx, DiskImportPollInterval, fmt.Sprintf("import of disk %s", diskKey), func() (bool, error) {
+				progress, err := da.GetDiskImportProgress(waitCtx, diskKey)
+				if err != nil {
+					// A single failed read shouldn't end the wait, the timeout bounds how long we keep trying
+					log.Printf("[VergeIO]: Failed to check import status of disk %s, retrying: %v", diskKey, err)
+					return false, nil
+				}
+
+				if onProgress != nil {
+					onProgress(*progress)
+				}
+
+				if progress.Failed() {
+					return false, fmt.Errorf("import of disk '%s' (key: %s) failed with status %s: %s", progress.Name, diskKey, progress.Status, progress.StatusInfo)
+				}
+
+				return !progress.Importing(), nil
+			})
+			if err != nil {
+				fail(err)
 			}
+		}(diskKey)
+	}
 
-			// Still importing, wait and retry
-			retries++
-			if retries >= maxRetries {
-				return fmt.Errorf("disk %s failed to complete import after %d retries, last status: %s", diskKey, maxRetries, status)
-			}
+	wg.Wait()
 
-			log.Printf("[VergeIO]: Disk %s still importing, waiting 5 seconds before retry %d/%d", diskKey, retries+1, maxRetries)
-			time.Sleep(5 * time.Second)
+	if firstErr != nil {
+		// Report the real reason rather than the cancellation it caused in the other goroutines
+		if ctx.Err() == nil && errors.Is(firstErr, context.DeadlineExceeded) {
+			return fmt.Errorf("disk imports did not complete within %v: %w", timeout, firstErr)
 		}
+		return firstErr
 	}
 
 	log.Printf("[VergeIO]: All disk imports completed successfully")
@@ -222,7 +370,7 @@ func (da *DriveApi) ReadDisk(ctx context.Context, diskKey string) (*VMDiskResour
 
 	// Call the disk endpoint to get the disk information
 	apiResp, err := da.client.Get(fmt.Sprintf("%s/%s", DiskEndpoint, diskKey), &Options{
-		Fields: "machine,name,disksize,interface,media,description,enabled,serial,media_source,preferred_tier,readonly,preserve_drive_format,asset,orderid",
+		Fields: diskFields,
 	})
 
 	if err != nil {
@@ -247,13 +395,13 @@ func (da *DriveApi) ReadDisk(ctx context.Context, diskKey string) (*VMDiskResour
 	return &diskData, nil
 }
 
-// UpdateDiskSize updates the disk size when import disk size differs from requested size
-func (da *DriveApi) UpdateDiskSize(ctx context.Context, diskKey string, requestedSizeGB int64) error {
-	log.Printf("[VergeIO]: Updating disk size for key %s to %d GB", diskKey, requestedSizeGB)
+// UpdateDiskSize sets the size of a disk in bytes
+func (da *DriveApi) UpdateDiskSize(ctx context.Context, diskKey string, sizeBytes int64) error {
+	log.Printf("[VergeIO]: Updating disk size for key %s to %d bytes", diskKey, sizeBytes)
 
 	// Prepare the API data packet with only the size field to update
 	updateData := map[string]interface{}{
-		"disksize": requestedSizeGB * 1024 * 1024 * 1024, // Convert GB to bytes
+		"disksize": sizeBytes,
 	}
 
 	// Encode the API data
@@ -276,65 +424,195 @@ func (da *DriveApi) UpdateDiskSize(ctx context.Context, diskKey string, requeste
 		return fmt.Errorf("VergeIO API returned status code %d for disk update", apiResp.StatusCode)
 	}
 
-	log.Printf("[VergeIO]: Successfully updated disk %s size to %d GB", diskKey, requestedSizeGB)
+	log.Printf("[VergeIO]: Successfully updated disk %s size to %d bytes", diskKey, sizeBytes)
 	return nil
 }
 
-// CheckAndResizeImportedDisks checks if imported disks need to be resized and updates them
-func (da *DriveApi) CheckAndResizeImportedDisks(ctx context.Context, diskConfigs []VMDiskResourceModel, diskKeys []string) error {
-	if len(diskConfigs) == 0 || len(diskKeys) == 0 {
+// GrowDisks brings each disk up to its requested size, keyed by drive key. It is used for
+// imported and cloned drives, whose initial size comes from their source. A disk that is
+// already larger than requested keeps its size, drives are never shrunk. A warning is
+// returned for each such disk.
+func (da *DriveApi) GrowDisks(ctx context.Context, disks map[string]VMDiskResourceModel) ([]string, error) {
+	if len(disks) == 0 {
 		log.Printf("[VergeIO]: No disks to check for resizing")
-		return nil
+		return nil, nil
 	}
 
-	log.Printf("[VergeIO]: Checking %d imported disk(s) for size mismatches", len(diskKeys))
+	log.Printf("[VergeIO]: Checking %d disk(s) against their requested size", len(disks))
 
-	// Create a map of disk names to requested sizes for quick lookup
-	diskSizeMap := make(map[string]int64)
-	for _, config := range diskConfigs {
-		if config.Media == "import" {
-			diskSizeMap[config.Name] = config.DiskSize
-		}
+	keys := make([]string, 0, len(disks))
+	for key := range disks {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	// Check each imported disk
-	for i, diskKey := range diskKeys {
-		if i >= len(diskConfigs) {
+	var warnings []string
+	for _, diskKey := range keys {
+		config := disks[diskKey]
+		if config.DiskSize <= 0 {
 			continue
 		}
 
-		config := diskConfigs[i]
-		requestedSizeGB := config.DiskSize
-
-		log.Printf("[VergeIO]: Checking disk '%s' (key: %s) - requested size: %d GB", config.Name, diskKey, requestedSizeGB)
-
-		// Read current disk information
 		currentDisk, err := da.ReadDisk(ctx, diskKey)
 		if err != nil {
-			return fmt.Errorf("failed to read disk %s after import: %w", config.Name, err)
+			return warnings, fmt.Errorf("failed to read disk '%s': %w", config.Name, err)
 		}
 
-		// Convert current size from bytes to GB for comparison
-		currentSizeGB := currentDisk.DiskSize / (1024 * 1024 * 1024)
-		
-		log.Printf("[VergeIO]: Disk '%s' current size: %d GB, requested size: %d GB", config.Name, currentSizeGB, requestedSizeGB)
-
-		// Check if sizes match
-		if currentSizeGB != requestedSizeGB {
-			log.Printf("[VergeIO]: Size mismatch detected for disk '%s' - resizing from %d GB to %d GB", 
-				config.Name, currentSizeGB, requestedSizeGB)
-
-			// Update the disk size
-			if err := da.UpdateDiskSize(ctx, diskKey, requestedSizeGB); err != nil {
-				return fmt.Errorf("failed to resize disk %s: %w", config.Name, err)
-			}
+		log.Printf("[VergeIO]: Disk '%s' (key: %s) current size: %d bytes, requested size: %d bytes",
+			config.Name, diskKey, currentDisk.DiskSize, config.DiskSize)
 
-			log.Printf("[VergeIO]: Successfully resized disk '%s' to %d GB", config.Name, requestedSizeGB)
-		} else {
+		switch {
+		case currentDisk.DiskSize == config.DiskSize:
 			log.Printf("[VergeIO]: Disk '%s' size matches requested size, no resize needed", config.Name)
+		case currentDisk.DiskSize > config.DiskSize:
+			warnings = append(warnings, fmt.Sprintf("disk '%s' is %d bytes, larger than the requested %d bytes, keeping its size",
+				config.Name, currentDisk.DiskSize, config.DiskSize))
+		default:
+			if err := da.UpdateDiskSize(ctx, diskKey, config.DiskSize); err != nil {
+				return warnings, fmt.Errorf("failed to resize disk '%s': %w", config.Name, err)
+			}
+			log.Printf("[VergeIO]: Grew disk '%s' from %d to %d bytes", config.Name, currentDisk.DiskSize, config.DiskSize)
 		}
 	}
 
 	log.Printf("[VergeIO]: Completed disk size checking and resizing")
+	return warnings, nil
+}
+
+// ListVMDisks returns every drive attached to the given machine, ordered as the VM sees them
+func (da *DriveApi) ListVMDisks(ctx context.Context, machine int) ([]VMDiskResourceModel, error) {
+	log.Printf("[VergeIO]: Listing disks for machine: %d", machine)
+
+	apiResp, err := da.client.Get(DiskEndpoint, &Options{
+		Fields: diskFields,
+		Filter: fmt.Sprintf("machine eq %d", machine),
+		Sort:   "+orderid",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks: %w", err)
+	}
+
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var disks []VMDiskResourceModel
+	if err := json.NewDecoder(apiResp.Body).Decode(&disks); err != nil {
+		return nil, fmt.Errorf("failed to decode disk list response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d disk(s) for machine %d", len(disks), machine)
+	return disks, nil
+}
+
+// ListVMDriveUsage returns the used and allocated bytes of every drive of the machine
+func (da *DriveApi) ListVMDriveUsage(ctx context.Context, machine int) ([]DriveUsage, error) {
+	apiResp, err := da.client.Get(DiskEndpoint, &Options{
+		Fields: "$key,name,media,media_source#used_bytes as used_bytes,media_source#allocated_bytes as allocated_bytes",
+		Filter: fmt.Sprintf("machine eq %d", machine),
+		Sort:   "+orderid",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drive usage: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var usage []DriveUsage
+	if err := json.NewDecoder(apiResp.Body).Decode(&usage); err != nil {
+		return nil, fmt.Errorf("failed to decode drive usage response: %w", err)
+	}
+	return usage, nil
+}
+
+// UpdateVMDisk applies a partial update to a disk. Only the supplied fields are changed
+func (da *DriveApi) UpdateVMDisk(ctx context.Context, diskKey string, fields map[string]interface{}) error {
+	log.Printf("[VergeIO]: Updating disk %s with fields: %v", diskKey, fields)
+
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(fields); err != nil {
+		return fmt.Errorf("failed to encode disk update data: %w", err)
+	}
+
+	apiResp, err := da.client.Put(fmt.Sprintf("%s/%s", DiskEndpoint, url.PathEscape(diskKey)), encodedBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to update disk %s: %w", diskKey, err)
+	}
+
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+
+	if apiResp.StatusCode != 200 {
+		return fmt.Errorf("VergeIO API returned status code %d for disk update", apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Successfully updated disk %s", diskKey)
+	return nil
+}
+
+// DeleteVMDisk removes a disk and its data from the machine
+func (da *DriveApi) DeleteVMDisk(ctx context.Context, diskKey string) error {
+	log.Printf("[VergeIO]: Deleting disk %s", diskKey)
+
+	apiResp, err := da.client.Delete(fmt.Sprintf("%s/%s", DiskEndpoint, url.PathEscape(diskKey)))
+	if err != nil {
+		return fmt.Errorf("error deleting disk %s: %w", diskKey, err)
+	}
+
+	if apiResp == nil {
+		return fmt.Errorf("no response received when deleting disk %s", diskKey)
+	}
+
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete disk %s, status code: %d", diskKey, apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Successfully deleted disk %s", diskKey)
 	return nil
-}
\ No newline at end of file
+}
+
+// ChangeDiskMedia swaps the media source of a CD-ROM drive, e.g. to insert a different ISO
+func (da *DriveApi) ChangeDiskMedia(ctx context.Context, diskKey string, mediaSource int) error {
+	return da.UpdateVMDisk(ctx, diskKey, map[string]interface{}{
+		"media_source": mediaSource,
+	})
+}
+
+// EjectDiskMedia detaches the media source from a CD-ROM drive, leaving the drive empty
+func (da *DriveApi) EjectDiskMedia(ctx context.Context, diskKey string) error {
+	return da.UpdateVMDisk(ctx, diskKey, map[string]interface{}{
+		"media_source": nil,
+	})
+}
+
+// MoveDiskToTier moves a disk's data to another storage tier
+func (da *DriveApi) MoveDiskToTier(ctx context.Context, diskKey string, tier string) error {
+	return da.UpdateVMDisk(ctx, diskKey, map[string]interface{}{
+		"preferred_tier": tier,
+	})
+}
+
+// HotplugVMDisk attaches a disk to a running VM
+func (da *DriveApi) HotplugVMDisk(ctx context.Context, vmKey string, diskKey string) error {
+	log.Printf("[VergeIO]: Hotplugging disk %s into VM %s", diskKey, vmKey)
+
+	_, err := NewVMApi(da.client).postVMAction(vmKey, "hotplug", &VMActionParams{Device: diskKey})
+	return err
+}
+
+// UnplugVMDisk detaches a disk from a running VM without deleting it
+func (da *DriveApi) UnplugVMDisk(ctx context.Context, vmKey string, diskKey string) error {
+	log.Printf("[VergeIO]: Unplugging disk %s from VM %s", diskKey, vmKey)
+
+	_, err := NewVMApi(da.client).postVMAction(vmKey, "hotplug", &VMActionParams{Device: diskKey, Unplug: true})
+	return err
+}
@@ -0,0 +1,240 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package vergeio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	FileEndpoint = APIEndpoint + "/files"
+)
+
+// UploadChunkSize is how much of a file UploadFile sends per request
+const UploadChunkSize = 64 << 20
+
+// FileApi provides methods for interacting with the VergeIO media file catalog
+type FileApi struct {
+	name   string
+	client *Client
+}
+
+// FileInfo represents a file in the VergeIO media catalog (ISOs, disk images, ...)
+type FileInfo struct {
+	Key            int    `json:"$key,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Type           string `json:"type,omitempty"`
+	Description    string `json:"description,omitempty"`
+	Filesize       int64  `json:"filesize,omitempty"`
+	UsedBytes      int64  `json:"used_bytes,omitempty"`
+	AllocatedBytes int64  `json:"allocated_bytes,omitempty"`
+	PreferredTier  string `json:"preferred_tier,omitempty"`
+}
+
+// NewFileApi creates a new FileApi instance
+func NewFileApi(c *Client) *FileApi {
+	return &FileApi{
+		name:   "File Api",
+		client: c,
+	}
+}
+
+func (fa *FileApi) Name() string {
+	return fa.name
+}
+
+// GetFiles retrieves catalog files, optionally filtered by exact name
+func (fa *FileApi) GetFiles(ctx context.Context, filterName string) ([]FileInfo, error) {
+	log.Printf("[VergeIO]: Getting files with filter_name='%s'", filterName)
+
+	opts := &Options{
+		Fields: "$key,name,type,description,filesize,used_bytes,allocated_bytes,preferred_tier",
+	}
+	if filterName != "" {
+		opts.Filter = fmt.Sprintf("name eq '%s'", filterName)
+	}
+
+	return fa.getFiles(opts)
+}
+
+// GetFile retrieves a single catalog file by its key
+func (fa *FileApi) GetFile(ctx context.Context, key int) (*FileInfo, error) {
+	files, err := fa.getFiles(&Options{
+		Fields: "$key,name,type,description,filesize,used_bytes,allocated_bytes,preferred_tier",
+		Filter: fmt.Sprintf("$key eq %d", key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("file with key %d not found", key)
+	}
+	return &files[0], nil
+}
+
+// GetFileByName retrieves the single catalog file with the given name.
+// More than one match is an error, the caller has to use the file key instead.
+func (fa *FileApi) GetFileByName(ctx context.Context, name string) (*FileInfo, error) {
+	files, err := fa.GetFiles(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file by name '%s': %w", name, err)
+	}
+
+	switch len(files) {
+	case 0:
+		return nil, fmt.Errorf("file with name '%s' not found", name)
+	case 1:
+		return &files[0], nil
+	}
+
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, strconv.Itoa(file.Key))
+	}
+	return nil, fmt.Errorf("file name '%s' is ambiguous: %d files match (keys %s)", name, len(files), strings.Join(keys, ", "))
+}
+
+func (fa *FileApi) getFiles(opts *Options) ([]FileInfo, error) {
+	apiResp, err := fa.client.Get(FileEndpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var files []FileInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("failed to decode files response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d file(s)", len(files))
+	return files, nil
+}
+
+// CreateFile adds an empty file of the given type and size to the catalog and returns its key.
+// UploadFile then writes the contents.
+func (fa *FileApi) CreateFile(ctx context.Context, name string, fileType string, size int64) (int, error) {
+	log.Printf("[VergeIO]: Creating catalog file '%s' (%s, %d bytes)", name, fileType, size)
+
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(map[string]interface{}{
+		"name":     name,
+		"type":     fileType,
+		"filesize": size,
+	}); err != nil {
+		return 0, fmt.Errorf("failed to encode file data: %w", err)
+	}
+
+	apiResp, err := fa.client.Post(FileEndpoint, encodedBuffer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file '%s': %w", name, err)
+	}
+	if apiResp == nil {
+		return 0, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 201 {
+		return 0, fmt.Errorf("VergeIO API returned status code %d for file creation", apiResp.StatusCode)
+	}
+
+	var created VergeResponse
+	if err := json.NewDecoder(apiResp.Body).Decode(&created); err != nil {
+		return 0, fmt.Errorf("failed to decode file creation response: %w", err)
+	}
+	key, err := strconv.Atoi(created.Key)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file key %q returned by the API", created.Key)
+	}
+	return key, nil
+}
+
+// UploadFile writes size bytes from r into the catalog file, one UploadChunkSize request at
+// a time with the chunk's offset as filepos. progress, if set, is called after every chunk
+// with the number of bytes sent so far.
+func (fa *FileApi) UploadFile(ctx context.Context, key int, r io.Reader, size int64, progress func(sent int64)) error {
+	// Chunks take longer than the client's API timeout, the request context bounds them instead
+	httpClient := &http.Client{Transport: fa.client.httpClient.Transport}
+	endpoint := fmt.Sprintf("%s/%d", FileEndpoint, key)
+
+	buf := make([]byte, UploadChunkSize)
+	for sent := int64(0); sent < size; {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-sent)])
+		if err != nil {
+			return fmt.Errorf("failed to read file %d at offset %d: %w", key, sent, err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s?filepos=%d", fa.client.serverURL(endpoint), sent), bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(fa.client.Username, fa.client.Password)
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to upload file %d at offset %d: %w", key, sent, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("VergeIO API returned status code %d uploading file %d at offset %d", resp.StatusCode, key, sent)
+		}
+
+		sent += int64(n)
+		if progress != nil {
+			progress(sent)
+		}
+	}
+
+	log.Printf("[VergeIO]: Uploaded %d bytes to file %d", size, key)
+	return nil
+}
+
+// SetFileDescription replaces the description of a catalog file
+func (fa *FileApi) SetFileDescription(ctx context.Context, key int, description string) error {
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(map[string]interface{}{"description": description}); err != nil {
+		return fmt.Errorf("failed to encode file data: %w", err)
+	}
+
+	apiResp, err := fa.client.Put(fmt.Sprintf("%s/%d", FileEndpoint, key), encodedBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to update file %d: %w", key, err)
+	}
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return fmt.Errorf("VergeIO API returned status code %d for file update", apiResp.StatusCode)
+	}
+	return nil
+}
+
+// DeleteFile removes a file from the catalog
+func (fa *FileApi) DeleteFile(ctx context.Context, key int) error {
+	apiResp, err := fa.client.Delete(fmt.Sprintf("%s/%d", FileEndpoint, key))
+	if err != nil {
+		return fmt.Errorf("error deleting file %d: %w", key, err)
+	}
+	if apiResp == nil {
+		return fmt.Errorf("no response received when deleting file %d", key)
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete file %d, status code: %d", key, apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Deleted file %d", key)
+	return nil
+}
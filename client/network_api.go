@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 )
 
 // Network endpoints based on Terraform provider
@@ -84,13 +86,45 @@ func (na *NetworkApi) GetNetworks(ctx context.Context, filterName, filterType st
 
 	// Log each network found
 	for _, network := range networks {
-		log.Printf("[VergeIO Network API]: Network found - ID: %d, Name: %s, Description: %s", 
+		log.Printf("[VergeIO Network API]: Network found - ID: %d, Name: %s, Description: %s",
 			network.ID, network.Name, network.Description)
 	}
 
 	return networks, nil
 }
 
+// GetNetwork retrieves a specific network by its key
+func (na *NetworkApi) GetNetwork(ctx context.Context, key int) (*NetworkInfo, error) {
+	log.Printf("[VergeIO Network API]: Getting network by key: %d", key)
+
+	apiResp, err := na.client.Get(NetworkEndpoint, &Options{
+		Fields: "description,name,$key",
+		Filter: fmt.Sprintf("$key eq %d", key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call VergeIO API: %w", err)
+	}
+
+	if apiResp == nil {
+		return nil, errors.New("missing response from the VergeIO API")
+	}
+
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var networks []NetworkInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&networks); err != nil {
+		return nil, fmt.Errorf("failed to decode API response: %w", err)
+	}
+
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("network with key %d not found", key)
+	}
+
+	return &networks[0], nil
+}
+
 // GetNetworkByName retrieves a specific network by name
 func (na *NetworkApi) GetNetworkByName(ctx context.Context, name string) (*NetworkInfo, error) {
 	log.Printf("[VergeIO Network API]: Getting network by name: %s", name)
@@ -105,10 +139,14 @@ func (na *NetworkApi) GetNetworkByName(ctx context.Context, name string) (*Netwo
 	}
 
 	if len(networks) > 1 {
-		log.Printf("[VergeIO Network API]: Warning - found %d networks with name '%s', returning first one", len(networks), name)
+		keys := make([]string, 0, len(networks))
+		for _, network := range networks {
+			keys = append(keys, strconv.Itoa(int(network.ID)))
+		}
+		return nil, fmt.Errorf("network name '%s' is ambiguous: %d networks match (keys %s)", name, len(networks), strings.Join(keys, ", "))
 	}
 
 	network := &networks[0]
 	log.Printf("[VergeIO Network API]: Found network - ID: %d, Name: %s", network.ID, network.Name)
 	return network, nil
-}
\ No newline at end of file
+}
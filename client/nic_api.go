@@ -10,12 +10,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 )
 
 const (
 	NICEndpoint = APIEndpoint + "/machine_nics"
 )
 
+// nicFields is the field list used when reading NICs back from the API.
+const nicFields = "$key,machine,name,description,interface,driver,model,vnet,macaddress,ipaddress,assign_ipaddress,enabled,orderid"
+
 func NewNicApi(c *Client) *NicApi {
 	return &NicApi{
 		name:   "NIC Api",
@@ -32,6 +36,30 @@ func (na *NicApi) Name() string {
 	return na.name
 }
 
+// GetValidNicInterfaces returns the NIC interface values accepted by the API
+func GetValidNicInterfaces() []string {
+	return []string{
+		"virtio",
+		"e1000",
+		"e1000e",
+		"rtl8139",
+		"pcnet",
+		"igb",
+		"eepro100",
+		"vmxnet3",
+		"direct",
+	}
+}
+
+// GetValidNicDrivers returns the NIC driver values accepted by the API. The driver is the
+// host side backend of the NIC, the guest sees the interface type.
+func GetValidNicDrivers() []string {
+	return []string{
+		"virtio",
+		"vhost",
+	}
+}
+
 type VMNICAPIDataSourceModel struct {
 	Key        int    `json:"$key,omitempty"`
 	Name       string `json:"name,omitempty"`
@@ -56,6 +84,7 @@ type VMNicResourceModel struct {
 	IPAddress       string `json:"ipaddress,omitempty"`
 	AssignIPAddress bool   `json:"assign_ipaddress,omitempty"`
 	Enabled         bool   `json:"enabled,omitempty"`
+	OrderId         int    `json:"orderid,omitempty"`
 }
 
 type nicResponse struct {
@@ -65,31 +94,149 @@ type nicResponse struct {
 }
 
 func (na *NicApi) CreateVMNic(ctx context.Context, apiData *VMNicResourceModel) error {
+	_, err := na.CreateVMNicWithKey(ctx, apiData)
+	return err
+}
+
+// CreateVMNicWithKey creates a VM NIC and returns the NIC key for tracking
+func (na *NicApi) CreateVMNicWithKey(ctx context.Context, apiData *VMNicResourceModel) (string, error) {
 	// Encode the API data
 	encodedBuffer := new(bytes.Buffer)
 	if err := json.NewEncoder(encodedBuffer).Encode(apiData); err != nil {
-		return errors.New("invalid format received for NIC Item")
+		return "", errors.New("invalid format received for NIC Item")
 	}
 
 	// Call the API and check the response
 	apiResp, err := na.client.Post(NICEndpoint, encodedBuffer)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if apiResp == nil {
-		return errors.New("missing response from the API")
+		return "", errors.New("missing response from the API")
 	}
 	if apiResp.StatusCode != 201 {
-		return fmt.Errorf("missing response from the API %d", apiResp.StatusCode)
+		return "", fmt.Errorf("missing response from the API %d", apiResp.StatusCode)
 	}
 
 	// Decode the API response
 	var nicAPIResp nicResponse
 	if err := json.NewDecoder(apiResp.Body).Decode(&nicAPIResp); err != nil {
-		return fmt.Errorf("invalid format received for creating a NIC %v", err)
+		return "", fmt.Errorf("invalid format received for creating a NIC %v", err)
 	}
 
 	log.Printf("Created a NIC with Id %v", nicAPIResp.Key)
 
+	return nicAPIResp.Key, nil
+}
+
+// ListVMNics returns every NIC attached to the given machine
+func (na *NicApi) ListVMNics(ctx context.Context, machine int) ([]VMNicResourceModel, error) {
+	log.Printf("[VergeIO]: Listing NICs for machine: %d", machine)
+
+	apiResp, err := na.client.Get(NICEndpoint, &Options{
+		Fields: nicFields,
+		Filter: fmt.Sprintf("machine eq %d", machine),
+		Sort:   "+orderid",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NICs: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var nics []VMNicResourceModel
+	if err := json.NewDecoder(apiResp.Body).Decode(&nics); err != nil {
+		return nil, fmt.Errorf("failed to decode NIC list response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d NIC(s) for machine %d", len(nics), machine)
+	return nics, nil
+}
+
+// ReadNic reads a single NIC by its key
+func (na *NicApi) ReadNic(ctx context.Context, nicKey string) (*VMNicResourceModel, error) {
+	log.Printf("[VergeIO]: Reading NIC information for key: %s", nicKey)
+
+	apiResp, err := na.client.Get(fmt.Sprintf("%s/%s", NICEndpoint, url.PathEscape(nicKey)), &Options{
+		Fields: nicFields,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NIC: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var nicData VMNicResourceModel
+	if err := json.NewDecoder(apiResp.Body).Decode(&nicData); err != nil {
+		return nil, fmt.Errorf("failed to decode NIC response: %w", err)
+	}
+
+	return &nicData, nil
+}
+
+// UpdateVMNic applies a partial update to a NIC. Only the supplied fields are changed
+func (na *NicApi) UpdateVMNic(ctx context.Context, nicKey string, fields map[string]interface{}) error {
+	log.Printf("[VergeIO]: Updating NIC %s with fields: %v", nicKey, fields)
+
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(fields); err != nil {
+		return fmt.Errorf("failed to encode NIC update data: %w", err)
+	}
+
+	apiResp, err := na.client.Put(fmt.Sprintf("%s/%s", NICEndpoint, url.PathEscape(nicKey)), encodedBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to update NIC %s: %w", nicKey, err)
+	}
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return fmt.Errorf("VergeIO API returned status code %d for NIC update", apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Successfully updated NIC %s", nicKey)
+	return nil
+}
+
+// DeleteVMNic removes a NIC from the machine
+func (na *NicApi) DeleteVMNic(ctx context.Context, nicKey string) error {
+	log.Printf("[VergeIO]: Deleting NIC %s", nicKey)
+
+	apiResp, err := na.client.Delete(fmt.Sprintf("%s/%s", NICEndpoint, url.PathEscape(nicKey)))
+	if err != nil {
+		return fmt.Errorf("error deleting NIC %s: %w", nicKey, err)
+	}
+	if apiResp == nil {
+		return fmt.Errorf("no response received when deleting NIC %s", nicKey)
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete NIC %s, status code: %d", nicKey, apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Successfully deleted NIC %s", nicKey)
 	return nil
-}
\ No newline at end of file
+}
+
+// HotplugVMNic attaches a NIC to a running VM
+func (na *NicApi) HotplugVMNic(ctx context.Context, vmKey string, nicKey string) error {
+	log.Printf("[VergeIO]: Hotplugging NIC %s into VM %s", nicKey, vmKey)
+
+	_, err := NewVMApi(na.client).postVMAction(vmKey, "hotplug", &VMActionParams{Device: nicKey})
+	return err
+}
+
+// UnplugVMNic detaches a NIC from a running VM without deleting it
+func (na *NicApi) UnplugVMNic(ctx context.Context, vmKey string, nicKey string) error {
+	log.Printf("[VergeIO]: Unplugging NIC %s from VM %s", nicKey, vmKey)
+
+	_, err := NewVMApi(na.client).postVMAction(vmKey, "hotplug", &VMActionParams{Device: nicKey, Unplug: true})
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MIT
+
+package vergeio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	UserEndpoint       = APIEndpoint + "/users"
+	MemberEndpoint     = APIEndpoint + "/members"
+	PermissionEndpoint = APIEndpoint + "/permissions"
+)
+
+// TablePermission is what an account may do with the rows of one table
+type TablePermission struct {
+	Table  string `json:"table"`
+	List   bool   `json:"list"`
+	Read   bool   `json:"read"`
+	Create bool   `json:"create"`
+	Modify bool   `json:"modify"`
+	Delete bool   `json:"delete"`
+}
+
+// merge adds another grant for the same table to p
+func (p *TablePermission) merge(other TablePermission) {
+	p.List = p.List || other.List
+	p.Read = p.Read || other.Read
+	p.Create = p.Create || other.Create
+	p.Modify = p.Modify || other.Modify
+	p.Delete = p.Delete || other.Delete
+}
+
+// GetTablePermissions collects the table permissions granted to the user and to the groups it
+// is a member of, keyed by table name. A grant on the root table "/" applies to every table
+// and is returned under "/". The result is false when the account may not read the users,
+// members or permissions tables, which is common for non-admin accounts.
+func (c *Client) GetTablePermissions(username string) (map[string]TablePermission, bool, error) {
+	get := func(endpoint string, opts *Options, v interface{}) (bool, error) {
+		resp, err := c.Get(endpoint, opts)
+		if err != nil {
+			var apiErr Error
+			if errors.As(err, &apiErr) && (apiErr.StatusCode == 401 || apiErr.StatusCode == 403) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to query %s: %w", endpoint, err)
+		}
+		if resp == nil {
+			return false, errors.New("missing response from VergeIO API")
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return false, fmt.Errorf("failed to decode %s response: %w", endpoint, err)
+		}
+		return true, nil
+	}
+
+	var users []struct {
+		Key      int `json:"$key"`
+		Identity int `json:"identity"`
+	}
+	ok, err := get(UserEndpoint, &Options{Fields: "$key,identity", Filter: fmt.Sprintf("name eq '%s'", username)}, &users)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	if len(users) != 1 {
+		return nil, false, nil
+	}
+
+	var groups []struct {
+		Identity int `json:"identity"`
+	}
+	ok, err = get(MemberEndpoint, &Options{Fields: "group#identity as identity", Filter: fmt.Sprintf("member eq 'users/%d'", users[0].Key)}, &groups)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	identities := []string{fmt.Sprintf("identity eq %d", users[0].Identity)}
+	for _, group := range groups {
+		identities = append(identities, fmt.Sprintf("identity eq %d", group.Identity))
+	}
+
+	var grants []TablePermission
+	ok, err = get(PermissionEndpoint, &Options{Fields: "table,list,read,create,modify,delete", Filter: strings.Join(identities, " or ")}, &grants)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	permissions := make(map[string]TablePermission)
+	for _, grant := range grants {
+		table := strings.Trim(grant.Table, "/")
+		if table == "" {
+			table = "/"
+		}
+		permission := permissions[table]
+		permission.Table = table
+		permission.merge(grant)
+		permissions[table] = permission
+	}
+	return permissions, true, nil
+}
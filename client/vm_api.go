@@ -13,15 +13,24 @@ import (
 	"log"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	VMEndpoint       = APIEndpoint + "/vms"
-	VMActionEndpoint = APIEndpoint + "/vm_actions"
+	VMEndpoint         = APIEndpoint + "/vms"
+	VMActionEndpoint   = APIEndpoint + "/vm_actions"
+	VMSnapshotEndpoint = APIEndpoint + "/machine_snapshots"
+
+	CloudInitFileEndpoint = APIEndpoint + "/cloudinit_files"
+
+	SnapshotProfileEndpoint = APIEndpoint + "/snapshot_profiles"
 )
 
+// vmFullFields is the field list used whenever the complete VM resource is read back.
+const vmFullFields = "id,machine,name,cluster,description,enabled,machine_type,allow_hotplug,disable_powercycle,cpu_cores,cpu_type,ram,console,display,video,sound,os_family,os_description,rtc_base,boot_order,console_pass_enabled,console_pass,usb_tablet,uefi,secure_boot,serial_port,boot_delay,preferred_node,snapshot_profile,cloudinit_datasource,ha_group,guest_agent,advanced,nested_virtualization,disable_hypervisor,machine#status#running as powerstate"
+
 func NewVMApi(c *Client) *VMApi {
 	return &VMApi{
 		name:   "VM Api",
@@ -32,13 +41,25 @@ func NewVMApi(c *Client) *VMApi {
 type VMApi struct {
 	name   string
 	client *Client
+
+	// PollInterval is how often state is polled while waiting for an action to settle.
+	// Defaults to DefaultPollInterval when zero.
+	PollInterval time.Duration
 }
 
 func (va *VMApi) Name() string {
 	return va.name
 }
 
-func getValidOSFamilies() []string {
+func (va *VMApi) pollInterval() time.Duration {
+	if va.PollInterval > 0 {
+		return va.PollInterval
+	}
+	return DefaultPollInterval
+}
+
+// GetValidOSFamilies returns the os_family values accepted by the API.
+func GetValidOSFamilies() []string {
 	return []string{
 		"linux",
 		"windows",
@@ -47,7 +68,8 @@ func getValidOSFamilies() []string {
 	}
 }
 
-func getValidMachineTypes() []string {
+// GetValidMachineTypes returns the machine_type values accepted by the API.
+func GetValidMachineTypes() []string {
 	return []string{"pc",
 		"pc-i440fx-2.7",
 		"pc-i440fx-2.8",
@@ -102,6 +124,23 @@ func getValidMachineTypes() []string {
 	}
 }
 
+// GetValidRTCBases returns the rtc_base values accepted by the API.
+func GetValidRTCBases() []string {
+	return []string{
+		"utc",
+		"localtime",
+	}
+}
+
+// GetValidCloudInitDataSources returns the cloudinit_datasource values accepted by the API.
+func GetValidCloudInitDataSources() []string {
+	return []string{
+		"none",
+		"nocloud",
+		"config_drive_v2",
+	}
+}
+
 type VMAPIDataSourceModel struct {
 	Id          int32  `json:"id,omitempty"`
 	Name        string `json:"name,omitempty"`
@@ -122,6 +161,15 @@ type CloudInitFileAPI struct {
 	Contents string `json:"contents"`
 }
 
+// CloudInitFileInfo is a cloud-init file stored for a VM, owner is "vms/<vm key>".
+// Contents is only filled in by FindCloudInitFiles.
+type CloudInitFileInfo struct {
+	Key      int    `json:"$key,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Contents string `json:"contents,omitempty"`
+}
+
 type VMAPIGuestAgentModel struct {
 	Machine struct {
 		Status struct {
@@ -196,8 +244,82 @@ type VMPowerState struct {
 }
 
 type VMActionParams struct {
-	Device string `json:"device,omitempty"`
-	Unplug bool   `json:"unplug,omitempty"`
+	Device        string `json:"device,omitempty"`
+	Unplug        bool   `json:"unplug,omitempty"`
+	Name          string `json:"name,omitempty"`
+	PreferredNode string `json:"preferred_node,omitempty"`
+	Snapshot      string `json:"snapshot,omitempty"`
+	Retention     int64  `json:"retention,omitempty"`
+	Quiesce       bool   `json:"quiesce,omitempty"`
+}
+
+// VMStatus is the runtime status of a VM's machine as reported by the API.
+type VMStatus struct {
+	Running bool        `json:"running,omitempty"`
+	Status  string      `json:"status,omitempty"`
+	Node    json.Number `json:"node,omitempty"`
+	// Changed is when the status last changed, in unix seconds
+	Changed int64 `json:"changed,omitempty"`
+}
+
+// VMSnapshotInfo represents a machine snapshot of a VM.
+type VMSnapshotInfo struct {
+	Key         int    `json:"$key,omitempty"`
+	Machine     int    `json:"machine,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+	Expires     int64  `json:"expires,omitempty"`
+}
+
+// VMSummary is the identity of a VM, as listed by FindVMsByPrefix.
+type VMSummary struct {
+	Key         int    `json:"$key,omitempty"`
+	Machine     int    `json:"machine,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+}
+
+// SnapshotProfileInfo represents a snapshot profile that can be assigned to a VM.
+type SnapshotProfileInfo struct {
+	Key         int    `json:"$key,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// transitionalStatuses are machine states that mean an action is still in progress.
+var transitionalStatuses = map[string]bool{
+	"starting":  true,
+	"stopping":  true,
+	"resetting": true,
+	"migrating": true,
+	"cloning":   true,
+	"restoring": true,
+}
+
+// vmActionWait tells when an action posted to a VM has finished. A powered-off VM is already
+// settled before a restore starts and a VM being reset still reports running, so done is only
+// asked once the action was seen to start: the VM passed through a transitional status, or its
+// status changed after the one read before the action was posted.
+type vmActionWait struct {
+	before  VMStatus
+	started bool
+	done    func(*VMStatus) bool
+}
+
+func (w *vmActionWait) finished(status *VMStatus) bool {
+	if transitionalStatuses[strings.ToLower(status.Status)] {
+		w.started = true
+		return false
+	}
+	if !w.started {
+		if status.Changed <= w.before.Changed {
+			return false
+		}
+		w.started = true
+	}
+	return w.done(status)
 }
 
 type NewResponse struct {
@@ -209,6 +331,8 @@ type NewResponseMachine struct {
 	Machine string `json:"machine,omitempty"`
 }
 
+// CreateVM creates the VM and reads it back into apiData. apiData.Id is set as soon as
+// the VM exists, so a caller must clean up whenever it is non-empty, even if an error is returned.
 func (va *VMApi) CreateVM(_ context.Context, apiData *VMAPIResourceModel) error {
 	log.Printf("[Vergeio]: Creating VM with data: %+v", apiData)
 
@@ -296,61 +420,526 @@ func (va *VMApi) IsVMRunning(ctx context.Context, vmId string) (*bool, error) {
 	return vmAPIResp.PowerState, nil
 }
 
-func (va *VMApi) PowerOnVM(vmKey string) error {
+// PowerOnVM powers on the VM and waits until it reports running.
+func (va *VMApi) PowerOnVM(ctx context.Context, vmKey string) error {
 	log.Printf("Calling the Power On VM API for VM Key %s", vmKey)
 	err := va.changeVMPowerState(vmKey, "poweron")
 	if err != nil {
 		return err
 	}
 
-	time.Sleep(10 * time.Second)
-
-	return nil
+	return va.WaitForPowerState(ctx, vmKey, true)
 }
 
-func (va *VMApi) PowerOffVM(vmKey string) error {
+// PowerOffVM hard-kills the VM and waits until it reports powered off.
+func (va *VMApi) PowerOffVM(ctx context.Context, vmKey string) error {
 	log.Printf("Calling the Power Off VM API for VM Key %s", vmKey)
 	err := va.changeVMPowerState(vmKey, "kill")
 	if err != nil {
 		return err
 	}
 
-	time.Sleep(5 * time.Second)
-
-	return nil
+	return va.WaitForPowerState(ctx, vmKey, false)
 }
 
 func (va *VMApi) changeVMPowerState(vmKey string, desiredState string) error {
 	log.Printf("Change the power state for VM Key %s to %s", vmKey, desiredState)
 
+	if _, err := va.postVMAction(vmKey, desiredState, nil); err != nil {
+		return fmt.Errorf("failed to change the VM power state: %w", err)
+	}
+
+	return nil
+}
+
+// postVMAction sends an action for the VM to the vm_actions endpoint.
+func (va *VMApi) postVMAction(vmKey string, action string, params *VMActionParams) (*VergeResponse, error) {
+	log.Printf("[VergeIO]: Sending action %s for VM Key %s with params %+v", action, vmKey, params)
+
 	actionData := map[string]interface{}{
 		"vm":     vmKey,
-		"action": desiredState,
+		"action": action,
+	}
+	if params != nil {
+		actionData["params"] = params
 	}
 
 	bytedata, err := json.Marshal(actionData)
 	if err != nil {
+		return nil, err
+	}
+
+	apiResp, err := va.client.Post(VMActionEndpoint, bytes.NewBuffer(bytedata))
+	if err != nil {
+		return nil, err
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from the API")
+	}
+	if apiResp.StatusCode != 201 && apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("action %s failed: status code %v", action, apiResp.StatusCode)
+	}
+
+	var actionResp VergeResponse
+	if apiResp.Body != nil {
+		body, err := io.ReadAll(apiResp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response for action %s: %w", action, err)
+		}
+		if len(body) > 0 {
+			// The response payload differs per action, only the key and error are of interest here
+			if err := json.Unmarshal(body, &actionResp); err != nil {
+				log.Printf("[VergeIO]: Ignoring unparsable response for action %s: %s", action, string(body))
+			}
+		}
+	}
+	if actionResp.Error != "" {
+		return nil, fmt.Errorf("action %s failed: %s", action, actionResp.Error)
+	}
+
+	return &actionResp, nil
+}
+
+// GetVMStatus reads the runtime status of the VM's machine.
+func (va *VMApi) GetVMStatus(ctx context.Context, vmKey string) (*VMStatus, error) {
+	apiResp, err := va.client.Get(fmt.Sprintf("%s/%s",
+		VMEndpoint,
+		url.PathEscape(vmKey)),
+		&Options{
+			Fields: "machine#status#running as running,machine#status#status as status,machine#status#node as node,machine#status#last_update as changed"})
+
+	if err != nil {
+		return nil, err
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from the API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("missing response from API %d", apiResp.StatusCode)
+	}
+
+	var status VMStatus
+	if err := json.NewDecoder(apiResp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid format received for VM status: %v", err)
+	}
+
+	log.Printf("[VergeIO]: VM %s status: %+v", vmKey, status)
+	return &status, nil
+}
+
+// waitForVMStatus polls the VM status until done reports true or ctx ends.
+func (va *VMApi) waitForVMStatus(ctx context.Context, vmKey string, description string, done func(*VMStatus) bool) error {
+	return waitFor(ctx, va.pollInterval(), fmt.Sprintf("VM %s to %s", vmKey, description), func() (bool, error) {
+		status, err := va.GetVMStatus(ctx, vmKey)
+		if err != nil {
+			return false, err
+		}
+		return done(status), nil
+	})
+}
+
+// waitForVMAction waits for an action posted after before was read to start and then to finish,
+// as told by done.
+func (va *VMApi) waitForVMAction(ctx context.Context, vmKey string, description string, before VMStatus, done func(*VMStatus) bool) error {
+	wait := &vmActionWait{before: before, done: done}
+	return va.waitForVMStatus(ctx, vmKey, description, wait.finished)
+}
+
+// GetVM returns the full VM resource for the given VM key.
+func (va *VMApi) GetVM(ctx context.Context, vmId string) (*VMAPIResourceModel, error) {
+	data := &VMAPIResourceModel{Id: vmId}
+	if err := va.readVM(data); err != nil {
+		return nil, fmt.Errorf("error reading VM %s: %w", vmId, err)
+	}
+	return data, nil
+}
+
+// UpdateVM applies a partial update to the VM. Only the supplied fields are changed.
+func (va *VMApi) UpdateVM(ctx context.Context, vmId string, fields map[string]interface{}) error {
+	log.Printf("[VergeIO]: Updating VM %s with fields: %v", vmId, fields)
+
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(fields); err != nil {
+		return fmt.Errorf("failed to encode VM update data: %w", err)
+	}
+
+	apiResp, err := va.client.Put(fmt.Sprintf("%s/%s", VMEndpoint, url.PathEscape(vmId)), encodedBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to update VM %s: %w", vmId, err)
+	}
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return fmt.Errorf("VergeIO API returned status code %d for VM update", apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Successfully updated VM %s", vmId)
+	return nil
+}
+
+// ListCloudInitFiles returns the cloud-init files stored for the VM, without their contents
+func (va *VMApi) ListCloudInitFiles(ctx context.Context, vmKey string) ([]CloudInitFileInfo, error) {
+	apiResp, err := va.client.Get(CloudInitFileEndpoint, &Options{
+		Fields: "$key,owner,name",
+		Filter: fmt.Sprintf("owner eq 'vms/%s'", vmKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud-init files: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var files []CloudInitFileInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("failed to decode cloud-init files response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d cloud-init file(s) for VM %s", len(files), vmKey)
+	return files, nil
+}
+
+// FindCloudInitFiles returns the cloud-init files of every VM that are named name, with their contents
+func (va *VMApi) FindCloudInitFiles(ctx context.Context, name string) ([]CloudInitFileInfo, error) {
+	apiResp, err := va.client.Get(CloudInitFileEndpoint, &Options{
+		Fields: "$key,owner,name,contents",
+		Filter: fmt.Sprintf("name eq '%s'", name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud-init files: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var files []CloudInitFileInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("failed to decode cloud-init files response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d cloud-init file(s) named %s", len(files), name)
+	return files, nil
+}
+
+// CreateCloudInitFile stores a cloud-init file for the VM
+func (va *VMApi) CreateCloudInitFile(ctx context.Context, vmKey string, file CloudInitFileAPI) error {
+	encodedBuffer := new(bytes.Buffer)
+	if err := json.NewEncoder(encodedBuffer).Encode(map[string]interface{}{
+		"owner":    "vms/" + vmKey,
+		"name":     file.Name,
+		"contents": file.Contents,
+	}); err != nil {
+		return fmt.Errorf("failed to encode cloud-init file: %w", err)
+	}
+
+	apiResp, err := va.client.Post(CloudInitFileEndpoint, encodedBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to create cloud-init file %s: %w", file.Name, err)
+	}
+	if apiResp == nil {
+		return errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 201 {
+		return fmt.Errorf("VergeIO API returned status code %d for cloud-init file creation", apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Created cloud-init file %s for VM %s", file.Name, vmKey)
+	return nil
+}
+
+// DeleteCloudInitFile removes a stored cloud-init file
+func (va *VMApi) DeleteCloudInitFile(ctx context.Context, fileKey int) error {
+	apiResp, err := va.client.Delete(fmt.Sprintf("%s/%d", CloudInitFileEndpoint, fileKey))
+	if err != nil {
+		return fmt.Errorf("error deleting cloud-init file %d: %w", fileKey, err)
+	}
+	if apiResp == nil {
+		return fmt.Errorf("no response received when deleting cloud-init file %d", fileKey)
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete cloud-init file %d, status code: %d", fileKey, apiResp.StatusCode)
+	}
+
+	log.Printf("[VergeIO]: Deleted cloud-init file %d", fileKey)
+	return nil
+}
+
+// ShutdownVM sends an ACPI power-button event and waits until the guest has powered off.
+func (va *VMApi) ShutdownVM(ctx context.Context, vmKey string) error {
+	log.Printf("[VergeIO]: Requesting graceful shutdown for VM Key %s", vmKey)
+
+	if _, err := va.postVMAction(vmKey, "poweroff", nil); err != nil {
 		return err
 	}
 
-	req, err := va.client.Post(VMActionEndpoint, bytes.NewBuffer(bytedata))
+	return va.WaitForPowerState(ctx, vmKey, false)
+}
+
+// WaitForPowerState polls the VM power state until it matches running or ctx ends.
+func (va *VMApi) WaitForPowerState(ctx context.Context, vmKey string, running bool) error {
+	description := fmt.Sprintf("VM %s to power off", vmKey)
+	if running {
+		description = fmt.Sprintf("VM %s to power on", vmKey)
+	}
+
+	return waitFor(ctx, va.pollInterval(), description, func() (bool, error) {
+		isRunning, err := va.IsVMRunning(ctx, vmKey)
+		if err != nil {
+			// A single failed read shouldn't end the wait, ctx bounds how long we keep trying
+			log.Printf("[VergeIO]: Failed to read power state for VM %s, retrying: %v", vmKey, err)
+			return false, nil
+		}
+		return (isRunning != nil && *isRunning) == running, nil
+	})
+}
+
+// ResetVM hard-resets the VM and waits until it is running again.
+func (va *VMApi) ResetVM(ctx context.Context, vmKey string) error {
+	log.Printf("[VergeIO]: Resetting VM Key %s", vmKey)
+
+	before, err := va.GetVMStatus(ctx, vmKey)
 	if err != nil {
 		return err
 	}
-	if req.StatusCode != 201 {
-		return fmt.Errorf("failed to change the VM power state: status code %v", req.StatusCode)
+
+	if _, err := va.postVMAction(vmKey, "reset", nil); err != nil {
+		return err
+	}
+
+	return va.waitForVMAction(ctx, vmKey, "come back after reset", *before, func(status *VMStatus) bool {
+		return status.Running && !transitionalStatuses[strings.ToLower(status.Status)]
+	})
+}
+
+// CloneVM clones the VM under a new name and returns the key of the clone once it exists.
+func (va *VMApi) CloneVM(ctx context.Context, vmKey string, name string) (string, error) {
+	log.Printf("[VergeIO]: Cloning VM Key %s as '%s'", vmKey, name)
+
+	// Remember VMs that already carry the name so the clone can be told apart from them
+	existing, err := va.FindVMsByPrefix(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	seen := make(map[int]bool)
+	for _, vm := range existing {
+		seen[vm.Key] = true
+	}
+
+	if _, err := va.postVMAction(vmKey, "clone", &VMActionParams{Name: name}); err != nil {
+		return "", err
+	}
+
+	var clone VMSummary
+	err = waitFor(ctx, va.pollInterval(), fmt.Sprintf("clone '%s' of VM %s", name, vmKey), func() (bool, error) {
+		vms, err := va.FindVMsByPrefix(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		for _, vm := range vms {
+			if vm.Name == name && !seen[vm.Key] {
+				clone = vm
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	cloneKey := strconv.Itoa(clone.Key)
+
+	// The clone may already be done by the time it shows up, its status then changed after it was created
+	if err := va.waitForVMAction(ctx, cloneKey, "finish cloning", VMStatus{Changed: clone.Created}, func(status *VMStatus) bool {
+		return !transitionalStatuses[strings.ToLower(status.Status)]
+	}); err != nil {
+		return "", err
+	}
+
+	log.Printf("[VergeIO]: VM %s cloned to VM Key %s", vmKey, cloneKey)
+	return cloneKey, nil
+}
+
+// SnapshotVM takes a machine snapshot of the VM and returns the snapshot key.
+// A zero retention keeps the snapshot until it is deleted.
+func (va *VMApi) SnapshotVM(ctx context.Context, vmKey string, name string, retention time.Duration, quiesce bool) (string, error) {
+	log.Printf("[VergeIO]: Taking snapshot '%s' of VM Key %s", name, vmKey)
+
+	vm, err := va.GetVM(ctx, vmKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Snapshots of the same name may exist already, only a new key is the one taken here
+	existing, err := va.GetVMSnapshots(ctx, vm.Machine, name)
+	if err != nil {
+		return "", err
+	}
+	seen := make(map[int]bool)
+	for _, snapshot := range existing {
+		seen[snapshot.Key] = true
+	}
+
+	params := &VMActionParams{
+		Name:      name,
+		Retention: int64(retention.Seconds()),
+		Quiesce:   quiesce,
+	}
+	if _, err := va.postVMAction(vmKey, "snapshot", params); err != nil {
+		return "", err
+	}
+
+	var snapshotKey string
+	err = waitFor(ctx, va.pollInterval(), fmt.Sprintf("snapshot '%s' of VM %s", name, vmKey), func() (bool, error) {
+		snapshots, err := va.GetVMSnapshots(ctx, vm.Machine, name)
+		if err != nil {
+			return false, err
+		}
+		for _, snapshot := range snapshots {
+			if !seen[snapshot.Key] {
+				snapshotKey = strconv.Itoa(snapshot.Key)
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[VergeIO]: Snapshot '%s' of VM %s created with key %s", name, vmKey, snapshotKey)
+	return snapshotKey, nil
+}
+
+// GetVMSnapshots lists the snapshots of a machine, optionally filtered by name, oldest first.
+func (va *VMApi) GetVMSnapshots(ctx context.Context, machine int, name string) ([]VMSnapshotInfo, error) {
+	filter := fmt.Sprintf("machine eq %d", machine)
+	if name != "" {
+		filter = fmt.Sprintf("%s and name eq '%s'", filter, name)
+	}
+
+	apiResp, err := va.client.Get(VMSnapshotEndpoint, &Options{
+		Fields: "$key,machine,name,description,created,expires",
+		Filter: filter,
+		Sort:   "+created",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VM snapshots: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var snapshots []VMSnapshotInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&snapshots); err != nil {
+		return nil, fmt.Errorf("failed to decode VM snapshots response: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a machine snapshot
+func (va *VMApi) DeleteSnapshot(ctx context.Context, snapshotKey string) error {
+	log.Printf("[VergeIO]: Deleting snapshot %s", snapshotKey)
+
+	apiResp, err := va.client.Delete(fmt.Sprintf("%s/%s", VMSnapshotEndpoint, url.PathEscape(snapshotKey)))
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot %s: %w", snapshotKey, err)
+	}
+	if apiResp == nil {
+		return fmt.Errorf("no response received when deleting snapshot %s", snapshotKey)
+	}
+	if apiResp.StatusCode != 200 && apiResp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete snapshot %s, status code: %d", snapshotKey, apiResp.StatusCode)
 	}
 
+	log.Printf("[VergeIO]: Successfully deleted snapshot %s", snapshotKey)
 	return nil
 }
 
+// GetSnapshotProfileByName retrieves the single snapshot profile with the given name.
+func (va *VMApi) GetSnapshotProfileByName(ctx context.Context, name string) (*SnapshotProfileInfo, error) {
+	apiResp, err := va.client.Get(SnapshotProfileEndpoint, &Options{
+		Fields: "$key,name,description",
+		Filter: fmt.Sprintf("name eq '%s'", name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot profiles: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var profiles []SnapshotProfileInfo
+	if err := json.NewDecoder(apiResp.Body).Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot profiles response: %w", err)
+	}
+
+	switch len(profiles) {
+	case 0:
+		return nil, fmt.Errorf("snapshot profile with name '%s' not found", name)
+	case 1:
+		return &profiles[0], nil
+	}
+
+	keys := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		keys = append(keys, strconv.Itoa(profile.Key))
+	}
+	return nil, fmt.Errorf("snapshot profile name '%s' is ambiguous: %d profiles match (keys %s)", name, len(profiles), strings.Join(keys, ", "))
+}
+
+// RestoreSnapshot rolls the VM back to the given snapshot and waits for the restore to finish.
+// The VM must be powered off.
+func (va *VMApi) RestoreSnapshot(ctx context.Context, vmKey string, snapshotKey string) error {
+	log.Printf("[VergeIO]: Restoring VM Key %s from snapshot %s", vmKey, snapshotKey)
+
+	before, err := va.GetVMStatus(ctx, vmKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := va.postVMAction(vmKey, "restore", &VMActionParams{Snapshot: snapshotKey}); err != nil {
+		return err
+	}
+
+	return va.waitForVMAction(ctx, vmKey, "finish restoring", *before, func(status *VMStatus) bool {
+		return !transitionalStatuses[strings.ToLower(status.Status)]
+	})
+}
+
+// MigrateVM live-migrates a running VM to the node with the given key.
+func (va *VMApi) MigrateVM(ctx context.Context, vmKey string, nodeKey string) error {
+	log.Printf("[VergeIO]: Migrating VM Key %s to node %s", vmKey, nodeKey)
+
+	if _, err := va.postVMAction(vmKey, "migrate", &VMActionParams{PreferredNode: nodeKey}); err != nil {
+		return err
+	}
+
+	return va.waitForVMStatus(ctx, vmKey, fmt.Sprintf("migrate to node %s", nodeKey), func(status *VMStatus) bool {
+		return status.Node.String() == nodeKey && !transitionalStatuses[strings.ToLower(status.Status)]
+	})
+}
+
 func (va *VMApi) readVM(data *VMAPIResourceModel) error {
 	log.Printf("[Vergeio]: Reading the vm data")
 
 	apiResp, err := va.client.Get(fmt.Sprintf("%s/%s",
 		VMEndpoint,
 		url.PathEscape(data.Id),
-	), &Options{Fields: "id,machine,name,cluster,description,enabled,machine_type,allow_hotplug,disable_powercycle,cpu_cores,cpu_type,ram,console,display,video,sound,os_family,os_description,rtc_base,boot_order,console_pass_enabled,console_pass,usb_tablet,uefi,secure_boot,serial_port,boot_delay,preferred_node,snapshot_profile,cloudinit_datasource,ha_group,guest_agent,advanced,nested_virtualization,disable_hypervisor,machine#status#running as powerstate"})
+	), &Options{Fields: vmFullFields})
 
 	if err != nil {
 		return err
@@ -690,6 +1279,82 @@ func (va *VMApi) GetVMs(ctx context.Context, filterName string, filterId int, is
 	return vms, nil
 }
 
+// FindVMsByPrefix lists the VMs whose name starts with prefix, snapshots excluded, oldest first.
+func (va *VMApi) FindVMsByPrefix(ctx context.Context, prefix string) ([]VMSummary, error) {
+	apiResp, err := va.client.Get(VMEndpoint, &Options{
+		Fields: "$key,machine,name,description,created",
+		Filter: fmt.Sprintf("name bw '%s' and is_snapshot eq false", prefix),
+		Sort:   "+created",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VMs: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var vms []VMSummary
+	if err := json.NewDecoder(apiResp.Body).Decode(&vms); err != nil {
+		return nil, fmt.Errorf("failed to decode VMs response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d VM(s) named '%s*'", len(vms), prefix)
+	return vms, nil
+}
+
+// FindVMsByHAGroup lists the VMs of an HA group, snapshots excluded.
+func (va *VMApi) FindVMsByHAGroup(ctx context.Context, group string) ([]VMSummary, error) {
+	apiResp, err := va.client.Get(VMEndpoint, &Options{
+		Fields: "$key,machine,name,description,created",
+		Filter: fmt.Sprintf("ha_group eq '%s' and is_snapshot eq false", group),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VMs: %w", err)
+	}
+	if apiResp == nil {
+		return nil, errors.New("missing response from VergeIO API")
+	}
+	if apiResp.StatusCode != 200 {
+		return nil, fmt.Errorf("VergeIO API returned status code %d", apiResp.StatusCode)
+	}
+
+	var vms []VMSummary
+	if err := json.NewDecoder(apiResp.Body).Decode(&vms); err != nil {
+		return nil, fmt.Errorf("failed to decode VMs response: %w", err)
+	}
+
+	log.Printf("[VergeIO]: Found %d VM(s) in HA group '%s'", len(vms), group)
+	return vms, nil
+}
+
+// GetVMByName retrieves the single VM with the given name, snapshots excluded.
+func (va *VMApi) GetVMByName(ctx context.Context, name string) (*VMInfo, error) {
+	vms, err := va.GetVMs(ctx, name, 0, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM by name '%s': %w", name, err)
+	}
+
+	var matches []VMInfo
+	var keys []string
+	for _, vm := range vms {
+		if !vm.IsSnapshot {
+			matches = append(matches, vm)
+			keys = append(keys, strconv.Itoa(int(vm.Key)))
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("VM with name '%s' not found", name)
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, fmt.Errorf("VM name '%s' is ambiguous: %d VMs match (keys %s)", name, len(matches), strings.Join(keys, ", "))
+}
+
 // isLoopbackIP checks if an IP address is a loopback address
 // This includes 127.0.0.1, ::1, and any address in the 127.0.0.0/8 range
 func isLoopbackIP(ipStr string) bool {
@@ -0,0 +1,81 @@
+package vergeio
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMActionWait(t *testing.T) {
+	settled := func(status *VMStatus) bool {
+		return !transitionalStatuses[strings.ToLower(status.Status)]
+	}
+	runningAgain := func(status *VMStatus) bool {
+		return status.Running && settled(status)
+	}
+
+	cases := []struct {
+		name     string
+		before   VMStatus
+		done     func(*VMStatus) bool
+		sequence []VMStatus
+		finishAt int
+	}{
+		{
+			name:   "restore of a powered-off VM",
+			before: VMStatus{Status: "stopped", Changed: 100},
+			done:   settled,
+			sequence: []VMStatus{
+				{Status: "stopped", Changed: 100},
+				{Status: "stopped", Changed: 100},
+				{Status: "restoring", Changed: 105},
+				{Status: "restoring", Changed: 105},
+				{Status: "stopped", Changed: 130},
+			},
+			finishAt: 4,
+		},
+		{
+			name:   "reset that is never seen resetting",
+			before: VMStatus{Running: true, Status: "running", Changed: 100},
+			done:   runningAgain,
+			sequence: []VMStatus{
+				{Running: true, Status: "running", Changed: 100},
+				{Running: true, Status: "running", Changed: 112},
+			},
+			finishAt: 1,
+		},
+		{
+			name:   "clone that finished before it was first read",
+			before: VMStatus{Changed: 100},
+			done:   settled,
+			sequence: []VMStatus{
+				{Status: "stopped", Changed: 101},
+			},
+			finishAt: 0,
+		},
+		{
+			name:   "action that never starts",
+			before: VMStatus{Status: "stopped", Changed: 100},
+			done:   settled,
+			sequence: []VMStatus{
+				{Status: "stopped", Changed: 100},
+				{Status: "stopped", Changed: 100},
+			},
+			finishAt: -1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait := &vmActionWait{before: tc.before, done: tc.done}
+			for i := range tc.sequence {
+				finished := wait.finished(&tc.sequence[i])
+				if finished != (i == tc.finishAt) {
+					t.Fatalf("read %d (%+v): got finished=%v", i, tc.sequence[i], finished)
+				}
+				if finished {
+					return
+				}
+			}
+		})
+	}
+}
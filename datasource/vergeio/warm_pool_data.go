@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc mapstructure-to-hcl2 -type WarmPoolConfig,WarmPoolEntry,WarmPoolOutput
+package vergeio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/hcl2helper"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	builder "github.com/verge-io/packer-plugin-vergeio/builder/vergeio"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+	"github.com/zclconf/go-cty/cty"
+)
+
+type WarmPoolConfig struct {
+	// VergeIO connection configuration
+	Username string `mapstructure:"vergeio_username" required:"true"`
+	Password string `mapstructure:"vergeio_password" required:"true"`
+	Endpoint string `mapstructure:"vergeio_endpoint" required:"true"`
+	Port     int    `mapstructure:"vergeio_port" required:"false"`
+	Insecure bool   `mapstructure:"vergeio_insecure" required:"false"`
+}
+
+// WarmPoolDataSource lists the entries of the builder's warm pool
+type WarmPoolDataSource struct {
+	config WarmPoolConfig
+}
+
+type WarmPoolEntry struct {
+	Name        string `mapstructure:"name"`
+	Key         int    `mapstructure:"key"`
+	PoolKey     string `mapstructure:"pool_key"`
+	Description string `mapstructure:"description"`
+	Created     string `mapstructure:"created"`
+}
+
+type WarmPoolOutput struct {
+	Entries []WarmPoolEntry `mapstructure:"entries"`
+}
+
+func (d *WarmPoolDataSource) ConfigSpec() hcldec.ObjectSpec {
+	return d.config.FlatMapstructure().HCL2Spec()
+}
+
+func (d *WarmPoolDataSource) Configure(raws ...interface{}) error {
+	err := config.Decode(&d.config, nil, raws...)
+	if err != nil {
+		return err
+	}
+
+	// Set defaults
+	if d.config.Port == 0 {
+		d.config.Port = 443
+	}
+
+	// Validate required fields
+	if d.config.Username == "" {
+		return fmt.Errorf("vergeio_username is required")
+	}
+	if d.config.Password == "" {
+		return fmt.Errorf("vergeio_password is required")
+	}
+	if d.config.Endpoint == "" {
+		return fmt.Errorf("vergeio_endpoint is required")
+	}
+
+	return nil
+}
+
+func (d *WarmPoolDataSource) OutputSpec() hcldec.ObjectSpec {
+	return (&WarmPoolOutput{}).FlatMapstructure().HCL2Spec()
+}
+
+func (d *WarmPoolDataSource) Execute() (cty.Value, error) {
+	vergeClient := client.NewClient(d.config.Endpoint, d.config.Username, d.config.Password, d.config.Insecure)
+	vmAPI := client.NewVMApi(vergeClient)
+
+	vms, err := vmAPI.FindVMsByPrefix(context.Background(), builder.WarmPoolPrefix)
+	if err != nil {
+		return cty.NilVal, fmt.Errorf("failed to list the warm pool: %w", err)
+	}
+
+	output := WarmPoolOutput{Entries: []WarmPoolEntry{}}
+	for _, vm := range vms {
+		output.Entries = append(output.Entries, WarmPoolEntry{
+			Name:        vm.Name,
+			Key:         vm.Key,
+			PoolKey:     strings.TrimPrefix(vm.Name, builder.WarmPoolPrefix),
+			Description: vm.Description,
+			Created:     time.Unix(vm.Created, 0).UTC().Format(time.RFC3339),
+		})
+	}
+
+	log.Printf("[VergeIO Warm Pool DataSource]: Found %d warm pool entries", len(output.Entries))
+	return hcl2helper.HCL2ValueFromConfig(output, d.OutputSpec()), nil
+}
@@ -129,11 +129,10 @@ source "vergeio" "example" {
     files = ["cloud-init/user-data.yml"]
   }
 
-  # Instance metadata
+  # Instance metadata, a unique instance-id is added for every build
   cloud_init_files {
     name     = "meta-data"
     contents = <<-EOF
-      instance-id: packer-example-vm
       local-hostname: packer-example-vm
     EOF
   }
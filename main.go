@@ -24,10 +24,12 @@ func main() {
 	pps := plugin.NewSet()
 	pps.RegisterBuilder(plugin.DEFAULT_NAME, new(vergeio.Builder))
 	pps.RegisterProvisioner("my-provisioner", new(vergeioProv.Provisioner))
+	pps.RegisterProvisioner("checkpoint", new(vergeioProv.CheckpointProvisioner))
 	pps.RegisterPostProcessor("my-post-processor", new(vergeioPP.PostProcessor))
 	pps.RegisterDatasource("my-datasource", new(vergeioData.Datasource))
 	pps.RegisterDatasource("networks", new(vergeioData.NetworkDataSource))
 	pps.RegisterDatasource("vms", new(vergeioData.VMDataSource))
+	pps.RegisterDatasource("warm-pool", new(vergeioData.WarmPoolDataSource))
 	pps.SetVersion(vergeioVersion.PluginVersion)
 	err := pps.Run()
 	if err != nil {
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc mapstructure-to-hcl2 -type CheckpointConfig
+
+package vergeio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	builder "github.com/verge-io/packer-plugin-vergeio/builder/vergeio"
+	client "github.com/verge-io/packer-plugin-vergeio/client"
+)
+
+type CheckpointConfig struct {
+	// VergeIO connection configuration
+	Username string `mapstructure:"vergeio_username" required:"true"`
+	Password string `mapstructure:"vergeio_password" required:"true"`
+	Endpoint string `mapstructure:"vergeio_endpoint" required:"true"`
+	Insecure bool   `mapstructure:"vergeio_insecure" required:"false"`
+
+	// Name identifies the checkpoint, a resumed build skips the provisioners up to the one that
+	// took the restored checkpoint
+	Name string `mapstructure:"name" required:"true"`
+	// Retention is how long the snapshot is kept if the build never finishes. Default: 24h
+	Retention time.Duration `mapstructure:"retention" required:"false"`
+	// Quiesce freezes the guest filesystems through the guest agent while the snapshot is taken
+	Quiesce bool `mapstructure:"quiesce" required:"false"`
+
+	ctx interpolate.Context
+}
+
+// CheckpointProvisioner snapshots the VM of a vergeio build between provisioners, so a
+// failed build can be resumed with resume_from_checkpoint
+type CheckpointProvisioner struct {
+	config CheckpointConfig
+}
+
+func (p *CheckpointProvisioner) ConfigSpec() hcldec.ObjectSpec {
+	return p.config.FlatMapstructure().HCL2Spec()
+}
+
+func (p *CheckpointProvisioner) Prepare(raws ...interface{}) error {
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         "packer.provisioner.vergeio-checkpoint",
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packer.MultiError
+	if p.config.Endpoint == "" || p.config.Username == "" || p.config.Password == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("vergeio_endpoint, vergeio_username and vergeio_password are required"))
+	}
+	if p.config.Name == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("name is required"))
+	} else if strings.Contains(p.config.Name, ",") {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("name: must not contain a comma, got %q", p.config.Name))
+	}
+	if p.config.Retention < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("retention: must not be negative, got %v", p.config.Retention))
+	}
+	if p.config.Retention == 0 {
+		p.config.Retention = 24 * time.Hour
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func (p *CheckpointProvisioner) Provision(ctx context.Context, ui packer.Ui, comm packer.Communicator, generatedData map[string]interface{}) error {
+	// The vergeio builder sets these, other builders leave ID unimplemented
+	vmKey, _ := generatedData["ID"].(string)
+	prefix, _ := generatedData["CheckpointPrefix"].(string)
+	if vmKey == "" || prefix == "" || strings.HasPrefix(vmKey, "ERR_") {
+		return errors.New("checkpoints only work with the vergeio builder")
+	}
+
+	// The builder answers the marker itself, on a resumed build it ends skipping the
+	// provisioners once the restored checkpoint is reached
+	if comm != nil {
+		marker := &packer.RemoteCmd{Command: builder.CheckpointMarkerCommand + " " + p.config.Name}
+		if err := marker.RunWithUi(ctx, comm, ui); err != nil {
+			return fmt.Errorf("checkpoint '%s' failed: %w", p.config.Name, err)
+		}
+	}
+
+	completed, _ := generatedData["CompletedCheckpoints"].(string)
+	if slices.Contains(strings.Split(completed, ","), p.config.Name) {
+		ui.Say(fmt.Sprintf("Checkpoint '%s' was reached before the build was resumed - skipping", p.config.Name))
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Taking checkpoint '%s'...", p.config.Name))
+	c := client.NewClient(p.config.Endpoint, p.config.Username, p.config.Password, p.config.Insecure)
+	if _, err := client.NewVMApi(c).SnapshotVM(ctx, vmKey, prefix+p.config.Name, p.config.Retention, p.config.Quiesce); err != nil {
+		return fmt.Errorf("checkpoint '%s' failed: %w", p.config.Name, err)
+	}
+	ui.Say(fmt.Sprintf("Checkpoint '%s' taken", p.config.Name))
+	return nil
+}